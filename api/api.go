@@ -2,17 +2,40 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/api/routes/admin"
 	"github.com/nireo/go-blog-api/api/routes/auth"
+	"github.com/nireo/go-blog-api/api/routes/feeds"
 	"github.com/nireo/go-blog-api/api/routes/posts"
 	"github.com/nireo/go-blog-api/api/routes/topic"
+	"github.com/nireo/go-blog-api/api/routes/wellknown"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/config"
+	"github.com/nireo/go-blog-api/lib/identity"
+	"github.com/nireo/go-blog-api/lib/keys"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/mailer"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/storage"
 )
 
-// ApplyRoutes adds router to gin engine
-func ApplyRoutes(r *gin.Engine) {
+// ApplyRoutes adds router to gin engine. The route packages read and write their data
+// through the given stores, tokens are signed with the key set, emails are sent with the
+// mailer, users can also log in with the identity providers, the guard locks out
+// whoever is guessing passwords and uploaded avatars are kept in the storage.
+func ApplyRoutes(r *gin.Engine, cfg config.Config, keySet *keys.KeySet, stores store.Stores, mail mailer.Mailer, providers identity.Providers, guard *lockout.Tracker, uploads storage.Storage) {
+	wellknown.ApplyRoutes(r, keySet)
+
+	// the local storage's files are served by the app itself
+	if cfg.Storage.Driver == "local" {
+		r.Group("/uploads", middlewares.Uploads).Static("/", cfg.Storage.Directory)
+	}
+
 	routes := r.Group("/api")
 	{
-		auth.ApplyRoutes(routes)
-		posts.ApplyRoutes(routes)
-		topic.ApplyRoutes(routes)
+		auth.ApplyRoutes(routes, cfg, keySet, stores, mail, providers, guard, uploads)
+		posts.ApplyRoutes(routes, stores)
+		topic.ApplyRoutes(routes, stores)
+		feeds.ApplyRoutes(routes, stores, cfg.Server.PublicURL)
+		admin.ApplyRoutes(routes, stores, guard)
 	}
 }
@@ -0,0 +1,191 @@
+package admin
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
+)
+
+// User model alias
+type User = models.User
+
+// JSON type alias
+type JSON = common.JSON
+
+// serializeUser formats the user with the fields only admins see
+func serializeUser(user User) JSON {
+	serialized := user.Serialize()
+	serialized["suspended_at"] = user.SuspendedAt
+	return serialized
+}
+
+// findTarget finds the user with the url parameter and makes sure the current user can do
+// the action to them. The response has already been written when ok is false.
+func findTarget(c *gin.Context, action policy.Action) (User, bool) {
+	target, err := middlewares.Stores(c).Users.FindByURL(c.Param("url"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return target, false
+	}
+
+	if !policy.CanUser(middlewares.CurrentUser(c), action, target) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return target, false
+	}
+
+	return target, true
+}
+
+func getUsers(c *gin.Context) {
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	users, next, err := middlewares.Stores(c).Users.List(page)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	serialized := make([]JSON, len(users), len(users))
+	for index := range users {
+		serialized[index] = serializeUser(users[index])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       serialized,
+		"next_cursor": common.EncodeCursor(next),
+	})
+}
+
+// updateRole promotes or demotes a user
+func updateRole(c *gin.Context) {
+	type RequestBody struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	var body RequestBody
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if !models.ValidRole(body.Role) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": "Unknown role"})
+		return
+	}
+
+	target, ok := findTarget(c, policy.ChangeRole)
+	if !ok {
+		return
+	}
+
+	if err := middlewares.Stores(c).Users.UpdateRole(&target, body.Role); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializeUser(target))
+}
+
+// suspendUser blocks the user from logging in and ends their sessions
+func suspendUser(c *gin.Context) {
+	target, ok := findTarget(c, policy.Suspend)
+	if !ok {
+		return
+	}
+
+	stores := middlewares.Stores(c)
+	if !target.Suspended() {
+		if err := stores.Users.SetSuspended(&target, true); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := stores.RevokeSessions(&target); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializeUser(target))
+}
+
+// unsuspendUser lets a suspended user log in again
+func unsuspendUser(c *gin.Context) {
+	target, ok := findTarget(c, policy.Suspend)
+	if !ok {
+		return
+	}
+
+	if err := middlewares.Stores(c).Users.SetSuspended(&target, false); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializeUser(target))
+}
+
+// unlock lifts the lock on the key and writes it to the security log. The target is nil when
+// the key is an ip.
+func (h *handler) unlock(c *gin.Context, key string, target *User) error {
+	if _, locked := h.loginGuard.Locked(key); locked {
+		actor := middlewares.CurrentUser(c)
+		event := models.SecurityEvent{
+			Kind:      models.SecurityEventUnlock,
+			Subject:   key,
+			ActorID:   &actor.ID,
+			IPAddress: c.ClientIP(),
+		}
+
+		if target != nil {
+			event.UserID = &target.ID
+		}
+
+		if err := middlewares.Stores(c).SecurityEvents.Create(&event); err != nil {
+			return err
+		}
+	}
+
+	h.loginGuard.Reset(key)
+	return nil
+}
+
+// unlockUser lifts the lock from too many failed logins on the user's username
+func (h *handler) unlockUser(c *gin.Context) {
+	target, ok := findTarget(c, policy.Unlock)
+	if !ok {
+		return
+	}
+
+	if err := h.unlock(c, lockout.UserKey(target.Username), &target); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// unlockIP lifts the lock from too many failed logins on the client ip
+func (h *handler) unlockIP(c *gin.Context) {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.unlock(c, lockout.IPKey(ip.String()), nil); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
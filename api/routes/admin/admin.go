@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
+)
+
+// handler holds what the admin routes need besides the stores
+type handler struct {
+	// loginGuard holds the lockouts admins can lift
+	loginGuard *lockout.Tracker
+}
+
+// ApplyRoutes adds admin routes to gin engine. Every route needs the manage users permission.
+func ApplyRoutes(r *gin.RouterGroup, stores store.Stores, guard *lockout.Tracker) {
+	h := &handler{loginGuard: guard}
+
+	admin := r.Group("/admin")
+	admin.Use(middlewares.InjectStores(stores), middlewares.Require(policy.ManageUsers))
+	{
+		admin.GET("/users", getUsers)
+		admin.PATCH("/users/:url/role", updateRole)
+		admin.POST("/users/:url/suspend", suspendUser)
+		admin.DELETE("/users/:url/suspend", unsuspendUser)
+		admin.DELETE("/users/:url/lockout", h.unlockUser)
+		admin.DELETE("/lockouts/ip/:ip", h.unlockIP)
+	}
+}
@@ -1,7 +1,7 @@
 package auth
 
 import (
-	"fmt"
+	"log"
 	"net/http"
 	"time"
 
@@ -9,21 +9,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/nireo/go-blog-api/database/models"
 	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/middlewares"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User model alias
 type User = models.User
 
-// Post model alias
-type Post = models.Post
-
-// Follow model alias
-type Follow = models.Follow
-
-// FollowedTopic alias
-type FollowedTopic = models.FollowedTopic
-
 // Topic model alias
 type Topic = models.Topic
 
@@ -36,6 +29,13 @@ type UserAction struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// RegisterRequest is the request definition used for the register controller. The email
+// address is optional, but a password can't be reset without a verified one.
+type RegisterRequest struct {
+	UserAction
+	Email string `json:"email" binding:"omitempty,email"`
+}
+
 // UpdateRequestBody is the request definiton used for the updateUser controller
 type UpdateRequestBody struct {
 	Username string `json:"username" binding:"required"`
@@ -51,27 +51,30 @@ func checkHash(password string, hash string) bool {
 	return err == nil
 }
 
-func generateToken(data common.JSON) (string, error) {
-	date := time.Now().Add(time.Hour * 24 * 7)
+// generateToken creates a short-lived access token. The token version and the session let
+// the server revoke it before it expires.
+func (h *handler) generateToken(user User, session Session) (string, error) {
+	date := time.Now().Add(h.tokenSettings.Expiry)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user": data,
+	return h.signingKeys.Sign(jwt.MapClaims{
+		"user": user.Claims(),
+		"ver":  user.TokenVersion,
+		"sid":  session.ID,
 		"exp":  date.Unix(),
 	})
-
-	tokenString, err := token.SignedString([]byte("temp"))
-	return tokenString, err
 }
 
-func register(c *gin.Context) {
-	var body UserAction
+func (h *handler) register(c *gin.Context) {
+	var body RegisterRequest
 	if err := c.BindJSON(&body); err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
+	stores := middlewares.Stores(c)
+
 	// check if user exists
-	_, err := models.FindOneUser(&User{Username: body.Username})
+	_, err := stores.Users.FindByUsername(body.Username)
 	if err == nil {
 		c.AbortWithStatus(http.StatusConflict)
 		return
@@ -88,58 +91,95 @@ func register(c *gin.Context) {
 		PasswordHash: hash,
 		UUID:         common.CreateUUID(),
 		URL:          common.FormatString(body.Username),
+		Role:         models.RoleUser,
 	}
 
-	// save to database
-	user.Save()
+	if body.Email != "" {
+		if _, err := stores.Users.FindByEmail(body.Email); err == nil {
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
 
-	serialized := user.Serialize()
-	token, err := generateToken(serialized)
+		user.Email = &body.Email
+	}
+
+	// save to database, the url can still be taken by a similar username
+	if err := stores.Users.Create(&user); err != nil {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	// the account works without a verified address, so a failed email only gets logged
+	if user.Email != nil {
+		if err := h.sendVerification(user); err != nil {
+			log.Println("Failed to send verification email to user", user.UUID, err)
+		}
+	}
+
+	tokens, err := h.issueTokens(c, stores, user)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	c.JSON(http.StatusOK, common.JSON{
-		"user":  serialized,
-		"token": token,
-	})
+	c.JSON(http.StatusOK, tokens)
 }
 
-func login(c *gin.Context) {
+func (h *handler) login(c *gin.Context) {
 	var body UserAction
 	if err := c.BindJSON(&body); err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	user, err := models.FindOneUser(&User{Username: body.Username})
+	if h.waitForLogin(c, body.Username) {
+		return
+	}
+
+	stores := middlewares.Stores(c)
+	user, err := stores.Users.FindByUsername(body.Username)
 	if err != nil {
+		h.failLogin(c, stores, body.Username, nil)
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
 	if !checkHash(body.Password, user.PasswordHash) {
+		h.failLogin(c, stores, body.Username, &user)
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	serialized := user.Serialize()
-	token, err := generateToken(serialized)
+	if user.Suspended() {
+		c.AbortWithStatusJSON(http.StatusForbidden, JSON{"error": "Account suspended"})
+		return
+	}
+
+	// the failures are only forgotten after the second factor, so the password can't be used
+	// to reset the counter while guessing codes
+	if user.TwoFactorEnabled() {
+		challenge, err := h.challengeResponse(user)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
 
+	h.loginGuard.Reset(lockout.UserKey(user.Username))
+	tokens, err := h.issueTokens(c, stores, user)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	c.JSON(http.StatusOK, common.JSON{
-		"user":  serialized,
-		"token": token,
-	})
+	c.JSON(http.StatusOK, tokens)
 }
 
 func updateUser(c *gin.Context) {
-	db := common.GetDatabase()
+	stores := middlewares.Stores(c)
 	user := c.MustGet("user").(User)
 
 	type RequestBody struct {
@@ -152,35 +192,39 @@ func updateUser(c *gin.Context) {
 		return
 	}
 
-	_, err := models.FindOneUser(&User{Username: body.Username})
-	if err != nil {
+	_, err := stores.Users.FindByUsername(body.Username)
+	if err == nil {
 		c.AbortWithStatus(http.StatusConflict)
 		return
 	}
 
-	user.Username = body.Username
+	if err := stores.Users.UpdateUsername(&user, body.Username); err != nil {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
 
-	db.Save(&user)
 	c.JSON(http.StatusOK, user.Serialize())
 }
 
-func remove(c *gin.Context) {
-	db := common.GetDatabase()
+func (h *handler) remove(c *gin.Context) {
+	stores := middlewares.Stores(c)
 	user := c.MustGet("user").(User)
 
-	var posts []Post
-	db.Model(&user).Related(&posts)
+	if err := stores.RevokeSessions(&user); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
-	for index := range posts {
-		db.Delete(&posts[index])
+	if err := stores.Users.Delete(&user); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
 
-	user.Delete()
+	h.removeAvatar(user, user.AvatarKey)
 	c.Status(http.StatusOK)
 }
 
-func changePassword(c *gin.Context) {
-	db := common.GetDatabase()
+func (h *handler) changePassword(c *gin.Context) {
 	user := c.MustGet("user").(User)
 
 	type RequestBody struct {
@@ -193,60 +237,89 @@ func changePassword(c *gin.Context) {
 		return
 	}
 
-	user.SetPassword(body.Password)
+	hash, err := hash(body.Password)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
-	db.Save(&user)
-	c.Status(http.StatusOK)
+	stores := middlewares.Stores(c)
+	if err := stores.Users.UpdatePassword(&user, hash); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// the old tokens might have been stolen, so they stop working and the user gets new ones
+	if err := stores.RevokeSessions(&user); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := h.issueTokens(c, stores, user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
 }
 
 func getUserWithUsername(c *gin.Context) {
-	db := common.GetDatabase()
 	url := c.Param("url")
 
-	displayFollowing := true
-	userRaw, ok := c.Get("user")
-	if !ok {
-		displayFollowing = false
-	}
+	// personal access tokens without the posts:read scope don't get to see the user's drafts
+	currentUser := middlewares.CurrentUser(c)
+	displayFollowing := currentUser != nil
 
 	var toCheckFollowing User
 	if displayFollowing {
-		toCheckFollowing = userRaw.(User)
+		toCheckFollowing = *currentUser
+	}
+
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
 	}
 
-	user, err := models.FindOneUser(&User{URL: url})
+	stores := middlewares.Stores(c)
+	user, err := stores.Users.FindByURL(url)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	var posts []Post
-	if err := db.Model(&user).Related(&posts).Error; err != nil {
+	// authors see all of their own posts, everyone else only sees the published ones
+	onlyPublished := !displayFollowing || toCheckFollowing.ID != user.ID
+	posts, next, err := stores.Posts.ListByUser(user, page, onlyPublished)
+	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
 	if displayFollowing {
+		following, _ := stores.Follows.IsFollowing(toCheckFollowing, user)
 		c.JSON(http.StatusOK, gin.H{
-			"user":      user.Serialize(),
-			"posts":     models.SerializePosts(posts),
-			"following": toCheckFollowing.IsFollowing(user),
+			"user":        user.Serialize(),
+			"posts":       stores.Posts.Serialize(posts, &toCheckFollowing),
+			"following":   following,
+			"next_cursor": common.EncodeCursor(next),
 		})
 	} else {
 		c.JSON(http.StatusOK, gin.H{
-			"user":  user.Serialize(),
-			"posts": models.SerializePosts(posts),
+			"user":        user.Serialize(),
+			"posts":       stores.Posts.Serialize(posts, nil),
+			"next_cursor": common.EncodeCursor(next),
 		})
 	}
 }
 
 func followUser(c *gin.Context) {
-	db := common.GetDatabase()
+	stores := middlewares.Stores(c)
 	user := c.MustGet("user").(User)
 	toFollowUsername := c.Param("username")
 
-	fmt.Println(toFollowUsername)
-	userToFollow, err := models.FindOneUser(&User{Username: toFollowUsername})
+	userToFollow, err := stores.Users.FindByUsername(toFollowUsername)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
@@ -254,21 +327,16 @@ func followUser(c *gin.Context) {
 
 	// check if the user is already following the toFollowUser this isn't really necessary,
 	// but it prevents the database from storing multiple records of the same information
-	if user.IsFollowing(userToFollow) {
+	if following, _ := stores.Follows.IsFollowing(user, userToFollow); following {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	newFollow := Follow{
-		Following:    userToFollow,
-		FollowingID:  userToFollow.ID,
-		FollowedBy:   user,
-		FollowedByID: user.ID,
+	if err := stores.Follows.Follow(user, userToFollow); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
 
-	db.NewRecord(newFollow)
-	db.Create(&newFollow)
-
 	c.Status(http.StatusNoContent)
 }
 
@@ -276,13 +344,14 @@ func unFollowUser(c *gin.Context) {
 	user := c.MustGet("user").(User)
 	toUnFollowUsername := c.Param("username")
 
-	toUnFollowUser, err := models.FindOneUser(&User{Username: toUnFollowUsername})
+	stores := middlewares.Stores(c)
+	toUnFollowUser, err := stores.Users.FindByUsername(toUnFollowUsername)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	if err := user.UnFollow(toUnFollowUser); err != nil {
+	if err := stores.Follows.Unfollow(user, toUnFollowUser); err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
@@ -291,40 +360,41 @@ func unFollowUser(c *gin.Context) {
 }
 
 func followTopic(c *gin.Context) {
-	db := common.GetDatabase()
+	stores := middlewares.Stores(c)
 	user := c.MustGet("user").(User)
-	topicID := c.Param("topicID")
+	topicURL := c.Param("topicURL")
 
-	topic, err := models.FindOneTopic(&Topic{UUID: topicID})
+	topic, err := stores.Topics.FindByURL(topicURL)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	newTopicFollow := FollowedTopic{
-		User:          user,
-		UserID:        user.ID,
-		FollowedTopic: topic,
-		TopicID:       topic.ID,
+	if following, _ := stores.Follows.IsFollowingTopic(user, topic); following {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
 	}
 
-	db.NewRecord(newTopicFollow)
-	db.Save(&newTopicFollow)
+	if err := stores.Follows.FollowTopic(user, topic); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
 	c.Status(http.StatusNoContent)
 }
 
 func unFollowTopic(c *gin.Context) {
 	user := c.MustGet("user").(User)
-	topicID := c.Param("topicID")
+	topicURL := c.Param("topicURL")
 
-	topic, err := models.FindOneTopic(&Topic{UUID: topicID})
+	stores := middlewares.Stores(c)
+	topic, err := stores.Topics.FindByURL(topicURL)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	if err := user.UnFollowTopic(topic); err != nil {
+	if err := stores.Follows.UnfollowTopic(user, topic); err != nil {
 		// not found status since a follow relationship has not been found
 		c.AbortWithStatus(http.StatusNotFound)
 		return
@@ -333,35 +403,46 @@ func unFollowTopic(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// followedPage is combination of getFollowedTopics and getFollowedUsers
+// followedPage is combination of getFollowedTopics and getFollowedUsers.
+// users and topics are paginated separately with the 'users_cursor' and 'topics_cursor' parameters
 func followedPage(c *gin.Context) {
-	db := common.GetDatabase()
 	user := c.MustGet("user").(User)
 
-	var followedUsers []models.Follow
-	if err := db.Where(&Follow{FollowedByID: user.ID}).Find(&followedUsers).Error; err != nil {
+	userPage, err := common.ParsePage(c, "users")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	topicPage, err := common.ParsePage(c, "topics")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	stores := middlewares.Stores(c)
+	followedUsers, nextUsers, err := stores.Follows.FollowedUsers(user, userPage)
+	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
+	// every user is wrapped in an object, like the follows used to be serialized
 	serializedUsers := make([]JSON, len(followedUsers), len(followedUsers))
 	for index := range followedUsers {
-		serializedUsers[index] = followedUsers[index].Serialize()
+		serializedUsers[index] = JSON{"user": followedUsers[index].Serialize()}
 	}
 
-	var followedTopics []FollowedTopic
-	if err := db.Where(FollowedTopic{UserID: user.ID}).Find(&followedTopics).Error; err != nil {
+	followedTopics, nextTopics, err := stores.Follows.FollowedTopics(user, topicPage)
+	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	serializedTopics := make([]JSON, len(followedTopics), len(followedTopics))
-	for index := range followedTopics {
-		serializedTopics[index] = followedTopics[index].FollowedTopic.Serialize()
-	}
-
 	c.JSON(http.StatusOK, JSON{
-		"followedTopics": serializedTopics,
-		"followedUsers":  serializedUsers,
+		"followedTopics":     models.SerializeTopics(followedTopics),
+		"followedUsers":      serializedUsers,
+		"next_users_cursor":  common.EncodeCursor(nextUsers),
+		"next_topics_cursor": common.EncodeCursor(nextTopics),
 	})
 }
@@ -2,17 +2,71 @@ package auth
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/config"
+	"github.com/nireo/go-blog-api/lib/identity"
+	"github.com/nireo/go-blog-api/lib/keys"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/mailer"
 	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
+	"github.com/nireo/go-blog-api/lib/storage"
 )
 
+// handler holds what the auth routes need besides the stores
+type handler struct {
+	// tokenSettings configure the tokens given out on login and registration
+	tokenSettings config.JWT
+
+	// signingKeys sign the access tokens, the login challenges and the tokens sent by email
+	signingKeys *keys.KeySet
+
+	// mailSettings configure the links in the emails sent to users
+	mailSettings config.Mail
+
+	// mail sends the verification and password reset emails
+	mail mailer.Mailer
+
+	// oidcSettings configure where the users are sent during an external login
+	oidcSettings config.OIDC
+
+	// identityProviders are the external providers users can log in with
+	identityProviders identity.Providers
+
+	// loginGuard counts failed logins and locks out the usernames and ips guessing passwords
+	loginGuard *lockout.Tracker
+
+	// storageSettings limit the size of the uploaded avatars
+	storageSettings config.Storage
+
+	// files keeps the uploaded avatars
+	files storage.Storage
+}
+
 // ApplyRoutes adds auth to gin engine
-func ApplyRoutes(r *gin.RouterGroup) {
+func ApplyRoutes(r *gin.RouterGroup, cfg config.Config, keySet *keys.KeySet, stores store.Stores, mailSender mailer.Mailer, providers identity.Providers, guard *lockout.Tracker, uploads storage.Storage) {
+	h := &handler{
+		tokenSettings:     cfg.JWT,
+		signingKeys:       keySet,
+		mailSettings:      cfg.Mail,
+		mail:              mailSender,
+		oidcSettings:      cfg.OIDC,
+		identityProviders: providers,
+		loginGuard:        guard,
+		storageSettings:   cfg.Storage,
+		files:             uploads,
+	}
+
 	auth := r.Group("/auth")
+	auth.Use(middlewares.InjectStores(stores))
 	{
-		auth.POST("/register", register)
-		auth.POST("/login", login)
+		auth.POST("/register", h.register)
+		auth.POST("/login", h.login)
+		auth.POST("/refresh", h.refresh)
+		auth.POST("/logout", logout)
+		auth.POST("/login/2fa", h.loginChallenge)
 
-		auth.GET("/single/:url", getUserWithUsername)
+		auth.GET("/single/:url", middlewares.Scope(policy.PostsRead), getUserWithUsername)
 
 		auth.GET("/followed", middlewares.Authorized, followedPage)
 		auth.POST("/follow/user/:username", middlewares.Authorized, followUser)
@@ -21,8 +75,42 @@ func ApplyRoutes(r *gin.RouterGroup) {
 		auth.POST("/follow/topic/:topicURL", middlewares.Authorized, followTopic)
 		auth.DELETE("/follow/topic/:topicURL", middlewares.Authorized, unFollowTopic)
 		auth.PATCH("/update", middlewares.Authorized, updateUser)
-		auth.PATCH("/update/password", middlewares.Authorized, changePassword)
+		auth.PATCH("/update/password", middlewares.Authorized, h.changePassword)
+		auth.PATCH("/update/email", middlewares.Authorized, h.updateEmail)
+
+		auth.POST("/email/resend", middlewares.Authorized, h.resendVerification)
+		auth.POST("/email/verify", h.verifyEmail)
+		auth.POST("/password/forgot", h.forgotPassword)
+		auth.POST("/password/reset", h.resetPassword)
+
+		auth.GET("/oidc", h.getProviders)
+		auth.GET("/oidc/:provider", h.oidcLogin)
+		auth.GET("/oidc/:provider/callback", h.oidcCallback)
+		auth.POST("/oidc/:provider/link", middlewares.Authorized, h.oidcLink)
+		auth.GET("/identities", middlewares.Authorized, getIdentities)
+		auth.DELETE("/identities/:provider", middlewares.Authorized, unlinkIdentity)
+
+		auth.GET("/2fa", middlewares.Authorized, getTwoFactor)
+		auth.POST("/2fa/setup", middlewares.Authorized, setupTwoFactor)
+		auth.POST("/2fa/confirm", middlewares.Authorized, confirmTwoFactor)
+		auth.POST("/2fa/disable", middlewares.Authorized, h.disableTwoFactor)
+		auth.POST("/2fa/recovery-codes", middlewares.Authorized, h.regenerateRecoveryCodes)
+
+		auth.GET("/sessions", middlewares.Authorized, getSessions)
+		auth.DELETE("/sessions", middlewares.Authorized, revokeOtherSessions)
+		auth.DELETE("/sessions/:id", middlewares.Authorized, revokeSession)
+
+		auth.GET("/lockout", middlewares.Authorized, h.getLockout)
+		auth.DELETE("/lockout", middlewares.Authorized, h.removeLockout)
+
+		auth.PATCH("/profile", middlewares.Authorized, updateProfile)
+		auth.POST("/avatar", middlewares.Authorized, h.uploadAvatar)
+		auth.DELETE("/avatar", middlewares.Authorized, h.deleteAvatar)
+
+		auth.GET("/tokens", middlewares.Authorized, getPersonalTokens)
+		auth.POST("/tokens", middlewares.Authorized, createPersonalToken)
+		auth.DELETE("/tokens/:id", middlewares.Authorized, revokePersonalToken)
 
-		auth.DELETE("/user/:id", middlewares.Authorized, remove)
+		auth.DELETE("/user/:id", middlewares.Authorized, h.remove)
 	}
 }
@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/mailer"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+)
+
+// The purposes of the tokens sent by email. Access tokens don't have a purpose, so they can't
+// be used in place of these and these don't have the user claim access tokens need.
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+)
+
+// How long the links in the emails work
+const (
+	verificationExpiry = time.Hour * 48
+	resetExpiry        = time.Hour
+)
+
+// errInvalidToken is returned for tokens which are malformed, expired or already used
+var errInvalidToken = errors.New("Invalid or expired token")
+
+// EmailRequest is the request body used for changing the email address and asking for a
+// password reset
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// EmailTokenRequest is the request body used for verifying the email address
+type EmailTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ResetPasswordRequest is the request body used for setting a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// serializeAccount formats the user with the fields only the user themselves sees
+func serializeAccount(user User) JSON {
+	account := user.Serialize()
+	account["email"] = user.Email
+	account["email_verified"] = user.EmailVerified()
+	account["two_factor"] = user.TwoFactorEnabled()
+	return account
+}
+
+// tokenBinding ties the token to the state it changes, so that the token stops working once
+// it has been used. Verification tokens are tied to the address and reset tokens to the
+// current password.
+func tokenBinding(purpose string, user User) string {
+	if purpose == purposeVerifyEmail {
+		if user.EmailVerified() {
+			return ""
+		}
+
+		return common.HashToken(user.EmailAddress())
+	}
+
+	return common.HashToken(user.PasswordHash)
+}
+
+// emailToken signs a single-use token for the purpose
+func (h *handler) emailToken(purpose string, user User, expiry time.Duration) (string, error) {
+	return h.signingKeys.Sign(jwt.MapClaims{
+		"purpose": purpose,
+		"sub":     strconv.FormatUint(uint64(user.ID), 10),
+		"bind":    tokenBinding(purpose, user),
+		"exp":     time.Now().Add(expiry).Unix(),
+	})
+}
+
+// parseEmailToken verifies the token and returns its user. Tokens for other purposes and
+// tokens which have already been used are rejected.
+func (h *handler) parseEmailToken(users store.UserStore, purpose, tokenString string) (User, error) {
+	claims, err := h.signingKeys.Parse(tokenString)
+	if err != nil || claims["purpose"] != purpose {
+		return User{}, errInvalidToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	id, err := strconv.ParseUint(subject, 10, 64)
+	if err != nil {
+		return User{}, errInvalidToken
+	}
+
+	user, err := users.FindByID(uint(id))
+	if err != nil {
+		return user, errInvalidToken
+	}
+
+	binding, _ := claims["bind"].(string)
+	if binding == "" || binding != tokenBinding(purpose, user) {
+		return user, errInvalidToken
+	}
+
+	return user, nil
+}
+
+// emailLink creates the link to the frontend page which consumes the token
+func (h *handler) emailLink(path, token string) string {
+	return strings.TrimRight(h.mailSettings.LinkURL, "/") + path + "?token=" + token
+}
+
+// sendVerification emails the user a link for verifying their address
+func (h *handler) sendVerification(user User) error {
+	token, err := h.emailToken(purposeVerifyEmail, user, verificationExpiry)
+	if err != nil {
+		return err
+	}
+
+	return h.mail.Send(mailer.Message{
+		To:      user.EmailAddress(),
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nconfirm that this address belongs to you by opening the link below. It works for %d hours.\n\n%s\n",
+			user.Username, int(verificationExpiry.Hours()), h.emailLink("/verify-email", token),
+		),
+	})
+}
+
+// sendPasswordReset emails the user a link for choosing a new password
+func (h *handler) sendPasswordReset(user User) error {
+	token, err := h.emailToken(purposeResetPassword, user, resetExpiry)
+	if err != nil {
+		return err
+	}
+
+	return h.mail.Send(mailer.Message{
+		To:      user.EmailAddress(),
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nyou can choose a new password by opening the link below. It works for %d minutes. If you didn't ask for this, you can ignore this email.\n\n%s\n",
+			user.Username, int(resetExpiry.Minutes()), h.emailLink("/reset-password", token),
+		),
+	})
+}
+
+// updateEmail changes the user's email address and sends a verification link to the new one
+func (h *handler) updateEmail(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	var body EmailRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if existing, err := stores.Users.FindByEmail(body.Email); err == nil && existing.ID != user.ID {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	if err := stores.Users.UpdateEmail(&user, body.Email); err != nil {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	if err := h.sendVerification(user); err != nil {
+		log.Println("Failed to send verification email to user", user.UUID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializeAccount(user))
+}
+
+// resendVerification sends a new verification link, for example when the old one expired
+func (h *handler) resendVerification(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	if user.Email == nil || user.EmailVerified() {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.sendVerification(user); err != nil {
+		log.Println("Failed to send verification email to user", user.UUID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// verifyEmail consumes a verification token. The user doesn't have to be logged in, since
+// the link might be opened on another device.
+func (h *handler) verifyEmail(c *gin.Context) {
+	stores := middlewares.Stores(c)
+
+	var body EmailTokenRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.parseEmailToken(stores.Users, purposeVerifyEmail, body.Token)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	if err := stores.Users.MarkEmailVerified(&user); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializeAccount(user))
+}
+
+// forgotPassword sends a reset link to the verified address. The response is the same whether
+// the address belongs to someone or not, so that it can't be used to find out who has an account.
+// The email is sent in the background, since waiting for the mail server would give it away
+// by taking longer.
+func (h *handler) forgotPassword(c *gin.Context) {
+	var body EmailRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	user, err := middlewares.Stores(c).Users.FindByEmail(body.Email)
+	if err == nil && user.EmailVerified() && !user.Suspended() {
+		go func() {
+			if err := h.sendPasswordReset(user); err != nil {
+				log.Println("Failed to send password reset email to user", user.UUID, err)
+			}
+		}()
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// resetPassword consumes a reset token and sets the new password. Every session is ended,
+// since whoever knew the old password might still be logged in, and the lock from guessing
+// the old password is lifted.
+func (h *handler) resetPassword(c *gin.Context) {
+	stores := middlewares.Stores(c)
+
+	var body ResetPasswordRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.parseEmailToken(stores.Users, purposeResetPassword, body.Token)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	hash, err := hash(body.Password)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := stores.Users.UpdatePassword(&user, hash); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := stores.RevokeSessions(&user); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.unlockUser(c, stores, user, user); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+)
+
+// loginKeys are the keys a login attempt's failures are counted with
+func loginKeys(c *gin.Context, username string) []string {
+	return []string{lockout.UserKey(username), lockout.IPKey(c.ClientIP())}
+}
+
+// waitForLogin rejects the attempt if the username or the client ip still has to wait after
+// earlier failures. It is checked before the password, so guessing doesn't cost any hashing.
+func (h *handler) waitForLogin(c *gin.Context, username string) bool {
+	wait := h.loginGuard.Wait(loginKeys(c, username)...)
+	if wait <= 0 {
+		return false
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, JSON{
+		"error":       "Too many failed login attempts",
+		"retry_after": seconds,
+	})
+	return true
+}
+
+// failLogin counts the failed attempt and writes a security event for every lock it caused.
+// The user is nil when nobody has the username.
+func (h *handler) failLogin(c *gin.Context, stores store.Stores, username string, user *User) {
+	for _, lock := range h.loginGuard.Fail(loginKeys(c, username)...) {
+		until := lock.Until
+		event := models.SecurityEvent{
+			Kind:      models.SecurityEventLockout,
+			Subject:   lock.Key,
+			IPAddress: c.ClientIP(),
+			Until:     &until,
+		}
+
+		if user != nil && lock.Key == lockout.UserKey(username) {
+			event.UserID = &user.ID
+		}
+
+		log.Println("Locked", lock.Key, "after too many failed logins until", until.Format(time.RFC3339))
+		if err := stores.SecurityEvents.Create(&event); err != nil {
+			log.Println("Failed to write the security event for", lock.Key, err)
+		}
+	}
+}
+
+// unlockUser lifts the lock on the user's username and writes it to the security log
+func (h *handler) unlockUser(c *gin.Context, stores store.Stores, user User, actor User) error {
+	key := lockout.UserKey(user.Username)
+	if _, locked := h.loginGuard.Locked(key); locked {
+		event := models.SecurityEvent{
+			Kind:      models.SecurityEventUnlock,
+			Subject:   key,
+			UserID:    &user.ID,
+			ActorID:   &actor.ID,
+			IPAddress: c.ClientIP(),
+		}
+
+		if err := stores.SecurityEvents.Create(&event); err != nil {
+			return err
+		}
+	}
+
+	h.loginGuard.Reset(key)
+	return nil
+}
+
+// getLockout tells the user if their username is locked, for example because someone has
+// been guessing their password while they're logged in elsewhere
+func (h *handler) getLockout(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	until, locked := h.loginGuard.Locked(lockout.UserKey(user.Username))
+	if !locked {
+		c.JSON(http.StatusOK, JSON{"locked": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, JSON{"locked": true, "until": until})
+}
+
+// removeLockout lets a logged in user lift the lock on their own username
+func (h *handler) removeLockout(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	if err := h.unlockUser(c, middlewares.Stores(c), user, user); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,442 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/identity"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"golang.org/x/oauth2"
+)
+
+// Identity model alias
+type Identity = models.Identity
+
+// purposeOIDCFlow marks the tokens which keep the state of a login between the redirects
+const purposeOIDCFlow = "oidc_flow"
+
+// flowCookie keeps the login's state, nonce and PKCE verifier in the user's browser until the
+// provider sends them back. It's only sent to the oidc routes.
+const (
+	flowCookie     = "oidc_flow"
+	flowCookiePath = "/api/auth/oidc"
+	flowExpiry     = time.Minute * 10
+)
+
+// errLoginFailed is shown to the user when the login can't be completed. The details are
+// logged instead, since they might reveal how the accounts are linked.
+var errLoginFailed = errors.New("login_failed")
+
+// flow is the state of a single login or linking with a provider
+type flow struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+	// LinkUserID is set when a logged in user links an identity to their account
+	LinkUserID uint
+}
+
+// setFlowCookie signs the flow into a short-lived cookie. The cookie has to survive the
+// redirect back from the provider, which is a cross-site navigation, so it is SameSite=Lax.
+func (h *handler) setFlowCookie(c *gin.Context, current flow) error {
+	token, err := h.signingKeys.Sign(jwt.MapClaims{
+		"purpose":  purposeOIDCFlow,
+		"provider": current.Provider,
+		"state":    current.State,
+		"nonce":    current.Nonce,
+		"verifier": current.Verifier,
+		"link":     strconv.FormatUint(uint64(current.LinkUserID), 10),
+		"exp":      time.Now().Add(flowExpiry).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(flowCookie, token, int(flowExpiry.Seconds()), flowCookiePath, "", h.secureCookies(), true)
+	return nil
+}
+
+// readFlowCookie verifies the flow cookie and removes it, so that it can only be used once
+func (h *handler) readFlowCookie(c *gin.Context) (flow, error) {
+	value, err := c.Cookie(flowCookie)
+	if err != nil {
+		return flow{}, err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(flowCookie, "", -1, flowCookiePath, "", h.secureCookies(), true)
+
+	claims, err := h.signingKeys.Parse(value)
+	if err != nil || claims["purpose"] != purposeOIDCFlow {
+		return flow{}, errInvalidToken
+	}
+
+	current := flow{}
+	current.Provider, _ = claims["provider"].(string)
+	current.State, _ = claims["state"].(string)
+	current.Nonce, _ = claims["nonce"].(string)
+	current.Verifier, _ = claims["verifier"].(string)
+
+	link, _ := claims["link"].(string)
+	linkUserID, err := strconv.ParseUint(link, 10, 64)
+	if err != nil {
+		return flow{}, errInvalidToken
+	}
+
+	current.LinkUserID = uint(linkUserID)
+	return current, nil
+}
+
+// secureCookies checks if the server is reached over https, where cookies shouldn't be sent
+// over plain http
+func (h *handler) secureCookies() bool {
+	return strings.HasPrefix(h.oidcSettings.CallbackURL, "https://")
+}
+
+// startFlow creates the state for a login with the provider and returns the provider's
+// login address. The response has already been written when ok is false.
+func (h *handler) startFlow(c *gin.Context, linkUserID uint) (string, bool) {
+	provider, err := h.identityProviders.Get(c.Param("provider"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return "", false
+	}
+
+	state, err := common.CreateToken(refreshTokenSize)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return "", false
+	}
+
+	nonce, err := common.CreateToken(refreshTokenSize)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return "", false
+	}
+
+	current := flow{
+		Provider:   provider.Name(),
+		State:      state,
+		Nonce:      nonce,
+		Verifier:   oauth2.GenerateVerifier(),
+		LinkUserID: linkUserID,
+	}
+
+	loginURL, err := provider.AuthCodeURL(c.Request.Context(), current.State, current.Nonce, current.Verifier)
+	if err != nil {
+		log.Println("Failed to start a login with", provider.Name(), err)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return "", false
+	}
+
+	if err := h.setFlowCookie(c, current); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return "", false
+	}
+
+	return loginURL, true
+}
+
+// redirectToFrontend sends the user back to the frontend with the values in the fragment,
+// which browsers don't send to servers, so the tokens don't end up in any logs
+func (h *handler) redirectToFrontend(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, h.oidcSettings.RedirectURL+"#"+values.Encode())
+}
+
+// getProviders lists the providers users can log in with
+func (h *handler) getProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": h.identityProviders.Names()})
+}
+
+// oidcLogin sends the user to the provider for logging in
+func (h *handler) oidcLogin(c *gin.Context) {
+	loginURL, ok := h.startFlow(c, 0)
+	if !ok {
+		return
+	}
+
+	c.Redirect(http.StatusFound, loginURL)
+}
+
+// oidcLink starts linking an identity at the provider to the logged in user. The frontend
+// navigates to the returned address, since the request needs the user's access token.
+func (h *handler) oidcLink(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	loginURL, ok := h.startFlow(c, user.ID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": loginURL})
+}
+
+// oidcCallback finishes the login after the provider sends the user back. The user is found
+// by their linked identity or by a verified email address, and new users get an account.
+func (h *handler) oidcCallback(c *gin.Context) {
+	stores := middlewares.Stores(c)
+
+	current, err := h.readFlowCookie(c)
+	if err != nil || current.Provider != c.Param("provider") || current.State != c.Query("state") {
+		h.redirectToFrontend(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		h.redirectToFrontend(c, url.Values{"error": {providerError}})
+		return
+	}
+
+	provider, err := h.identityProviders.Get(current.Provider)
+	if err != nil {
+		h.redirectToFrontend(c, url.Values{"error": {errLoginFailed.Error()}})
+		return
+	}
+
+	claims, err := provider.Exchange(c.Request.Context(), c.Query("code"), current.Nonce, current.Verifier)
+	if err != nil {
+		log.Println("Failed to finish a login with", provider.Name(), err)
+		h.redirectToFrontend(c, url.Values{"error": {errLoginFailed.Error()}})
+		return
+	}
+
+	if current.LinkUserID != 0 {
+		if err := linkIdentity(stores, current.LinkUserID, provider.Name(), claims); err != nil {
+			log.Println("Failed to link an identity from", provider.Name(), err)
+			h.redirectToFrontend(c, url.Values{"error": {"link_failed"}})
+			return
+		}
+
+		h.redirectToFrontend(c, url.Values{"linked": {provider.Name()}})
+		return
+	}
+
+	user, err := userForIdentity(stores, provider.Name(), claims)
+	if err != nil {
+		log.Println("Failed to log in with", provider.Name(), err)
+		h.redirectToFrontend(c, url.Values{"error": {errLoginFailed.Error()}})
+		return
+	}
+
+	if user.Suspended() {
+		h.redirectToFrontend(c, url.Values{"error": {"account_suspended"}})
+		return
+	}
+
+	if user.TwoFactorEnabled() {
+		challenge, err := h.challengeToken(user)
+		if err != nil {
+			h.redirectToFrontend(c, url.Values{"error": {errLoginFailed.Error()}})
+			return
+		}
+
+		h.redirectToFrontend(c, url.Values{
+			"challenge_token": {challenge},
+			"expires_in":      {strconv.Itoa(int(challengeExpiry.Seconds()))},
+		})
+		return
+	}
+
+	session, refreshToken, err := h.startSession(c, stores, user)
+	if err != nil {
+		h.redirectToFrontend(c, url.Values{"error": {errLoginFailed.Error()}})
+		return
+	}
+
+	accessToken, err := h.generateToken(user, session)
+	if err != nil {
+		h.redirectToFrontend(c, url.Values{"error": {errLoginFailed.Error()}})
+		return
+	}
+
+	h.redirectToFrontend(c, url.Values{
+		"token":         {accessToken},
+		"refresh_token": {refreshToken},
+		"expires_in":    {strconv.Itoa(int(h.tokenSettings.Expiry.Seconds()))},
+	})
+}
+
+// linkIdentity links the provider's account to the user who started the linking
+func linkIdentity(stores store.Stores, userID uint, provider string, claims identity.Claims) error {
+	user, err := stores.Users.FindByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if existing, err := stores.Identities.Find(provider, claims.Subject); err == nil {
+		if existing.UserID == user.ID {
+			return nil
+		}
+
+		return store.ErrConflict
+	}
+
+	return stores.Identities.Create(&Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+}
+
+// userForIdentity finds the user the provider's account belongs to. An account which hasn't
+// been linked yet is linked to the user with the same email address if both sides have
+// verified it, and to a new user otherwise. When a concurrent login links the account first,
+// the user it was linked to is used.
+func userForIdentity(stores store.Stores, provider string, claims identity.Claims) (User, error) {
+	if existing, err := stores.Identities.Find(provider, claims.Subject); err == nil {
+		return stores.Users.FindByID(existing.UserID)
+	}
+
+	user, err := linkNewIdentity(stores, Identity{Provider: provider, Subject: claims.Subject, Email: claims.Email}, claims)
+	if err != store.ErrConflict {
+		return user, err
+	}
+
+	existing, err := stores.Identities.Find(provider, claims.Subject)
+	if err != nil {
+		return User{}, err
+	}
+
+	return stores.Users.FindByID(existing.UserID)
+}
+
+// linkNewIdentity links the identity to the user with the same verified email address or to
+// a new user. ErrConflict is returned when the identity has been linked in the meantime.
+func linkNewIdentity(stores store.Stores, newIdentity Identity, claims identity.Claims) (User, error) {
+	if claims.Email != "" && claims.EmailVerified {
+		user, err := stores.Users.FindByEmail(claims.Email)
+		if err == nil && user.EmailVerified() {
+			newIdentity.UserID = user.ID
+			return user, stores.Identities.Create(&newIdentity)
+		}
+	}
+
+	return createExternalUser(stores, newIdentity, claims)
+}
+
+// createExternalUser creates an account for someone logging in with a provider for the first
+// time together with their identity, so that a failure can't leave an account nobody can log
+// in to. The account doesn't have a password, so the provider is the only way to log in
+// until the user sets one.
+func createExternalUser(stores store.Stores, newIdentity Identity, claims identity.Claims) (User, error) {
+	user := User{
+		UUID: common.CreateUUID(),
+		Role: models.RoleUser,
+	}
+
+	if claims.Email != "" && claims.EmailVerified {
+		if _, err := stores.Users.FindByEmail(claims.Email); err == store.ErrNotFound {
+			email := claims.Email
+			now := time.Now()
+			user.Email = &email
+			user.EmailVerifiedAt = &now
+		}
+	}
+
+	base := externalUsername(claims)
+	for attempt := 1; attempt <= 20; attempt++ {
+		user.Username = base
+		if attempt > 1 {
+			user.Username = fmt.Sprintf("%s-%d", base, attempt)
+		}
+
+		user.URL = common.FormatString(user.Username)
+		if _, err := stores.Users.FindByUsername(user.Username); err != store.ErrNotFound {
+			continue
+		}
+
+		if _, err := stores.Users.FindByURL(user.URL); err != store.ErrNotFound {
+			continue
+		}
+
+		// the username can still be taken by a concurrent registration
+		err := stores.Identities.CreateWithUser(&user, &newIdentity)
+		if err == nil || err == store.ErrConflict {
+			return user, err
+		}
+	}
+
+	return user, fmt.Errorf("Could not find a free username for %s", base)
+}
+
+// externalUsername picks a username from the provider's claims
+func externalUsername(claims identity.Claims) string {
+	candidates := []string{claims.PreferredUsername, claims.Name}
+	if at := strings.Index(claims.Email, "@"); at > 0 {
+		candidates = append(candidates, claims.Email[:at])
+	}
+
+	for _, candidate := range candidates {
+		if candidate = strings.TrimSpace(candidate); candidate != "" {
+			return candidate
+		}
+	}
+
+	return "user"
+}
+
+// getIdentities lists the identities linked to the user
+func getIdentities(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	identities, err := middlewares.Stores(c).Identities.ListByUser(user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	serialized := make([]JSON, len(identities), len(identities))
+	for index := range identities {
+		serialized[index] = identities[index].Serialize()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"identities": serialized})
+}
+
+// unlinkIdentity removes the user's identity at the provider. The last way to log in can't
+// be removed, so users without a password have to keep one identity.
+func unlinkIdentity(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	identities, err := stores.Identities.ListByUser(user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	for index := range identities {
+		if identities[index].Provider != c.Param("provider") {
+			continue
+		}
+
+		if user.PasswordHash == "" && len(identities) == 1 {
+			c.AbortWithStatusJSON(http.StatusConflict, JSON{"error": "Set a password before removing the last linked account"})
+			return
+		}
+
+		if err := stores.Identities.Delete(&identities[index]); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
@@ -0,0 +1,297 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/api/routes/auth"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/config"
+	"github.com/nireo/go-blog-api/lib/identity"
+	"github.com/nireo/go-blog-api/lib/identity/identitytest"
+	"github.com/nireo/go-blog-api/lib/keys"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/mailer"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/storage"
+)
+
+// server runs the auth routes against in-memory stores with the mock provider as "mock"
+type server struct {
+	t        *testing.T
+	router   *gin.Engine
+	stores   store.Stores
+	provider *identitytest.Server
+}
+
+func newServer(t *testing.T, stores store.Stores) *server {
+	gin.SetMode(gin.TestMode)
+	provider := identitytest.NewServer("client", "secret")
+	t.Cleanup(provider.Close)
+
+	cfg := config.Default()
+	cfg.JWT.Secret = "test secret"
+	cfg.OIDC.CallbackURL = "http://blog.example.com"
+	cfg.OIDC.RedirectURL = "http://frontend.example.com/oauth/callback"
+	cfg.OIDC.Providers = []config.OIDCProvider{{
+		Name:         "mock",
+		Issuer:       provider.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}}
+
+	keySet, err := keys.Load(cfg.JWT)
+	if err != nil {
+		t.Fatalf("Could not load the keys: %v", err)
+	}
+
+	s := &server{t: t, router: gin.New(), stores: stores, provider: provider}
+	s.router.Use(middlewares.JWTMiddleware(keySet, stores))
+	auth.ApplyRoutes(
+		s.router.Group("/api"),
+		cfg,
+		keySet,
+		stores,
+		mailer.NewLogMailer(cfg.Mail.From, ""),
+		identity.Load(cfg.OIDC),
+		lockout.New(cfg.Lockout, time.Now),
+		storage.NewLocalStorage(t.TempDir(), cfg.Storage.URL),
+	)
+	return s
+}
+
+func (s *server) get(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// start begins a login and returns the provider's login address with the flow cookie
+func (s *server) start() (string, []*http.Cookie) {
+	response := s.get("/api/auth/oidc/mock", nil)
+	if response.Code != http.StatusFound {
+		s.t.Fatalf("Starting the login returned %d", response.Code)
+	}
+
+	return response.Header().Get("Location"), response.Result().Cookies()
+}
+
+// finish sends the user back from the provider and returns the values in the fragment of
+// the address the frontend gets
+func (s *server) finish(callback string, cookies []*http.Cookie) url.Values {
+	parsed, err := url.Parse(callback)
+	if err != nil {
+		s.t.Fatalf("Could not parse the callback: %v", err)
+	}
+
+	response := s.get(parsed.RequestURI(), cookies)
+	if response.Code != http.StatusFound {
+		s.t.Fatalf("The callback returned %d", response.Code)
+	}
+
+	location := response.Header().Get("Location")
+	if !strings.HasPrefix(location, "http://frontend.example.com/oauth/callback#") {
+		s.t.Fatalf("Expected a redirect to the frontend, got %s", location)
+	}
+
+	values, err := url.ParseQuery(location[strings.Index(location, "#")+1:])
+	if err != nil {
+		s.t.Fatalf("Could not parse the fragment: %v", err)
+	}
+
+	return values
+}
+
+// login logs in at the provider with the claims
+func (s *server) login(claims identity.Claims) url.Values {
+	loginURL, cookies := s.start()
+	callback, err := s.provider.Authorize(loginURL, claims)
+	if err != nil {
+		s.t.Fatalf("The provider rejected the login: %v", err)
+	}
+
+	return s.finish(callback, cookies)
+}
+
+// linkedUser returns the user the subject's identity is linked to
+func (s *server) linkedUser(subject string) models.User {
+	linked, err := s.stores.Identities.Find("mock", subject)
+	if err != nil {
+		s.t.Fatalf("The identity of %s wasn't linked: %v", subject, err)
+	}
+
+	user, err := s.stores.Users.FindByID(linked.UserID)
+	if err != nil {
+		s.t.Fatalf("The linked user doesn't exist: %v", err)
+	}
+
+	return user
+}
+
+func (s *server) user(name, email string, verified bool) models.User {
+	user := models.User{Username: name, URL: name, UUID: common.CreateUUID(), Email: &email}
+	if verified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	if err := s.stores.Users.Create(&user); err != nil {
+		s.t.Fatalf("Could not create user %s: %v", name, err)
+	}
+
+	return user
+}
+
+func expectTokens(t *testing.T, values url.Values) {
+	t.Helper()
+	if values.Get("error") != "" || values.Get("token") == "" || values.Get("refresh_token") == "" {
+		t.Fatalf("Expected tokens, got %v", values)
+	}
+}
+
+func TestOIDCFirstLogin(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	claims := identity.Claims{Subject: "1", Email: "new@example.com", EmailVerified: true, PreferredUsername: "newcomer"}
+
+	expectTokens(t, s.login(claims))
+
+	user := s.linkedUser("1")
+	if user.Username != "newcomer" || user.Email == nil || *user.Email != "new@example.com" || !user.EmailVerified() {
+		t.Errorf("Expected a new user with the verified email, got %+v", user)
+	}
+
+	// the second login finds the same user through the identity
+	expectTokens(t, s.login(claims))
+	if again := s.linkedUser("1"); again.ID != user.ID {
+		t.Errorf("Expected the second login to use user %d, got %d", user.ID, again.ID)
+	}
+
+	if _, err := s.stores.Users.FindByUsername("newcomer-2"); err != store.ErrNotFound {
+		t.Error("The second login shouldn't create another user")
+	}
+}
+
+func TestOIDCUsernameTaken(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	s.user("taken", "taken@example.com", true)
+
+	expectTokens(t, s.login(identity.Claims{Subject: "1", PreferredUsername: "taken"}))
+	if user := s.linkedUser("1"); user.Username != "taken-2" || user.Email != nil {
+		t.Errorf("Expected a new user with the next free username and no email, got %+v", user)
+	}
+}
+
+func TestOIDCInvalidState(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	claims := identity.Claims{Subject: "1", PreferredUsername: "someone"}
+
+	loginURL, cookies := s.start()
+	callback, err := s.provider.Authorize(loginURL, claims)
+	if err != nil {
+		t.Fatalf("The provider rejected the login: %v", err)
+	}
+
+	// a callback with a forged state
+	parsed, _ := url.Parse(callback)
+	query := parsed.Query()
+	query.Set("state", "forged")
+	parsed.RawQuery = query.Encode()
+
+	if values := s.finish(parsed.String(), cookies); values.Get("error") != "invalid_state" {
+		t.Errorf("A mismatched state should be rejected, got %v", values)
+	}
+
+	// a callback without the cookie of the browser which started the login
+	if values := s.finish(callback, nil); values.Get("error") != "invalid_state" {
+		t.Errorf("A callback without the flow cookie should be rejected, got %v", values)
+	}
+
+	if _, err := s.stores.Identities.Find("mock", "1"); err != store.ErrNotFound {
+		t.Error("A rejected login shouldn't link the identity")
+	}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	existing := s.user("existing", "same@example.com", true)
+
+	expectTokens(t, s.login(identity.Claims{Subject: "1", Email: "same@example.com", EmailVerified: true, PreferredUsername: "other"}))
+	if user := s.linkedUser("1"); user.ID != existing.ID {
+		t.Errorf("Expected the identity linked to the user with the verified email, got %+v", user)
+	}
+}
+
+func TestOIDCUnverifiedClaimDoesNotLink(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	existing := s.user("existing", "same@example.com", true)
+
+	expectTokens(t, s.login(identity.Claims{Subject: "1", Email: "same@example.com", PreferredUsername: "other"}))
+	user := s.linkedUser("1")
+	if user.ID == existing.ID || user.Email != nil {
+		t.Errorf("Expected a new user without the unverified email, got %+v", user)
+	}
+}
+
+func TestOIDCUnverifiedUserDoesNotLink(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	existing := s.user("existing", "same@example.com", false)
+
+	expectTokens(t, s.login(identity.Claims{Subject: "1", Email: "same@example.com", EmailVerified: true, PreferredUsername: "other"}))
+	user := s.linkedUser("1")
+	if user.ID == existing.ID || user.Email != nil {
+		t.Errorf("Expected a new user without the taken email, got %+v", user)
+	}
+}
+
+// racingIdentities misses the identity on the first lookup, like a login which checked
+// just before a concurrent login linked the identity
+type racingIdentities struct {
+	store.IdentityStore
+	missed bool
+}
+
+func (identities *racingIdentities) Find(provider, subject string) (models.Identity, error) {
+	if !identities.missed {
+		identities.missed = true
+		return models.Identity{}, store.ErrNotFound
+	}
+
+	return identities.IdentityStore.Find(provider, subject)
+}
+
+func TestOIDCConcurrentFirstLogin(t *testing.T) {
+	stores := store.NewMemoryStores()
+	identities := &racingIdentities{IdentityStore: stores.Identities}
+	stores.Identities = identities
+	s := newServer(t, stores)
+
+	first := s.user("first", "first@example.com", true)
+	if err := stores.Identities.Create(&models.Identity{UserID: first.ID, Provider: "mock", Subject: "1"}); err != nil {
+		t.Fatalf("Could not link the identity: %v", err)
+	}
+
+	expectTokens(t, s.login(identity.Claims{Subject: "1", PreferredUsername: "second"}))
+	if !identities.missed {
+		t.Fatal("The login didn't look up the identity")
+	}
+
+	if user := s.linkedUser("1"); user.ID != first.ID {
+		t.Errorf("Expected the identity to stay linked to the first user, got %+v", user)
+	}
+
+	if _, err := s.stores.Users.FindByUsername("second"); err != store.ErrNotFound {
+		t.Error("The losing login shouldn't leave a user behind")
+	}
+}
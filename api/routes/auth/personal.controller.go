@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
+)
+
+// PersonalToken model alias
+type PersonalToken = models.PersonalToken
+
+// personalTokenSize is the amount of random bytes in a personal access token
+const personalTokenSize = 32
+
+// maxPersonalTokens is how many personal access tokens a user can have at once
+const maxPersonalTokens = 50
+
+// PersonalTokenRequest is the request body for creating a personal access token. Tokens
+// without an expiry work until they are revoked.
+type PersonalTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"min=0,max=365"`
+}
+
+// getPersonalTokens lists the user's personal access tokens without the tokens themselves
+func getPersonalTokens(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	tokens, err := middlewares.Stores(c).PersonalTokens.ListByUser(user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	serialized := make([]JSON, len(tokens))
+	for index := range tokens {
+		serialized[index] = tokens[index].Serialize()
+	}
+
+	c.JSON(http.StatusOK, JSON{"tokens": serialized, "scopes": policy.Scopes})
+}
+
+// createPersonalToken creates a token with the scopes. The token is in the response only
+// this once, after that only its hash is known.
+func createPersonalToken(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	var body PersonalTokenRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range body.Scopes {
+		if !policy.ValidScope(scope) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": "Unknown scope " + scope})
+			return
+		}
+
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	existing, err := stores.PersonalTokens.ListByUser(user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if len(existing) >= maxPersonalTokens {
+		c.AbortWithStatusJSON(http.StatusConflict, JSON{"error": "Too many personal access tokens"})
+		return
+	}
+
+	value, err := common.CreateToken(personalTokenSize)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	value = models.PersonalTokenPrefix + value
+	token := PersonalToken{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(body.Name),
+		TokenHash: common.HashToken(value),
+		Scopes:    strings.Join(scopes, " "),
+	}
+
+	if body.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, body.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := stores.PersonalTokens.Create(&token); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	serialized := token.Serialize()
+	serialized["token"] = value
+	c.JSON(http.StatusCreated, serialized)
+}
+
+// revokePersonalToken removes one of the user's personal access tokens
+func revokePersonalToken(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token, err := stores.PersonalTokens.FindForUser(user, uint(id))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err := stores.PersonalTokens.Delete(&token); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
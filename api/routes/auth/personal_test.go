@@ -0,0 +1,129 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// request sends the body as JSON with the token and decodes the JSON response into out,
+// which can be nil
+func (s *server) request(method, path, token string, body interface{}, out interface{}) int {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			s.t.Fatalf("Could not encode the request: %v", err)
+		}
+	}
+
+	request := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+
+	if out != nil && recorder.Code < 300 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("Could not decode the response of %s %s: %v", method, path, err)
+		}
+	}
+
+	return recorder.Code
+}
+
+// register creates the user and returns their access token
+func (s *server) register(username string) string {
+	var tokens struct {
+		Token string `json:"token"`
+	}
+
+	body := common.JSON{"username": username, "password": "correct horse battery"}
+	if code := s.request(http.MethodPost, "/api/auth/register", "", body, &tokens); code != http.StatusOK {
+		s.t.Fatalf("Registering %s returned %d", username, code)
+	}
+
+	return tokens.Token
+}
+
+func (s *server) personalToken(accessToken string, scopes ...string) string {
+	var created struct {
+		Token string `json:"token"`
+	}
+
+	body := common.JSON{"name": "script", "scopes": scopes}
+	if code := s.request(http.MethodPost, "/api/auth/tokens", accessToken, body, &created); code != http.StatusCreated {
+		s.t.Fatalf("Creating the personal token returned %d", code)
+	}
+
+	return created.Token
+}
+
+func TestProfileDraftsNeedPostsScope(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	accessToken := s.register("author")
+	author, _ := s.stores.Users.FindByUsername("author")
+
+	draft := models.Post{UUID: common.CreateUUID(), Title: "Draft", UserID: author.ID, Status: models.PostStatusDraft}
+	if err := s.stores.Posts.Create(&draft, nil); err != nil {
+		t.Fatalf("Could not create the draft: %v", err)
+	}
+
+	var profile struct {
+		Posts []struct {
+			UUID string `json:"uuid"`
+		} `json:"posts"`
+	}
+
+	unscoped := s.personalToken(accessToken, "topics:read")
+	if code := s.request(http.MethodGet, "/api/auth/single/"+author.URL, unscoped, nil, &profile); code != http.StatusOK {
+		t.Fatalf("Reading the profile returned %d", code)
+	}
+
+	if len(profile.Posts) != 0 {
+		t.Errorf("A token without posts:read shouldn't see the drafts, got %+v", profile.Posts)
+	}
+
+	scoped := s.personalToken(accessToken, "posts:read")
+	s.request(http.MethodGet, "/api/auth/single/"+author.URL, scoped, nil, &profile)
+	if len(profile.Posts) != 1 || profile.Posts[0].UUID != draft.UUID {
+		t.Errorf("A token with posts:read should see the drafts, got %+v", profile.Posts)
+	}
+}
+
+func TestPasswordChangeRevokesPersonalTokens(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	accessToken := s.register("author")
+	personal := s.personalToken(accessToken, "posts:read")
+
+	var tokens struct {
+		Token string `json:"token"`
+	}
+
+	body := common.JSON{"password": "another horse battery"}
+	if code := s.request(http.MethodPatch, "/api/auth/update/password", accessToken, body, &tokens); code != http.StatusOK {
+		t.Fatalf("Changing the password returned %d", code)
+	}
+
+	author, _ := s.stores.Users.FindByUsername("author")
+	if remaining, _ := s.stores.PersonalTokens.ListByUser(author); len(remaining) != 0 {
+		t.Errorf("Expected the personal tokens to be revoked, %d remain", len(remaining))
+	}
+
+	if code := s.request(http.MethodGet, "/api/auth/tokens", personal, nil, nil); code != http.StatusForbidden {
+		t.Errorf("The revoked personal token should be rejected, got %d", code)
+	}
+
+	if code := s.request(http.MethodGet, "/api/auth/tokens", tokens.Token, nil, nil); code != http.StatusOK {
+		t.Errorf("The new access token should work, got %d", code)
+	}
+}
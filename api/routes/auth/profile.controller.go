@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	// the avatar formats image.DecodeConfig has to understand
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+)
+
+// maxProfileLinks is how many links a profile can have
+const maxProfileLinks = 5
+
+// maxAvatarDimension is the largest width and height accepted for an avatar
+const maxAvatarDimension = 4096
+
+// multipartOverhead is the room left for the multipart headers on top of the avatar's size
+const multipartOverhead = 64 << 10
+
+// avatarExtensions are the accepted avatar formats keyed by their sniffed content type
+var avatarExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+}
+
+// ProfileLinkRequest is a single link in the profile update
+type ProfileLinkRequest struct {
+	Label string `json:"label" binding:"required,max=30"`
+	URL   string `json:"url" binding:"required,max=200"`
+}
+
+// ProfileRequest is the request body of the profile update. Fields which are left out keep
+// their value and empty ones are cleared. The links replace all of the existing ones.
+type ProfileRequest struct {
+	DisplayName *string               `json:"display_name" binding:"omitempty,max=50"`
+	Bio         *string               `json:"bio" binding:"omitempty,max=500"`
+	Location    *string               `json:"location" binding:"omitempty,max=100"`
+	Website     *string               `json:"website" binding:"omitempty,max=200"`
+	Links       *[]ProfileLinkRequest `json:"links" binding:"omitempty,dive"`
+}
+
+// profileURL tells if the address can be shown as a link on the profile
+func profileURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// updateProfile changes the fields given in the request
+func updateProfile(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	var body ProfileRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	profile := user.Profile
+	if body.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*body.DisplayName)
+	}
+
+	if body.Bio != nil {
+		profile.Bio = strings.TrimSpace(*body.Bio)
+	}
+
+	if body.Location != nil {
+		profile.Location = strings.TrimSpace(*body.Location)
+	}
+
+	if body.Website != nil {
+		website := strings.TrimSpace(*body.Website)
+		if website != "" && !profileURL(website) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": "The website should be an http or https address"})
+			return
+		}
+
+		profile.Website = website
+	}
+
+	if body.Links != nil {
+		if len(*body.Links) > maxProfileLinks {
+			c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": fmt.Sprintf("A profile can have at most %d links", maxProfileLinks)})
+			return
+		}
+
+		links := make([]models.ProfileLink, len(*body.Links))
+		for index, link := range *body.Links {
+			links[index] = models.ProfileLink{Label: strings.TrimSpace(link.Label), URL: strings.TrimSpace(link.URL)}
+			if links[index].Label == "" || !profileURL(links[index].URL) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": "Every link needs a label and an http or https address"})
+				return
+			}
+		}
+
+		if err := profile.SetLinks(links); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := stores.Users.UpdateProfile(&user, profile); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializeAccount(user))
+}
+
+// readAvatar reads the uploaded avatar and checks it's an image in one of the accepted
+// formats. The format is decided from the content, never from the name or the client's
+// content type.
+func (h *handler) readAvatar(c *gin.Context) ([]byte, string, bool) {
+	limit := h.storageSettings.MaxAvatarSize
+	if c.Request.ContentLength > limit+multipartOverhead {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, JSON{"error": fmt.Sprintf("The avatar can be at most %d bytes", limit)})
+		return nil, "", false
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": "The avatar should be uploaded in the avatar field"})
+		return nil, "", false
+	}
+
+	if header.Size > limit {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, JSON{"error": fmt.Sprintf("The avatar can be at most %d bytes", limit)})
+		return nil, "", false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return nil, "", false
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return nil, "", false
+	}
+
+	if int64(len(content)) > limit {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, JSON{"error": fmt.Sprintf("The avatar can be at most %d bytes", limit)})
+		return nil, "", false
+	}
+
+	extension, ok := avatarExtensions[http.DetectContentType(content)]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, JSON{"error": "The avatar should be a PNG, JPEG or GIF image"})
+		return nil, "", false
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, JSON{"error": "The avatar isn't a valid image"})
+		return nil, "", false
+	}
+
+	if config.Width > maxAvatarDimension || config.Height > maxAvatarDimension {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": fmt.Sprintf("The avatar can be at most %dx%d pixels", maxAvatarDimension, maxAvatarDimension)})
+		return nil, "", false
+	}
+
+	return content, extension, true
+}
+
+// uploadAvatar saves the uploaded image under a new key and replaces the old avatar with it.
+// New keys keep caches from showing the old image.
+func (h *handler) uploadAvatar(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	content, extension, ok := h.readAvatar(c)
+	if !ok {
+		return
+	}
+
+	key := "avatars/" + common.CreateUUID() + "." + extension
+	avatarURL, err := h.files.Save(key, bytes.NewReader(content))
+	if err != nil {
+		log.Println("Failed to save the avatar of user", user.UUID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	previous := user.AvatarKey
+	if err := stores.Users.UpdateAvatar(&user, key, avatarURL); err != nil {
+		h.files.Delete(key)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	h.removeAvatar(user, previous)
+	c.JSON(http.StatusOK, serializeAccount(user))
+}
+
+// deleteAvatar removes the user's avatar
+func (h *handler) deleteAvatar(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	previous := user.AvatarKey
+	if err := stores.Users.UpdateAvatar(&user, "", ""); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	h.removeAvatar(user, previous)
+	c.JSON(http.StatusOK, serializeAccount(user))
+}
+
+// removeAvatar deletes a replaced avatar from the storage. The user no longer points to it,
+// so a failure only leaves an unused file behind.
+func (h *handler) removeAvatar(user User, key string) {
+	if key == "" {
+		return
+	}
+
+	if err := h.files.Delete(key); err != nil {
+		log.Println("Failed to delete the old avatar of user", user.UUID, err)
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+)
+
+// currentSessionID returns the id of the session the request was made with, zero for access
+// tokens given out before sessions were recorded
+func currentSessionID(c *gin.Context) uint {
+	rawSession, exists := c.Get("session")
+	if !exists {
+		return 0
+	}
+
+	return rawSession.(Session).ID
+}
+
+// getSessions lists the devices the user is logged in on
+func getSessions(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	sessions, err := middlewares.Stores(c).Sessions.ListActive(user, time.Now())
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	current := currentSessionID(c)
+	serialized := make([]JSON, len(sessions))
+	for index := range sessions {
+		serialized[index] = sessions[index].Serialize()
+		serialized[index]["current"] = sessions[index].ID == current
+	}
+
+	c.JSON(http.StatusOK, JSON{"sessions": serialized})
+}
+
+// revokeSession logs the user out on one device. Its access tokens stop working right away.
+func revokeSession(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	session, err := stores.Sessions.FindByID(uint(id))
+	if err != nil || session.UserID != user.ID || !session.Active(time.Now()) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err := stores.Sessions.Revoke(&session); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// revokeOtherSessions logs the user out everywhere except on the device making the request
+func revokeOtherSessions(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	if err := middlewares.Stores(c).Sessions.RevokeAll(user, currentSessionID(c)); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+)
+
+// RefreshToken model alias
+type RefreshToken = models.RefreshToken
+
+// Session model alias
+type Session = models.Session
+
+// refreshTokenSize is the amount of random bytes in a refresh token
+const refreshTokenSize = 32
+
+// maxUserAgentLength keeps long user agents from overflowing the column
+const maxUserAgentLength = 255
+
+// TokenRequest is the request body of the refresh and logout controllers
+type TokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+	// All logs the user out of every session instead of just this one
+	All bool `json:"all"`
+}
+
+// newRefreshToken creates an unsaved refresh token for the user's session and returns it with
+// the token's value, which is only known to the client after this
+func (h *handler) newRefreshToken(user User, sessionID uint) (RefreshToken, string, error) {
+	value, err := common.CreateToken(refreshTokenSize)
+	if err != nil {
+		return RefreshToken{}, "", err
+	}
+
+	token := RefreshToken{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		TokenHash: common.HashToken(value),
+		ExpiresAt: time.Now().Add(h.tokenSettings.RefreshExpiry),
+	}
+
+	return token, value, nil
+}
+
+// newSession records a session on the device the request came from
+func newSession(c *gin.Context, stores store.Stores, user User, expiresAt time.Time) (Session, error) {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	session := Session{
+		UserID:     user.ID,
+		UserAgent:  userAgent,
+		IPAddress:  c.ClientIP(),
+		LastSeenAt: time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+
+	if err := stores.Sessions.Create(&session); err != nil {
+		return session, err
+	}
+
+	return session, nil
+}
+
+// tokenResponse formats the user and their new tokens
+func (h *handler) tokenResponse(user User, session Session, refreshToken string) (JSON, error) {
+	accessToken, err := h.generateToken(user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return JSON{
+		"user":          serializeAccount(user),
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(h.tokenSettings.Expiry.Seconds()),
+	}, nil
+}
+
+// startSession records a new session for the user and saves its first refresh token. The
+// session is returned with the refresh token's value.
+func (h *handler) startSession(c *gin.Context, stores store.Stores, user User) (Session, string, error) {
+	refreshToken, value, err := h.newRefreshToken(user, 0)
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	session, err := newSession(c, stores, user, refreshToken.ExpiresAt)
+	if err != nil {
+		return session, "", err
+	}
+
+	refreshToken.SessionID = session.ID
+	if err := stores.Tokens.Create(&refreshToken); err != nil {
+		return session, "", err
+	}
+
+	return session, value, nil
+}
+
+// issueTokens starts a new session for the user and returns the response with its tokens
+func (h *handler) issueTokens(c *gin.Context, stores store.Stores, user User) (JSON, error) {
+	session, refreshToken, err := h.startSession(c, stores, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.tokenResponse(user, session, refreshToken)
+}
+
+// refresh exchanges a refresh token for a new access token and refresh token. Every refresh
+// token can only be used once, so using a revoked one means that it has been stolen and
+// all of the user's sessions are ended. Tokens revoked along with their session are just
+// rejected.
+func (h *handler) refresh(c *gin.Context) {
+	var body TokenRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	stores := middlewares.Stores(c)
+	token, err := stores.Tokens.FindByHash(common.HashToken(body.RefreshToken))
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := stores.Users.FindByID(token.UserID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var session Session
+	if token.SessionID != 0 {
+		session, err = stores.Sessions.FindByID(token.SessionID)
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if token.RevokedAt != nil {
+		if session.RevokedAt == nil {
+			// the stolen tokens stay usable if this fails, so it can't pass as a normal rejection
+			if err := stores.RevokeSessions(&user); err != nil {
+				log.Println("Failed to revoke sessions of user", user.UUID, "after refresh token reuse", err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	if !token.Active(now) || user.Suspended() || (token.SessionID != 0 && !session.Active(now)) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	replacement, value, err := h.newRefreshToken(user, session.ID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// tokens given out before sessions were recorded get a session when they're rotated
+	if token.SessionID == 0 {
+		session, err = newSession(c, stores, user, replacement.ExpiresAt)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		replacement.SessionID = session.ID
+	}
+
+	if err := stores.Tokens.Rotate(&token, &replacement); err != nil {
+		if err == store.ErrConflict {
+			// another request used the token first
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := stores.Sessions.Extend(&session, now, replacement.ExpiresAt); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	response, err := h.tokenResponse(user, session, value)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// logout ends the session of the refresh token, which also stops its access tokens from
+// working, or every session of the user with 'all'. Only the current refresh token of a
+// session is accepted, so an old token from a log or a device can't log the user out.
+func logout(c *gin.Context) {
+	var body TokenRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	stores := middlewares.Stores(c)
+	token, err := stores.Tokens.FindByHash(common.HashToken(body.RefreshToken))
+	if err != nil || !token.Active(time.Now()) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if body.All {
+		user, err := stores.Users.FindByID(token.UserID)
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if err := stores.RevokeSessions(&user); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if token.SessionID == 0 {
+		if err := stores.Tokens.Revoke(&token); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	session, err := stores.Sessions.FindByID(token.SessionID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if err := stores.Sessions.Revoke(&session); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package auth_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func TestLogoutRejectsRotatedToken(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+
+	var registered tokenPair
+	body := common.JSON{"username": "author", "password": "correct horse battery"}
+	if code := s.request(http.MethodPost, "/api/auth/register", "", body, &registered); code != http.StatusOK {
+		t.Fatalf("Registering returned %d", code)
+	}
+
+	var rotated tokenPair
+	if code := s.request(http.MethodPost, "/api/auth/refresh", "", common.JSON{"refresh_token": registered.RefreshToken}, &rotated); code != http.StatusOK {
+		t.Fatalf("Refreshing returned %d", code)
+	}
+
+	s.personalToken(rotated.Token, "posts:read")
+
+	// the token was replaced when it was used, so it can't end the session anymore
+	old := common.JSON{"refresh_token": registered.RefreshToken, "all": true}
+	if code := s.request(http.MethodPost, "/api/auth/logout", "", old, nil); code != http.StatusUnauthorized {
+		t.Errorf("Logging out with a rotated token should be rejected, got %d", code)
+	}
+
+	if code := s.request(http.MethodGet, "/api/auth/tokens", rotated.Token, nil, nil); code != http.StatusOK {
+		t.Errorf("The session should still work, got %d", code)
+	}
+
+	author, _ := s.stores.Users.FindByUsername("author")
+	if remaining, _ := s.stores.PersonalTokens.ListByUser(author); len(remaining) != 1 {
+		t.Errorf("The personal token should be kept, %d remain", len(remaining))
+	}
+
+	if code := s.request(http.MethodPost, "/api/auth/logout", "", common.JSON{"refresh_token": rotated.RefreshToken}, nil); code != http.StatusNoContent {
+		t.Fatalf("Logging out with the current token returned %d", code)
+	}
+
+	if code := s.request(http.MethodGet, "/api/auth/tokens", rotated.Token, nil, nil); code != http.StatusForbidden {
+		t.Errorf("The session should have ended, got %d", code)
+	}
+}
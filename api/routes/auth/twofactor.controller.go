@@ -0,0 +1,400 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// purposeLoginChallenge is the purpose of the token which is given out instead of the session
+// tokens when the user still has to give their second factor
+const purposeLoginChallenge = "login_challenge"
+
+// challengeExpiry is how long the user has for entering the code after giving their password
+const challengeExpiry = time.Minute * 5
+
+// totpIssuer is shown next to the account in authenticator apps
+const totpIssuer = "go-blog-api"
+
+// The codes are the ones every authenticator app supports: six digits from SHA1 every 30
+// seconds. A code from the previous or next step is accepted as well, in case the clocks drift.
+const (
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+// recoveryCodeCount is the amount of recovery codes given out at once and recoveryCodeSize is
+// the amount of random bytes in each. The codes are hex, split in two halves with a dash.
+const (
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 6
+)
+
+// TwoFactorCodeRequest is the request body used for confirming the enrollment
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// ChallengeRequest is the request body of the second login step. The code is either from the
+// authenticator app or one of the recovery codes.
+type ChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// ReauthRequest is the request body of the actions which need the user to prove again that it
+// is them. Users who log in only with an external provider don't have a password.
+type ReauthRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// totpOptions generate the codes the authenticator apps show
+var totpOptions = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// matchTOTP checks the code against the steps around the time and returns the step which it
+// belongs to
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	for offset := -totpSkew; offset <= totpSkew; offset++ {
+		at := now.Add(time.Duration(offset*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, at, totpOptions)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / totpPeriod, true
+		}
+	}
+
+	return 0, false
+}
+
+// normalizeCode removes the spaces and dashes people type into codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// useTOTP accepts a code from the user's authenticator once
+func useTOTP(stores store.Stores, user *User, code string) bool {
+	step, ok := matchTOTP(user.TOTPSecret, normalizeCode(code), time.Now())
+	if !ok {
+		return false
+	}
+
+	return stores.Users.UseTOTPStep(user, step) == nil
+}
+
+// useSecondFactor accepts either a code from the authenticator or an unused recovery code
+func useSecondFactor(stores store.Stores, user *User, code string) bool {
+	if useTOTP(stores, user, code) {
+		return true
+	}
+
+	return stores.RecoveryCodes.Use(*user, common.HashToken(normalizeCode(code))) == nil
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new ones, which are
+// shown to the user only this once
+func newRecoveryCodes(stores store.Stores, user User) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+
+		code := hex.EncodeToString(bytes)
+		codes[i] = code[:len(code)/2] + "-" + code[len(code)/2:]
+		hashes[i] = common.HashToken(code)
+	}
+
+	if err := stores.RecoveryCodes.Replace(user, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// challengeToken signs the token the second login step is done with. It carries the token
+// version, so logging out everywhere or changing the password invalidates it.
+func (h *handler) challengeToken(user User) (string, error) {
+	return h.signingKeys.Sign(jwt.MapClaims{
+		"purpose": purposeLoginChallenge,
+		"sub":     strconv.FormatUint(uint64(user.ID), 10),
+		"ver":     user.TokenVersion,
+		"exp":     time.Now().Add(challengeExpiry).Unix(),
+	})
+}
+
+// parseChallengeToken verifies the challenge token and returns its user
+func (h *handler) parseChallengeToken(users store.UserStore, tokenString string) (User, error) {
+	claims, err := h.signingKeys.Parse(tokenString)
+	if err != nil || claims["purpose"] != purposeLoginChallenge {
+		return User{}, errInvalidToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	id, err := strconv.ParseUint(subject, 10, 64)
+	if err != nil {
+		return User{}, errInvalidToken
+	}
+
+	user, err := users.FindByID(uint(id))
+	if err != nil {
+		return user, errInvalidToken
+	}
+
+	version, ok := claims["ver"].(float64)
+	if !ok || int(version) != user.TokenVersion || !user.TwoFactorEnabled() {
+		return user, errInvalidToken
+	}
+
+	return user, nil
+}
+
+// challengeResponse is returned by login instead of the tokens when the user has two-factor
+// authentication on
+func (h *handler) challengeResponse(user User) (JSON, error) {
+	token, err := h.challengeToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return JSON{
+		"two_factor_required": true,
+		"challenge_token":     token,
+		"expires_in":          int(challengeExpiry.Seconds()),
+	}, nil
+}
+
+// reauthenticate checks the password, if the user has one, and a second factor. Failures count
+// towards the login lockout like failed logins, so a stolen access token can't be used to guess
+// them.
+func (h *handler) reauthenticate(c *gin.Context, stores store.Stores, user *User) bool {
+	var body ReauthRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return false
+	}
+
+	if h.waitForLogin(c, user.Username) {
+		return false
+	}
+
+	if user.PasswordHash != "" && !checkHash(body.Password, user.PasswordHash) {
+		h.failLogin(c, stores, user.Username, user)
+		c.AbortWithStatus(http.StatusForbidden)
+		return false
+	}
+
+	if !useSecondFactor(stores, user, body.Code) {
+		h.failLogin(c, stores, user.Username, user)
+		c.AbortWithStatusJSON(http.StatusForbidden, JSON{"error": "Invalid code"})
+		return false
+	}
+
+	return true
+}
+
+// setupTwoFactor starts the enrollment by generating a new secret. Two-factor authentication
+// stays off until the user confirms they can generate codes with it.
+func setupTwoFactor(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	if user.TwoFactorEnabled() {
+		c.AbortWithStatusJSON(http.StatusConflict, JSON{"error": "Two-factor authentication is already on"})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Username,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := stores.Users.SetTOTPSecret(&user, key.Secret()); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, JSON{
+		"secret": key.Secret(),
+		"uri":    key.URL(),
+	})
+}
+
+// confirmTwoFactor turns two-factor authentication on with the first code from the new secret
+// and gives out the recovery codes
+func confirmTwoFactor(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	var body TwoFactorCodeRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if user.TOTPSecret == "" || user.TwoFactorEnabled() {
+		c.AbortWithStatusJSON(http.StatusConflict, JSON{"error": "No enrollment in progress"})
+		return
+	}
+
+	if !useTOTP(stores, &user, body.Code) {
+		c.AbortWithStatusJSON(http.StatusForbidden, JSON{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := newRecoveryCodes(stores, user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := stores.Users.EnableTOTP(&user); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, JSON{
+		"user":           serializeAccount(user),
+		"recovery_codes": codes,
+	})
+}
+
+// disableTwoFactor turns two-factor authentication off and removes the recovery codes
+func (h *handler) disableTwoFactor(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	if !user.TwoFactorEnabled() {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if !h.reauthenticate(c, stores, &user) {
+		return
+	}
+
+	if err := stores.Users.SetTOTPSecret(&user, ""); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := stores.RecoveryCodes.Replace(user, nil); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializeAccount(user))
+}
+
+// regenerateRecoveryCodes replaces the recovery codes, for example when the user has used
+// most of them or lost the list
+func (h *handler) regenerateRecoveryCodes(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	user := c.MustGet("user").(User)
+
+	if !user.TwoFactorEnabled() {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if !h.reauthenticate(c, stores, &user) {
+		return
+	}
+
+	codes, err := newRecoveryCodes(stores, user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, JSON{"recovery_codes": codes})
+}
+
+// getTwoFactor tells if two-factor authentication is on and how many recovery codes are left
+func getTwoFactor(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	remaining, err := middlewares.Stores(c).RecoveryCodes.CountUnused(user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, JSON{
+		"enabled":                  user.TwoFactorEnabled(),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// loginChallenge finishes a login with the challenge token and a second factor. Wrong codes
+// count as failed logins, so the codes can't be guessed either.
+func (h *handler) loginChallenge(c *gin.Context) {
+	stores := middlewares.Stores(c)
+
+	var body ChallengeRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.parseChallengeToken(stores.Users, body.ChallengeToken)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, JSON{"error": err.Error()})
+		return
+	}
+
+	if user.Suspended() {
+		c.AbortWithStatusJSON(http.StatusForbidden, JSON{"error": "Account suspended"})
+		return
+	}
+
+	if h.waitForLogin(c, user.Username) {
+		return
+	}
+
+	if !useSecondFactor(stores, &user, body.Code) {
+		h.failLogin(c, stores, user.Username, &user)
+		c.AbortWithStatusJSON(http.StatusForbidden, JSON{"error": "Invalid code"})
+		return
+	}
+
+	h.loginGuard.Reset(lockout.UserKey(user.Username))
+	tokens, err := h.issueTokens(c, stores, user)
+	if err != nil {
+		log.Println("Failed to start a session for user", user.UUID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
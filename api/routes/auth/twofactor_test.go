@@ -0,0 +1,50 @@
+package auth_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/pquerna/otp/totp"
+)
+
+func TestReauthenticationFailuresAreLimited(t *testing.T) {
+	s := newServer(t, store.NewMemoryStores())
+	accessToken := s.register("author")
+
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	if code := s.request(http.MethodPost, "/api/auth/2fa/setup", accessToken, nil, &setup); code != http.StatusOK {
+		t.Fatalf("Setting up two-factor authentication returned %d", code)
+	}
+
+	code, err := totp.GenerateCode(setup.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("Could not generate a code: %v", err)
+	}
+
+	if status := s.request(http.MethodPost, "/api/auth/2fa/confirm", accessToken, common.JSON{"code": code}, nil); status != http.StatusOK {
+		t.Fatalf("Confirming two-factor authentication returned %d", status)
+	}
+
+	wrong := common.JSON{"password": "correct horse battery", "code": "000000"}
+	if status := s.request(http.MethodPost, "/api/auth/2fa/disable", accessToken, wrong, nil); status != http.StatusForbidden {
+		t.Fatalf("A wrong code should be rejected, got %d", status)
+	}
+
+	// the failure makes the user wait, so the codes can't be guessed one after another
+	for _, path := range []string{"/api/auth/2fa/disable", "/api/auth/2fa/recovery-codes"} {
+		if status := s.request(http.MethodPost, path, accessToken, wrong, nil); status != http.StatusTooManyRequests {
+			t.Errorf("%s should make the user wait after a failure, got %d", path, status)
+		}
+	}
+
+	// the wait applies to logging in with the password as well
+	login := common.JSON{"username": "author", "password": "correct horse battery"}
+	if status := s.request(http.MethodPost, "/api/auth/login", "", login, nil); status != http.StatusTooManyRequests {
+		t.Errorf("Logging in should wait after a failed reauthentication, got %d", status)
+	}
+}
@@ -0,0 +1,168 @@
+package feeds
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/syndication"
+)
+
+// Post model alias
+type Post = models.Post
+
+// handler serves the feeds with links to the site at baseURL and keeps the rendered feeds
+// in the cache
+type handler struct {
+	baseURL string
+	cache   *syndication.Cache
+}
+
+var errLoad = errors.New("Failed to load the feed's posts")
+
+// feedPage is the page of newest posts included in every feed
+var feedPage = common.Page{Limit: syndication.FeedSize}
+
+// loader finds the feed's channel and posts, the bool is false when the feed doesn't exist
+type loader func(baseURL string) (syndication.Channel, []Post, bool, error)
+
+func (h *handler) siteFeed(c *gin.Context) {
+	h.serveFeed(c, func(baseURL string) (syndication.Channel, []Post, bool, error) {
+		posts, _, err := middlewares.Stores(c).Posts.List(feedPage)
+		if err != nil {
+			return syndication.Channel{}, nil, true, errLoad
+		}
+
+		channel := syndication.Channel{
+			Title:       "go-blog",
+			Description: "The newest posts on go-blog",
+			Link:        baseURL + "/",
+		}
+
+		return channel, posts, true, nil
+	})
+}
+
+func (h *handler) userFeed(c *gin.Context) {
+	url := c.Param("url")
+
+	h.serveFeed(c, func(baseURL string) (syndication.Channel, []Post, bool, error) {
+		stores := middlewares.Stores(c)
+		user, err := stores.Users.FindByURL(url)
+		if err != nil {
+			return syndication.Channel{}, nil, false, nil
+		}
+
+		posts, _, err := stores.Posts.ListByUser(user, feedPage, true)
+		if err != nil {
+			return syndication.Channel{}, nil, true, errLoad
+		}
+
+		channel := syndication.Channel{
+			Title:       user.Username + " on go-blog",
+			Description: "The newest posts by " + user.Username,
+			Link:        baseURL + "/profile/" + user.URL,
+		}
+
+		return channel, posts, true, nil
+	})
+}
+
+func (h *handler) topicFeed(c *gin.Context) {
+	url := c.Param("url")
+
+	h.serveFeed(c, func(baseURL string) (syndication.Channel, []Post, bool, error) {
+		stores := middlewares.Stores(c)
+		topic, err := stores.Topics.FindByURL(url)
+		if err != nil {
+			return syndication.Channel{}, nil, false, nil
+		}
+
+		posts, _, err := stores.Posts.ListByTopic(topic, feedPage)
+		if err != nil {
+			return syndication.Channel{}, nil, true, errLoad
+		}
+
+		channel := syndication.Channel{
+			Title:       topic.Title + " on go-blog",
+			Description: topic.Description,
+			Link:        baseURL + "/topic/" + topic.URL,
+		}
+
+		return channel, posts, true, nil
+	})
+}
+
+// serveFeed answers from the cache when possible and only loads the feed when it has expired.
+// Readers which already have the newest version get a 304 response without a body.
+func (h *handler) serveFeed(c *gin.Context, load loader) {
+	format := c.Param("format")
+	if !syndication.ValidFormat(format) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	key := c.Request.URL.Path
+
+	entry, ok := h.cache.Get(key)
+	if !ok {
+		channel, posts, found, err := load(h.baseURL)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if !found {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		feed, ok := syndication.Build(middlewares.Stores(c), channel, posts, h.baseURL, format)
+		if !ok {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		entry = h.cache.Set(key, feed)
+	}
+
+	c.Header("ETag", entry.ETag)
+	c.Header("Last-Modified", entry.LastModified.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(syndication.CacheTTL.Seconds())))
+
+	if notModified(c.Request, entry) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, entry.ContentType, entry.Body)
+}
+
+// notModified checks the request's validators. If-None-Match takes precedence over
+// If-Modified-Since like in RFC 7232.
+func notModified(request *http.Request, entry syndication.Entry) bool {
+	if match := request.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == entry.ETag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(request.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	// the header only has second precision
+	return !entry.LastModified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,27 @@
+package feeds
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/syndication"
+)
+
+// ApplyRoutes adds feed routes to gin engine. The links in the feeds start with the site's
+// public address.
+func ApplyRoutes(r *gin.RouterGroup, stores store.Stores, publicURL string) {
+	h := &handler{
+		baseURL: strings.TrimSuffix(publicURL, "/"),
+		cache:   syndication.NewCache(syndication.CacheTTL),
+	}
+
+	feeds := r.Group("/feeds")
+	feeds.Use(middlewares.InjectStores(stores))
+	{
+		feeds.GET("/:format", h.siteFeed)
+		feeds.GET("/user/:url/:format", h.userFeed)
+		feeds.GET("/topic/:url/:format", h.topicFeed)
+	}
+}
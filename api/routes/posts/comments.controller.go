@@ -0,0 +1,162 @@
+package posts
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
+)
+
+// Comment model alias
+type Comment = models.Comment
+
+// CommentRequestBody is the request definition used when creating and editing comments
+type CommentRequestBody struct {
+	Content string `json:"content" binding:"required"`
+	// ParentID is the uuid of the comment being replied to, it is ignored when editing
+	ParentID string `json:"parent_id"`
+}
+
+func getComments(c *gin.Context) {
+	postID := c.Param("id")
+
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	post, err := middlewares.Stores(c).Posts.FindByUUID(postID)
+	if err != nil || !policy.CanPost(middlewares.CurrentUser(c), policy.Read, post) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	comments, next, err := middlewares.Stores(c).Comments.Tree(post, page)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"comments":    comments,
+		"next_cursor": common.EncodeCursor(next),
+	})
+}
+
+func createComment(c *gin.Context) {
+	postID := c.Param("id")
+	user := c.MustGet("user").(User)
+
+	var body CommentRequestBody
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	stores := middlewares.Stores(c)
+	post, err := stores.Posts.FindByUUID(postID)
+	if err != nil || !policy.CanPost(&user, policy.Read, post) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	comment := Comment{
+		UUID:    common.CreateUUID(),
+		Content: body.Content,
+		PostID:  post.ID,
+		UserID:  user.ID,
+	}
+
+	if body.ParentID != "" {
+		parent, err := stores.Comments.FindByUUID(body.ParentID)
+		if err != nil || parent.PostID != post.ID {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		comment.ParentID = parent.ID
+	}
+
+	if err := stores.Comments.Create(&comment); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, stores.Comments.Serialize(comment))
+}
+
+func updateComment(c *gin.Context) {
+	postID := c.Param("id")
+	commentID := c.Param("commentID")
+	user := c.MustGet("user").(User)
+
+	var body CommentRequestBody
+	if err := c.BindJSON(&body); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	comment, post, ok := findCommentInPost(c, postID, commentID)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if !policy.CanComment(&user, policy.Update, comment, post) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	comment.Content = body.Content
+
+	stores := middlewares.Stores(c)
+	if err := stores.Comments.Save(&comment); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, stores.Comments.Serialize(comment))
+}
+
+func removeComment(c *gin.Context) {
+	postID := c.Param("id")
+	commentID := c.Param("commentID")
+	user := c.MustGet("user").(User)
+
+	comment, post, ok := findCommentInPost(c, postID, commentID)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if !policy.CanComment(&user, policy.Delete, comment, post) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if err := middlewares.Stores(c).Comments.Remove(&comment); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// findCommentInPost finds a comment which hasn't been removed and the post it belongs to
+func findCommentInPost(c *gin.Context, postID, commentID string) (Comment, Post, bool) {
+	post, err := middlewares.Stores(c).Posts.FindByUUID(postID)
+	if err != nil {
+		return Comment{}, post, false
+	}
+
+	comment, err := middlewares.Stores(c).Comments.FindByUUID(commentID)
+	if err != nil || comment.PostID != post.ID || comment.Removed {
+		return comment, post, false
+	}
+
+	return comment, post, true
+}
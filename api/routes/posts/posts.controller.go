@@ -1,11 +1,20 @@
 package posts
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/search"
+	"github.com/nireo/go-blog-api/database/store"
 	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
+	"github.com/nireo/go-blog-api/lib/render"
 )
 
 // Define topics, so we can check if the topic given in request is valid
@@ -26,6 +35,68 @@ type Topic = models.Topic
 // JSON type alias
 type JSON = common.JSON
 
+// ParagraphRequest is a single content block in a request. Data holds the block's fields, but
+// older clients can send only the content instead. When the whole document is saved, the uuid
+// tells which of the post's paragraphs the block updates.
+type ParagraphRequest struct {
+	UUID    string          `json:"uuid"`
+	Type    string          `json:"type" binding:"required"`
+	Content string          `json:"content"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// buildParagraphs validates the blocks in a request and creates unsaved paragraphs from them
+func buildParagraphs(requests []ParagraphRequest) ([]Paragraph, error) {
+	paragraphs := make([]Paragraph, len(requests), len(requests))
+	for index := range requests {
+		paragraph, err := models.BuildParagraph(requests[index].Type, requests[index].Content, requests[index].Data)
+		if err != nil {
+			return nil, fmt.Errorf("Paragraph %d: %v", index, err)
+		}
+
+		paragraph.UUID = requests[index].UUID
+		paragraphs[index] = paragraph
+	}
+
+	return paragraphs, nil
+}
+
+// reindex updates the post's search index entry. A failure only makes search results stale,
+// so it is logged instead of failing the request.
+func reindex(c *gin.Context, post Post) {
+	if err := middlewares.Stores(c).Search.Index(post); err != nil {
+		log.Println("Failed to index post", post.UUID, err)
+	}
+}
+
+// lifecycle checks the requested status and publish time. An empty status publishes the post
+// right away and scheduled posts need a publish time in the future. The times are kept in
+// UTC, since SQLite compares them as text and a different offset would break the order.
+func lifecycle(status string, publishAt *time.Time) (string, *time.Time, bool) {
+	now := time.Now().UTC()
+
+	switch status {
+	case "", models.PostStatusPublished:
+		return models.PostStatusPublished, &now, true
+	case models.PostStatusScheduled:
+		if publishAt == nil || !publishAt.After(now) {
+			return "", nil, false
+		}
+
+		utc := publishAt.UTC()
+		return status, &utc, true
+	case models.PostStatusDraft, models.PostStatusArchived:
+		return status, nil, true
+	}
+
+	return "", nil, false
+}
+
+// serializePost formats a single post for the viewer, who can be nil
+func serializePost(c *gin.Context, post Post, viewer *User) JSON {
+	return middlewares.Stores(c).Posts.Serialize([]Post{post}, viewer)[0]
+}
+
 // function for checking if topic is valid
 func checkIfValid(topic string) bool {
 	valid := false
@@ -38,20 +109,17 @@ func checkIfValid(topic string) bool {
 }
 
 func create(c *gin.Context) {
-	db := common.GetDatabase()
+	stores := middlewares.Stores(c)
 	user := c.MustGet("user").(User)
 
-	type ParagraphJSON struct {
-		Type    string `json:"type" binding:"required"`
-		Content string `json:"content" binding:"required"`
-	}
-
 	type RequestBody struct {
-		Title       string          `json:"title" binding:"required"`
-		Description string          `json:"description" binding:"required"`
-		ImageURL    string          `json:"imageURL" binding:"required"`
-		Topic       string          `json:"topic" binding:"required"`
-		Paragraphs  []ParagraphJSON `json:"paragraphs" binding:"required"`
+		Title       string             `json:"title" binding:"required"`
+		Description string             `json:"description" binding:"required"`
+		ImageURL    string             `json:"imageURL" binding:"required"`
+		Topic       string             `json:"topic" binding:"required"`
+		Paragraphs  []ParagraphRequest `json:"paragraphs" binding:"required,dive"`
+		Status      string             `json:"status"`
+		PublishAt   *time.Time         `json:"publish_at"`
 	}
 
 	var requestBody RequestBody
@@ -60,7 +128,19 @@ func create(c *gin.Context) {
 		return
 	}
 
-	topic, err := models.FindOneTopic(&Topic{URL: requestBody.Topic})
+	paragraphs, err := buildParagraphs(requestBody.Paragraphs)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	status, publishAt, ok := lifecycle(requestBody.Status, requestBody.PublishAt)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	topic, err := stores.Topics.FindByURL(requestBody.Topic)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
@@ -76,72 +156,116 @@ func create(c *gin.Context) {
 		ImageURL:    requestBody.ImageURL,
 		UUID:        common.CreateUUID(),
 		TopicID:     topic.ID,
+		Status:      status,
+		PublishAt:   publishAt,
 	}
 
-	post.Save()
-
-	// create database entries for paragraphs
-	for index := range requestBody.Paragraphs {
-		newParagraph := Paragraph{
-			Type:    requestBody.Paragraphs[index].Type,
-			Content: requestBody.Paragraphs[index].Content,
-			PostID:  post.ID,
-			UUID:    common.CreateUUID(),
-		}
+	for index := range paragraphs {
+		paragraphs[index].UUID = common.CreateUUID()
+	}
 
-		db.NewRecord(newParagraph)
-		db.Create(&newParagraph)
+	if err := stores.Posts.Create(&post, paragraphs); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
 
-	c.JSON(http.StatusOK, post.Serialize())
+	recordRevision(c, post, user)
+	reindex(c, post)
+	c.JSON(http.StatusOK, serializePost(c, post, nil))
 }
 
 func list(c *gin.Context) {
-	posts, ok := models.GetPosts(0, 10)
-	if !ok {
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	stores := middlewares.Stores(c)
+	posts, next, err := stores.Posts.List(page)
+	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	c.JSON(http.StatusOK, models.SerializePosts(posts))
+	c.JSON(http.StatusOK, gin.H{
+		"posts":       stores.Posts.Serialize(posts, middlewares.CurrentUser(c)),
+		"next_cursor": common.EncodeCursor(next),
+	})
 }
 
+// feed returns the newest posts from the users and topics the user follows
+func feed(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	stores := middlewares.Stores(c)
+	posts, next, err := stores.Posts.Feed(user, page)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"posts":       stores.Posts.Serialize(posts, &user),
+		"next_cursor": common.EncodeCursor(next),
+	})
+}
+
+// postFromID returns the post and its paragraphs. The 'format' parameter can be used to get
+// the post rendered into 'html' or 'markdown' instead.
 func postFromID(c *gin.Context) {
 	postID := c.Param("id")
+	format := c.Query("format")
+	if format != "" && format != "html" && format != "markdown" && format != "json" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
 
-	post, err := models.FindOnePost(&Post{UUID: postID})
-	if err != nil {
+	stores := middlewares.Stores(c)
+	post, err := stores.Posts.FindByUUID(postID)
+	if err != nil || !policy.CanPost(middlewares.CurrentUser(c), policy.Read, post) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	paragraphs, ok := models.GetParagraphsRelatedToPost(post)
-	if !ok {
+	paragraphs, err := stores.Posts.Paragraphs(post)
+	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	switch format {
+	case "html":
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(render.PostHTML(post, paragraphs)))
+		return
+	case "markdown":
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(render.PostMarkdown(post, paragraphs)))
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"post":       post.Serialize(),
+		"post":       serializePost(c, post, middlewares.CurrentUser(c)),
 		"paragraphs": models.SerializeParagraphs(paragraphs),
 	})
 }
 
+// update saves the whole document, the paragraphs in the request replace the post's current
+// paragraphs in the given order. Paragraphs sent with their uuid keep it, the others are new.
 func update(c *gin.Context) {
-	db := common.GetDatabase()
 	postID := c.Param("id")
 	user := c.MustGet("user").(User)
 
-	type ParagraphJSON struct {
-		Type    string `json:"type" binding:"required"`
-		Content string `json:"content" binding:"required"`
-	}
-
 	type RequestBody struct {
-		Text        string          `json:"text" binding:"required"`
-		Title       string          `json:"title" binding:"required"`
-		Description string          `json:"description" binding:"required"`
-		Paragraphs  []ParagraphJSON `json:"paragraphs" binding:"required"`
+		Text        string             `json:"text" binding:"required"`
+		Title       string             `json:"title" binding:"required"`
+		Description string             `json:"description" binding:"required"`
+		Paragraphs  []ParagraphRequest `json:"paragraphs" binding:"required,dive"`
 	}
 
 	var requestBody RequestBody
@@ -150,80 +274,203 @@ func update(c *gin.Context) {
 		return
 	}
 
-	post, err := models.FindOnePost(&Post{UUID: postID})
+	paragraphs, err := buildParagraphs(requestBody.Paragraphs)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	post, err := middlewares.Stores(c).Posts.FindByUUID(postID)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	if post.UserID != user.ID {
+	if !policy.CanPost(&user, policy.Update, post) {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
+	ensureRevision(c, post)
+
 	post.Text = requestBody.Text
 	post.Title = requestBody.Title
 	post.Description = requestBody.Description
 
-	db.Save(&post)
-	c.JSON(http.StatusOK, post.Serialize())
+	if err := middlewares.Stores(c).Posts.ReplaceContent(&post, paragraphs); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	recordRevision(c, post, user)
+	reindex(c, post)
+	c.JSON(http.StatusOK, gin.H{
+		"post":       serializePost(c, post, &user),
+		"paragraphs": models.SerializeParagraphs(paragraphs),
+	})
 }
 
-func handleLike(c *gin.Context) {
-	db := common.GetDatabase()
-	postID := c.Param("postId")
-	user := c.MustGet("user").(models.User)
+// updateStatus moves the post between the draft, scheduled, published and archived states
+func updateStatus(c *gin.Context) {
+	stores := middlewares.Stores(c)
+	postID := c.Param("id")
+	user := c.MustGet("user").(User)
 
-	post, err := models.FindOnePost(&Post{UUID: postID})
+	type RequestBody struct {
+		Status    string     `json:"status" binding:"required"`
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	var requestBody RequestBody
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	status, publishAt, ok := lifecycle(requestBody.Status, requestBody.PublishAt)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	post, err := stores.Posts.FindByUUID(postID)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
 
-	postLike, err := models.FindPostLikeModel(&models.PostLike{UserID: user.ID, LikedPostID: post.ID})
-	if err == nil {
+	if !policy.CanPost(&user, policy.Update, post) {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	// unneeded, but go keeps complaining for literally no reason
-	if postLike.ID > 0 {
+	// keep the original publish time when a published post is saved as published again
+	if status == models.PostStatusPublished && post.Status == models.PostStatusPublished && post.PublishAt != nil {
+		publishAt = post.PublishAt
+	}
+
+	post.Status = status
+	post.PublishAt = publishAt
+
+	if err := stores.Posts.Save(&post); err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	post.Likes = post.Likes + 1
-	db.Save(&post)
-	c.JSON(http.StatusOK, post.Serialize())
+	c.JSON(http.StatusOK, serializePost(c, post, &user))
+}
+
+func likePost(c *gin.Context) {
+	postID := c.Param("postID")
+	user := c.MustGet("user").(User)
+
+	post, err := middlewares.Stores(c).Posts.FindByUUID(postID)
+	if err != nil || !policy.CanPost(&user, policy.Read, post) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err := middlewares.Stores(c).Likes.Like(user, &post); err != nil {
+		if err == store.ErrConflict {
+			c.AbortWithStatus(http.StatusConflict)
+			return
+		}
+
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializePost(c, post, &user))
+}
+
+func unlikePost(c *gin.Context) {
+	postID := c.Param("postID")
+	user := c.MustGet("user").(User)
+
+	post, err := middlewares.Stores(c).Posts.FindByUUID(postID)
+	if err != nil || !policy.CanPost(&user, policy.Read, post) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err := middlewares.Stores(c).Likes.Unlike(user, &post); err != nil {
+		if err == store.ErrNotFound {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, serializePost(c, post, &user))
+}
+
+// getLikes lists the users who have liked a post
+func getLikes(c *gin.Context) {
+	postID := c.Param("postID")
+
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	post, err := middlewares.Stores(c).Posts.FindByUUID(postID)
+	if err != nil || !policy.CanPost(middlewares.CurrentUser(c), policy.Read, post) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	users, next, err := middlewares.Stores(c).Likes.Likers(post, page)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       models.SerializeUsers(users),
+		"next_cursor": common.EncodeCursor(next),
+	})
 }
 
 func remove(c *gin.Context) {
 	postID := c.Param("id")
 	user := c.MustGet("user").(User)
 
-	post, err := models.FindOnePost(&Post{UUID: postID})
+	stores := middlewares.Stores(c)
+	post, err := stores.Posts.FindByUUID(postID)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	if post.UserID != user.ID {
+	if !policy.CanPost(&user, policy.Delete, post) {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	post.Delete()
-	c.Status(http.StatusForbidden)
+	if err := stores.Posts.Delete(&post); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := stores.Search.Remove(post); err != nil {
+		log.Println("Failed to remove post from search index", post.UUID, err)
+	}
+
+	c.Status(http.StatusNoContent)
 }
 
-// add new paragraph at the end of the content
+// addNewParagraph inserts a paragraph at the given position, or at the end of the content if
+// no position is given
 func addNewParagraph(c *gin.Context) {
-	db := common.GetDatabase()
 	user := c.MustGet("user").(User)
 	postID := c.Param("id")
 
 	type RequestBody struct {
-		Type    string `json:"type" binding:"required"`
-		Content string `json:"content" binding:"required"`
+		ParagraphRequest
+		Position *int `json:"position"`
 	}
 
 	var requestBody RequestBody
@@ -232,82 +479,268 @@ func addNewParagraph(c *gin.Context) {
 		return
 	}
 
-	post, err := models.FindOnePost(&Post{UUID: postID})
+	newParagraph, err := models.BuildParagraph(requestBody.Type, requestBody.Content, requestBody.Data)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	post, err := middlewares.Stores(c).Posts.FindByUUID(postID)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	if post.UserID != user.ID {
+	if !policy.CanPost(&user, policy.Update, post) {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	newParagraph := Paragraph{
-		Type:    requestBody.Type,
-		Content: requestBody.Content,
+	ensureRevision(c, post)
+
+	// a negative position adds the paragraph to the end
+	position := -1
+	if requestBody.Position != nil {
+		position = *requestBody.Position
 	}
 
-	db.NewRecord(newParagraph)
-	db.Save(&newParagraph)
+	newParagraph.UUID = common.CreateUUID()
+	if err := middlewares.Stores(c).Posts.InsertParagraph(post, &newParagraph, position); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	recordRevision(c, post, user)
+	reindex(c, post)
 
 	c.JSON(http.StatusOK, newParagraph.Serialize())
 }
 
-func deleteParagraph(c *gin.Context) {
-	db := common.GetDatabase()
-	user := c.MustGet("user").(User)
-	paragraphID := c.Param("id")
+// getBlockTypes returns the JSON Schema of every block type a paragraph can contain
+func getBlockTypes(c *gin.Context) {
+	c.JSON(http.StatusOK, models.BlockSchemas())
+}
 
-	var paragraph Paragraph
-	if err := db.Where("uuid = ?", paragraphID).First(&paragraph).Error; err != nil {
+// findEditableParagraph finds the paragraph with the id parameter and the post it belongs to, and
+// makes sure the user can do the action on the post. The response has already been written when
+// ok is false.
+func findEditableParagraph(c *gin.Context, user User, action policy.Action) (Paragraph, Post, bool) {
+	paragraph, err := middlewares.Stores(c).Posts.FindParagraph(c.Param("id"))
+	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
-		return
+		return paragraph, Post{}, false
 	}
 
-	// find the post paragraph is in, so that we can check for ownership
-	var post Post
-	if err := db.Where("id = ?", paragraph.PostID).First(&post).Error; err != nil {
+	// find the post paragraph is in, so that we can check for permissions
+	post, err := middlewares.Stores(c).Posts.FindByID(paragraph.PostID)
+	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
-		return
+		return paragraph, post, false
 	}
 
-	if user.ID != post.UserID {
+	if !policy.CanParagraph(&user, action, paragraph, post) {
 		c.AbortWithStatus(http.StatusForbidden)
+		return paragraph, post, false
+	}
+
+	return paragraph, post, true
+}
+
+// updateParagraph replaces the block stored in a single paragraph
+func updateParagraph(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	var requestBody ParagraphRequest
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	block, err := models.BuildParagraph(requestBody.Type, requestBody.Content, requestBody.Data)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	paragraph, post, ok := findEditableParagraph(c, user, policy.Update)
+	if !ok {
+		return
+	}
+
+	ensureRevision(c, post)
+
+	paragraph.Type = block.Type
+	paragraph.Content = block.Content
+	paragraph.Data = block.Data
+
+	if err := middlewares.Stores(c).Posts.SaveParagraph(&paragraph); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	recordRevision(c, post, user)
+	reindex(c, post)
+	c.JSON(http.StatusOK, paragraph.Serialize())
+}
+
+// moveParagraph moves a paragraph to a new position in its post
+func moveParagraph(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	type RequestBody struct {
+		Position *int `json:"position" binding:"required"`
+	}
+
+	var requestBody RequestBody
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	paragraph, post, ok := findEditableParagraph(c, user, policy.Update)
+	if !ok {
+		return
+	}
+
+	ensureRevision(c, post)
+
+	if err := middlewares.Stores(c).Posts.MoveParagraph(&paragraph, *requestBody.Position); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	db.Delete(&paragraph)
+	recordRevision(c, post, user)
+	reindex(c, post)
+	c.JSON(http.StatusOK, paragraph.Serialize())
+}
+
+func deleteParagraph(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	paragraph, post, ok := findEditableParagraph(c, user, policy.Delete)
+	if !ok {
+		return
+	}
+
+	ensureRevision(c, post)
+
+	if err := middlewares.Stores(c).Posts.DeleteParagraph(paragraph); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	recordRevision(c, post, user)
+	reindex(c, post)
 	c.Status(http.StatusNoContent)
 }
 
+// searchForPost searches the title, description and paragraphs of posts. The search text can be
+// given in the path or the 'q' parameter and results can be filtered with the 'topic' and
+// 'author' parameters, which take the topic's and the user's url.
 func searchForPost(c *gin.Context) {
-	db := common.GetDatabase()
-	search := c.Param("search")
+	text := c.Param("search")
+	if text == "" {
+		text = c.Query("q")
+	}
 
-	var posts []Post
-	if err := db.Where("title LIKE ?", search).Find(&posts).Error; err != nil {
-		c.AbortWithStatus(http.StatusNotFound)
+	limit, err := common.ParseLimit(c)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	c.JSON(http.StatusOK, models.SerializePosts(posts))
+	stores := middlewares.Stores(c)
+	query := search.Query{
+		Text:  text,
+		Limit: limit,
+	}
+
+	if token := c.Query("cursor"); token != "" {
+		offset, err := search.DecodeOffset(token)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+
+		query.Offset = offset
+	}
+
+	if topicURL := c.Query("topic"); topicURL != "" {
+		topic, err := stores.Topics.FindByURL(topicURL)
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		query.TopicID = topic.ID
+	}
+
+	if authorURL := c.Query("author"); authorURL != "" {
+		author, err := stores.Users.FindByURL(authorURL)
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		query.UserID = author.ID
+	}
+
+	// ask for one extra result to find out if there is another page
+	query.Limit++
+	results, err := stores.Search.Search(query)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var next interface{}
+	if len(results) > limit {
+		results = results[:limit]
+		next = search.EncodeOffset(query.Offset + limit)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":     search.SerializeResults(results, stores.Posts.Serialize(search.Posts(results), middlewares.CurrentUser(c))),
+		"next_cursor": next,
+	})
 }
 
 // gets basically all the needed information for dashboard page.
-// this is used so that we can lower the needed amount of controllers
+// this is used so that we can lower the needed amount of controllers.
+// posts and topics are paginated separately with the 'cursor' and 'topics_cursor' parameters
 func dashboardController(c *gin.Context) {
-	db := common.GetDatabase()
 	user := c.MustGet("user").(User)
 
-	var topics []Topic
-	db.Model(&user).Related(&topics)
+	postPage, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	topicPage, err := common.ParsePage(c, "topics")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
 
-	var posts []Post
-	db.Model(&user).Related(&posts)
+	stores := middlewares.Stores(c)
+	topics, nextTopics, err := stores.Topics.ListByUser(user, topicPage)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// the dashboard is only shown to the author, so it includes drafts and scheduled posts
+	posts, nextPosts, err := stores.Posts.ListByUser(user, postPage, false)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"posts":  models.SerializePosts(posts),
-		"topics": models.SerializeTopics(topics),
+		"posts":              stores.Posts.Serialize(posts, &user),
+		"topics":             models.SerializeTopics(topics),
+		"next_cursor":        common.EncodeCursor(nextPosts),
+		"next_topics_cursor": common.EncodeCursor(nextTopics),
 	})
 }
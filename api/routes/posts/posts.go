@@ -2,25 +2,47 @@ package posts
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/store"
 	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
 )
 
-// ApplyRoutes adds post routes to gin engine
-func ApplyRoutes(r *gin.RouterGroup) {
+// ApplyRoutes adds post routes to gin engine. Personal access tokens read posts and their
+// comments with the posts:read scope, and change them with posts:write or comments:write.
+func ApplyRoutes(r *gin.RouterGroup, stores store.Stores) {
 	posts := r.Group("/posts")
+	posts.Use(middlewares.InjectStores(stores))
 	{
-		posts.GET("/", list)
-		posts.GET("/single/:id", postFromID)
-		posts.GET("/dashboard", middlewares.Authorized, dashboardController)
-		posts.GET("/search/:search", searchForPost)
+		posts.GET("/", middlewares.Scope(policy.PostsRead), list)
+		posts.GET("/single/:id", middlewares.Scope(policy.PostsRead), postFromID)
+		posts.GET("/dashboard", middlewares.Scope(policy.PostsRead), middlewares.Authorized, dashboardController)
+		posts.GET("/feed", middlewares.Scope(policy.PostsRead), middlewares.Authorized, feed)
+		posts.GET("/blocks", getBlockTypes)
+		posts.GET("/search", middlewares.Scope(policy.PostsRead), searchForPost)
+		posts.GET("/search/:search", middlewares.Scope(policy.PostsRead), searchForPost)
 
-		posts.POST("/", middlewares.Authorized, create)
-		posts.POST("/paragraph/:id", middlewares.Authorized, addNewParagraph)
-		posts.POST("/like/:postID", middlewares.Authorized, handleLike)
+		posts.POST("/", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, create)
+		posts.POST("/paragraph/:id", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, addNewParagraph)
+		posts.GET("/likes/:postID", middlewares.Scope(policy.PostsRead), getLikes)
+		posts.POST("/like/:postID", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, likePost)
+		posts.DELETE("/like/:postID", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, unlikePost)
 
-		posts.PATCH("/:id", middlewares.Authorized, update)
+		posts.PATCH("/:id", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, update)
+		posts.PATCH("/paragraph/:id", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, updateParagraph)
+		posts.PATCH("/paragraph/:id/move", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, moveParagraph)
+		posts.PATCH("/:id/status", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, updateStatus)
 
-		posts.DELETE("/blog/:id", middlewares.Authorized, remove)
-		posts.DELETE("/paragraph/:id", middlewares.Authorized, deleteParagraph)
+		posts.DELETE("/blog/:id", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, remove)
+		posts.DELETE("/paragraph/:id", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, deleteParagraph)
+
+		posts.GET("/:id/revisions", middlewares.Scope(policy.PostsRead), middlewares.Authorized, getRevisions)
+		posts.GET("/:id/revisions/diff", middlewares.Scope(policy.PostsRead), middlewares.Authorized, diffRevisions)
+		posts.GET("/:id/revisions/:number", middlewares.Scope(policy.PostsRead), middlewares.Authorized, getRevision)
+		posts.POST("/:id/revisions/:number/restore", middlewares.Scope(policy.PostsWrite), middlewares.Authorized, restoreRevision)
+
+		posts.GET("/:id/comments", middlewares.Scope(policy.PostsRead), getComments)
+		posts.POST("/:id/comments", middlewares.Scope(policy.CommentsWrite), middlewares.Authorized, createComment)
+		posts.PATCH("/:id/comments/:commentID", middlewares.Scope(policy.CommentsWrite), middlewares.Authorized, updateComment)
+		posts.DELETE("/:id/comments/:commentID", middlewares.Scope(policy.CommentsWrite), middlewares.Authorized, removeComment)
 	}
 }
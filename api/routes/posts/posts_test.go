@@ -0,0 +1,318 @@
+package posts_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/api/routes/posts"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// server runs the post routes against in-memory stores. Requests act as the user named in
+// the X-Test-User header instead of carrying a token.
+type server struct {
+	t      *testing.T
+	router *gin.Engine
+	stores store.Stores
+}
+
+func newServer(t *testing.T) *server {
+	gin.SetMode(gin.TestMode)
+	s := &server{t: t, router: gin.New(), stores: store.NewMemoryStores()}
+
+	s.router.Use(func(c *gin.Context) {
+		if user, err := s.stores.Users.FindByUsername(c.GetHeader("X-Test-User")); err == nil {
+			c.Set("user", user)
+		}
+	})
+	posts.ApplyRoutes(s.router.Group("/api"), s.stores)
+	return s
+}
+
+func (s *server) user(name string) models.User {
+	user := models.User{Username: name, URL: name, UUID: common.CreateUUID()}
+	if err := s.stores.Users.Create(&user); err != nil {
+		s.t.Fatalf("Could not create user %s: %v", name, err)
+	}
+
+	return user
+}
+
+func (s *server) topic(url string, owner models.User) models.Topic {
+	topic := models.Topic{Title: url, URL: url, UUID: common.CreateUUID(), UserID: owner.ID}
+	if err := s.stores.Topics.Create(&topic); err != nil {
+		s.t.Fatalf("Could not create topic %s: %v", url, err)
+	}
+
+	return topic
+}
+
+// request sends the body as JSON and decodes the JSON response into out, which can be nil
+func (s *server) request(method, path, username string, body interface{}, out interface{}) int {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			s.t.Fatalf("Could not encode the request: %v", err)
+		}
+	}
+
+	request := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Test-User", username)
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+
+	if out != nil && recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("Could not decode the response of %s %s: %v", method, path, err)
+		}
+	}
+
+	return recorder.Code
+}
+
+func (s *server) createPost(author, topic string, status string, contents ...string) string {
+	paragraphs := make([]common.JSON, len(contents), len(contents))
+	for index := range contents {
+		paragraphs[index] = common.JSON{"type": "paragraph", "content": contents[index]}
+	}
+
+	var created struct {
+		UUID string `json:"uuid"`
+	}
+
+	code := s.request(http.MethodPost, "/api/posts/", author, common.JSON{
+		"title":       "Title of " + contents[0],
+		"description": "A post",
+		"imageURL":    "https://example.com/image.png",
+		"topic":       topic,
+		"paragraphs":  paragraphs,
+		"status":      status,
+	}, &created)
+	if code != http.StatusOK {
+		s.t.Fatalf("Creating the post returned %d", code)
+	}
+
+	return created.UUID
+}
+
+type singlePost struct {
+	Post struct {
+		Likes    int  `json:"likes"`
+		Comments int  `json:"comments"`
+		LikedBy  bool `json:"liked_by_me"`
+	} `json:"post"`
+	Paragraphs []struct {
+		UUID    string `json:"uuid"`
+		Content string `json:"content"`
+	} `json:"paragraphs"`
+}
+
+func TestPostContent(t *testing.T) {
+	s := newServer(t)
+	author := s.user("author")
+	s.user("reader")
+	s.topic("programming", author)
+
+	id := s.createPost("author", "programming", "", "first", "second", "third")
+
+	var post singlePost
+	if code := s.request(http.MethodGet, "/api/posts/single/"+id, "", nil, &post); code != http.StatusOK {
+		t.Fatalf("Reading the post returned %d", code)
+	}
+
+	if len(post.Paragraphs) != 3 || post.Paragraphs[0].Content != "first" {
+		t.Fatalf("Expected the 3 paragraphs in order, got %+v", post.Paragraphs)
+	}
+
+	// only the author can edit the post
+	move := common.JSON{"position": 0}
+	if code := s.request(http.MethodPatch, "/api/posts/paragraph/"+post.Paragraphs[2].UUID+"/move", "reader", move, nil); code != http.StatusForbidden {
+		t.Errorf("Moving someone else's paragraph should be forbidden, got %d", code)
+	}
+
+	if code := s.request(http.MethodPatch, "/api/posts/paragraph/"+post.Paragraphs[2].UUID+"/move", "author", move, nil); code != http.StatusOK {
+		t.Fatalf("Moving the paragraph returned %d", code)
+	}
+
+	if code := s.request(http.MethodDelete, "/api/posts/paragraph/"+post.Paragraphs[1].UUID, "author", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("Deleting the paragraph returned %d", code)
+	}
+
+	s.request(http.MethodGet, "/api/posts/single/"+id, "", nil, &post)
+	if len(post.Paragraphs) != 2 || post.Paragraphs[0].Content != "third" || post.Paragraphs[1].Content != "first" {
+		t.Errorf("Expected the moved paragraph first and the deleted one gone, got %+v", post.Paragraphs)
+	}
+
+	// the create and both edits were recorded
+	var revisions struct {
+		Revisions []struct {
+			Number int `json:"number"`
+		} `json:"revisions"`
+	}
+	if code := s.request(http.MethodGet, "/api/posts/"+id+"/revisions", "author", nil, &revisions); code != http.StatusOK {
+		t.Fatalf("Listing the revisions returned %d", code)
+	}
+
+	if len(revisions.Revisions) != 3 || revisions.Revisions[0].Number != 3 {
+		t.Fatalf("Expected 3 revisions newest first, got %+v", revisions.Revisions)
+	}
+
+	if code := s.request(http.MethodPost, "/api/posts/"+id+"/revisions/1/restore", "author", nil, nil); code != http.StatusOK {
+		t.Fatalf("Restoring the first revision returned %d", code)
+	}
+
+	s.request(http.MethodGet, "/api/posts/single/"+id, "", nil, &post)
+	if len(post.Paragraphs) != 3 || post.Paragraphs[1].Content != "second" {
+		t.Errorf("Expected the original paragraphs back, got %+v", post.Paragraphs)
+	}
+}
+
+func TestLikesAndComments(t *testing.T) {
+	s := newServer(t)
+	author := s.user("author")
+	s.user("reader")
+	s.topic("programming", author)
+
+	id := s.createPost("author", "programming", "", "content")
+
+	if code := s.request(http.MethodPost, "/api/posts/like/"+id, "", nil, nil); code != http.StatusForbidden {
+		t.Errorf("Liking anonymously should be forbidden, got %d", code)
+	}
+
+	if code := s.request(http.MethodPost, "/api/posts/like/"+id, "reader", nil, nil); code != http.StatusOK {
+		t.Fatalf("Liking the post returned %d", code)
+	}
+
+	if code := s.request(http.MethodPost, "/api/posts/like/"+id, "reader", nil, nil); code != http.StatusConflict {
+		t.Errorf("Liking the post twice should conflict, got %d", code)
+	}
+
+	var comment struct {
+		UUID string `json:"uuid"`
+	}
+	if code := s.request(http.MethodPost, "/api/posts/"+id+"/comments", "reader", common.JSON{"content": "Nice"}, &comment); code != http.StatusOK {
+		t.Fatalf("Commenting returned %d", code)
+	}
+
+	reply := common.JSON{"content": "Thanks", "parent_id": comment.UUID}
+	if code := s.request(http.MethodPost, "/api/posts/"+id+"/comments", "author", reply, nil); code != http.StatusOK {
+		t.Fatalf("Replying returned %d", code)
+	}
+
+	var post singlePost
+	s.request(http.MethodGet, "/api/posts/single/"+id, "reader", nil, &post)
+	if post.Post.Likes != 1 || post.Post.Comments != 2 || !post.Post.LikedBy {
+		t.Errorf("Expected 1 like by the reader and 2 comments, got %+v", post.Post)
+	}
+
+	var thread struct {
+		Comments []struct {
+			Content string `json:"content"`
+			Replies []struct {
+				Content string `json:"content"`
+			} `json:"replies"`
+		} `json:"comments"`
+	}
+	s.request(http.MethodGet, "/api/posts/"+id+"/comments", "", nil, &thread)
+	if len(thread.Comments) != 1 || len(thread.Comments[0].Replies) != 1 || thread.Comments[0].Replies[0].Content != "Thanks" {
+		t.Errorf("Expected the reply nested under the comment, got %+v", thread.Comments)
+	}
+
+	if code := s.request(http.MethodDelete, "/api/posts/like/"+id, "reader", nil, nil); code != http.StatusOK {
+		t.Fatalf("Unliking the post returned %d", code)
+	}
+
+	if code := s.request(http.MethodDelete, "/api/posts/like/"+id, "reader", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Unliking the post twice should return 404, got %d", code)
+	}
+}
+
+func TestDraftsAndSearch(t *testing.T) {
+	s := newServer(t)
+	author := s.user("author")
+	s.user("reader")
+	s.topic("programming", author)
+
+	published := s.createPost("author", "programming", "", "about gophers")
+	draft := s.createPost("author", "programming", "draft", "gophers in drafts")
+
+	if code := s.request(http.MethodGet, "/api/posts/single/"+draft, "reader", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Other users shouldn't see drafts, got %d", code)
+	}
+
+	if code := s.request(http.MethodGet, "/api/posts/single/"+draft, "author", nil, nil); code != http.StatusOK {
+		t.Errorf("The author should see their draft, got %d", code)
+	}
+
+	// a like left on the post before it became a draft doesn't let the reader see it
+	reader, _ := s.stores.Users.FindByUsername("reader")
+	post, _ := s.stores.Posts.FindByUUID(draft)
+	if err := s.stores.Likes.Like(reader, &post); err != nil {
+		t.Fatalf("Could not like the draft: %v", err)
+	}
+
+	if code := s.request(http.MethodDelete, "/api/posts/like/"+draft, "reader", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Unliking someone else's draft should return 404, got %d", code)
+	}
+
+	var results struct {
+		Results []struct {
+			Post struct {
+				UUID string `json:"uuid"`
+			} `json:"post"`
+		} `json:"results"`
+	}
+	if code := s.request(http.MethodGet, "/api/posts/search?q=Gophers", "", nil, &results); code != http.StatusOK {
+		t.Fatalf("Searching returned %d", code)
+	}
+
+	if len(results.Results) != 1 || results.Results[0].Post.UUID != published {
+		t.Errorf("Expected only the published post, got %+v", results.Results)
+	}
+}
+
+func TestModeratorDeletesParagraph(t *testing.T) {
+	s := newServer(t)
+	author := s.user("author")
+	s.user("reader")
+	s.topic("programming", author)
+
+	moderator := models.User{Username: "moderator", URL: "moderator", UUID: common.CreateUUID(), Role: models.RoleModerator}
+	if err := s.stores.Users.Create(&moderator); err != nil {
+		t.Fatalf("Could not create the moderator: %v", err)
+	}
+
+	id := s.createPost("author", "programming", "", "keep", "spam")
+
+	var post singlePost
+	s.request(http.MethodGet, "/api/posts/single/"+id, "", nil, &post)
+	spam := post.Paragraphs[1].UUID
+
+	if code := s.request(http.MethodDelete, "/api/posts/paragraph/"+spam, "reader", nil, nil); code != http.StatusForbidden {
+		t.Errorf("Other users shouldn't delete the paragraph, got %d", code)
+	}
+
+	// moderators can delete content, but not edit it
+	move := common.JSON{"position": 0}
+	if code := s.request(http.MethodPatch, "/api/posts/paragraph/"+spam+"/move", "moderator", move, nil); code != http.StatusForbidden {
+		t.Errorf("Moderators shouldn't move the paragraph, got %d", code)
+	}
+
+	if code := s.request(http.MethodDelete, "/api/posts/paragraph/"+spam, "moderator", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("The moderator should delete the paragraph, got %d", code)
+	}
+
+	s.request(http.MethodGet, "/api/posts/single/"+id, "", nil, &post)
+	if len(post.Paragraphs) != 1 || post.Paragraphs[0].Content != "keep" {
+		t.Errorf("Expected only the kept paragraph, got %+v", post.Paragraphs)
+	}
+}
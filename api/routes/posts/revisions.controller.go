@@ -0,0 +1,183 @@
+package posts
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
+)
+
+// recordRevision snapshots the post after an edit. A failure only leaves a gap in the history,
+// so it is logged instead of failing the request.
+func recordRevision(c *gin.Context, post Post, user User) {
+	if _, err := middlewares.Stores(c).Revisions.Snapshot(post, user); err != nil {
+		log.Println("Failed to store revision of post", post.UUID, err)
+	}
+}
+
+// ensureRevision stores the original version of posts created before revisions were tracked
+func ensureRevision(c *gin.Context, post Post) {
+	if err := middlewares.Stores(c).Revisions.Ensure(post); err != nil {
+		log.Println("Failed to store revision of post", post.UUID, err)
+	}
+}
+
+// findEditablePost finds the post with the id parameter and makes sure the user can edit it. The
+// response has already been written when ok is false.
+func findEditablePost(c *gin.Context, user User) (Post, bool) {
+	post, err := middlewares.Stores(c).Posts.FindByUUID(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return post, false
+	}
+
+	if !policy.CanPost(&user, policy.Update, post) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return post, false
+	}
+
+	return post, true
+}
+
+// findRevision finds the revision of the post with the number in the given parameter. The
+// response has already been written when ok is false.
+func findRevision(c *gin.Context, post Post, number string) (models.PostRevision, bool) {
+	parsed, err := strconv.Atoi(number)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return models.PostRevision{}, false
+	}
+
+	revision, err := middlewares.Stores(c).Revisions.Find(post, parsed)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return revision, false
+	}
+
+	return revision, true
+}
+
+func getRevisions(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	post, ok := findEditablePost(c, user)
+	if !ok {
+		return
+	}
+
+	revisions, next, err := middlewares.Stores(c).Revisions.List(post, page)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revisions":   models.SerializeRevisions(revisions),
+		"next_cursor": common.EncodeCursor(next),
+	})
+}
+
+func getRevision(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	post, ok := findEditablePost(c, user)
+	if !ok {
+		return
+	}
+
+	revision, ok := findRevision(c, post, c.Param("number"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, revision.Serialize())
+}
+
+// diffRevisions compares the revisions given in the 'from' and 'to' parameters
+func diffRevisions(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	post, ok := findEditablePost(c, user)
+	if !ok {
+		return
+	}
+
+	from, ok := findRevision(c, post, c.Query("from"))
+	if !ok {
+		return
+	}
+
+	to, ok := findRevision(c, post, c.Query("to"))
+	if !ok {
+		return
+	}
+
+	fromParagraphs, err := from.Snapshots()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	toParagraphs, err := to.Snapshots()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	fields := JSON{}
+	if from.Title != to.Title {
+		fields["title"] = JSON{"from": from.Title, "to": to.Title}
+	}
+
+	if from.Description != to.Description {
+		fields["description"] = JSON{"from": from.Description, "to": to.Description}
+	}
+
+	if from.Text != to.Text {
+		fields["text"] = JSON{"from": from.Text, "to": to.Text}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":       from.Number,
+		"to":         to.Number,
+		"fields":     fields,
+		"paragraphs": models.DiffParagraphs(fromParagraphs, toParagraphs),
+	})
+}
+
+func restoreRevision(c *gin.Context) {
+	user := c.MustGet("user").(User)
+
+	post, ok := findEditablePost(c, user)
+	if !ok {
+		return
+	}
+
+	revision, ok := findRevision(c, post, c.Param("number"))
+	if !ok {
+		return
+	}
+
+	restored, err := middlewares.Stores(c).Revisions.Restore(&post, revision, user)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	reindex(c, post)
+	c.JSON(http.StatusOK, gin.H{
+		"post":     serializePost(c, post, &user),
+		"revision": restored.Serialize(),
+	})
+}
@@ -6,14 +6,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/nireo/go-blog-api/database/models"
 	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
 )
 
 // Topic model alias
 type Topic = models.Topic
 
-// Post model alias
-type Post = models.Post
-
 // User model alias
 type User = models.User
 
@@ -35,7 +34,8 @@ func createTopic(c *gin.Context) {
 		return
 	}
 
-	_, err := models.FindOneTopic(&Topic{Title: body.Title})
+	stores := middlewares.Stores(c)
+	_, err := stores.Topics.FindByTitle(body.Title)
 	if err == nil {
 		c.AbortWithStatus(http.StatusConflict)
 		return
@@ -49,7 +49,12 @@ func createTopic(c *gin.Context) {
 		UserID:      user.ID,
 	}
 
-	newTopic.Save()
+	// titles differing only in case or spacing share the same url
+	if err := stores.Topics.Create(&newTopic); err != nil {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
 	c.JSON(http.StatusOK, newTopic.Serialize())
 }
 
@@ -57,54 +62,76 @@ func deleteTopic(c *gin.Context) {
 	user := c.MustGet("user").(User)
 	topicID := c.Param("id")
 
-	topic, err := models.FindOneTopic(&Topic{UUID: topicID})
+	stores := middlewares.Stores(c)
+	topic, err := stores.Topics.FindByUUID(topicID)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	if user.ID != topic.UserID {
+	if !policy.CanTopic(&user, policy.Delete, topic) {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	topic.Delete()
+	if err := stores.Topics.Delete(&topic); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
 func getSingleTopic(c *gin.Context) {
 	topicURL := c.Param("url")
 
-	topic, err := models.FindOneTopic(&Topic{URL: topicURL})
+	stores := middlewares.Stores(c)
+	topic, err := stores.Topics.FindByURL(topicURL)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	posts, ok := models.GetPostsRelatedToTopic(topic)
-	if !ok {
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	posts, next, err := stores.Posts.ListByTopic(topic, page)
+	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"topic": topic.Serialize(),
-		"posts": models.SerializePosts(posts),
+		"topic":       topic.Serialize(),
+		"posts":       stores.Posts.Serialize(posts, middlewares.CurrentUser(c)),
+		"next_cursor": common.EncodeCursor(next),
 	})
 }
 
 func getTopics(c *gin.Context) {
-	topics, ok := models.GetAllTopics()
-	if !ok {
+	page, err := common.ParsePage(c, "")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	topics, next, err := middlewares.Stores(c).Topics.List(page)
+	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	c.JSON(http.StatusOK, models.SerializeTopics(topics))
+	c.JSON(http.StatusOK, gin.H{
+		"topics":      models.SerializeTopics(topics),
+		"next_cursor": common.EncodeCursor(next),
+	})
 }
 
 func updateTopic(c *gin.Context) {
-	db := common.GetDatabase()
+	stores := middlewares.Stores(c)
 	user := c.MustGet("user").(User)
 	topicID := c.Param("id")
 
@@ -114,13 +141,13 @@ func updateTopic(c *gin.Context) {
 		return
 	}
 
-	topic, err := models.FindOneTopic(&Topic{UUID: topicID})
+	topic, err := stores.Topics.FindByUUID(topicID)
 	if err != nil {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	if user.ID != topic.UserID {
+	if !policy.CanTopic(&user, policy.Update, topic) {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
@@ -129,6 +156,10 @@ func updateTopic(c *gin.Context) {
 	topic.Title = body.Title
 	topic.Description = body.Description
 
-	db.Save(&topic)
+	if err := stores.Topics.Save(&topic); err != nil {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
 	c.JSON(http.StatusOK, topic.Serialize())
 }
@@ -2,17 +2,21 @@ package topic
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/store"
 	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/policy"
 )
 
-// ApplyRoutes adds topic routes to gin engine
-func ApplyRoutes(r *gin.RouterGroup) {
+// ApplyRoutes adds topic routes to gin engine. Personal access tokens need the topics:read or
+// topics:write scope.
+func ApplyRoutes(r *gin.RouterGroup, stores store.Stores) {
 	topics := r.Group("/topics")
+	topics.Use(middlewares.InjectStores(stores))
 	{
-		topics.POST("/", middlewares.Authorized, createTopic)
-		topics.GET("/", getTopics)
-		topics.GET("/single/:id", getSingleTopic)
-		topics.DELETE("/:id", middlewares.Authorized, getSingleTopic)
-		topics.PATCH("/:id", middlewares.Authorized, updateTopic)
+		topics.POST("/", middlewares.Scope(policy.TopicsWrite), middlewares.Authorized, createTopic)
+		topics.GET("/", middlewares.Scope(policy.TopicsRead), getTopics)
+		topics.GET("/single/:url", middlewares.Scope(policy.TopicsRead), getSingleTopic)
+		topics.DELETE("/:id", middlewares.Scope(policy.TopicsWrite), middlewares.Authorized, deleteTopic)
+		topics.PATCH("/:id", middlewares.Scope(policy.TopicsWrite), middlewares.Authorized, updateTopic)
 	}
 }
@@ -0,0 +1,19 @@
+package wellknown
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/lib/keys"
+)
+
+// jwks publishes the public keys tokens can be verified with. Verifiers cache the set, so
+// a new key should be listed in jwt.verification_keys for a while before it signs tokens.
+func jwks(keySet *keys.KeySet) gin.HandlerFunc {
+	body := keySet.JWKS()
+
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, body)
+	}
+}
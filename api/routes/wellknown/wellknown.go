@@ -0,0 +1,15 @@
+package wellknown
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/lib/keys"
+)
+
+// ApplyRoutes adds the well-known routes to gin engine. They live at the root of the site,
+// since other services look for them there.
+func ApplyRoutes(r *gin.Engine, keySet *keys.KeySet) {
+	wellKnown := r.Group("/.well-known")
+	{
+		wellKnown.GET("/jwks.json", jwks(keySet))
+	}
+}
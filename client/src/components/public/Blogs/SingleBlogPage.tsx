@@ -96,7 +96,7 @@ const SingleBlogPage: React.FC<Props> = ({
           </div>
           {post.paragraphs.map((paragraph: ParagraphAction) => (
             <div className="m-auto w-1/2 mt-4 mb-4">
-              {paragraph.type === 'text' && (
+              {(paragraph.type === 'text' || paragraph.type === 'paragraph') && (
                 <p style={{ marginTop: '2rem', marginBottom: '2rem' }}>
                   {paragraph.content}
                 </p>
@@ -108,20 +108,12 @@ const SingleBlogPage: React.FC<Props> = ({
                   </pre>
                 </div>
               )}
-              {paragraph.type === 'list' && (
+              {paragraph.type === 'list' && paragraph.block && (
                 <div style={{ marginTop: '2rem', marginBottom: '2rem' }}>
                   <ul style={{ listStyle: 'circle' }}>
-                    {paragraph.content
-                      .split('|LIST|')
-                      .map((item: string, index: number) => {
-                        if (
-                          index ===
-                          paragraph.content.split('|LIST|').length - 1
-                        ) {
-                          return null;
-                        }
-                        return <li>{item}</li>;
-                      })}
+                    {paragraph.block.data.items.map((item: string) => (
+                      <li>{item}</li>
+                    ))}
                   </ul>
                 </div>
               )}
@@ -18,9 +18,15 @@ export interface CreateNewPost {
   paragraphs: ParagraphAction[];
 }
 
+export interface Block {
+  type: string;
+  data: { [field: string]: any };
+}
+
 export interface ParagraphAction {
   type: string;
   content: string;
+  block?: Block;
 }
 
 export interface Post {
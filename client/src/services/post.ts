@@ -31,7 +31,7 @@ export const getPostById = async (id: string) => {
 
 export const getPosts = async () => {
   const response = await axios.get(baseUrl, getConfig());
-  return response.data;
+  return response.data.posts;
 };
 
 export const removePost = async (id: string) => {
@@ -46,7 +46,7 @@ export const getDashboardData = async () => {
 
 export const searchPost = async (search: string) => {
   const response = await axios.get(`${baseUrl}/search/${search}`);
-  return response.data;
+  return response.data.results.map((result: { post: Post }) => result.post);
 };
 
 export const likePost = async (id: string) => {
@@ -21,7 +21,7 @@ export const createTopic = async (newTopic: TopicAction) => {
 
 export const getTopics = async () => {
   const response = await axios.get(baseURL);
-  return response.data;
+  return response.data.topics;
 };
 
 export const getSingleTopic = async (url: string) => {
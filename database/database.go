@@ -1,18 +1,90 @@
 package database
 
 import (
+	"fmt"
+	"log"
+
+	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite" // sqlite configuration
-	"github.com/nireo/go-blog-api/database/models"
+	_ "github.com/jinzhu/gorm/dialects/mysql"    // mysql configuration
+	_ "github.com/jinzhu/gorm/dialects/postgres" // postgres configuration
+	_ "github.com/jinzhu/gorm/dialects/sqlite"   // sqlite configuration
+	"github.com/nireo/go-blog-api/database/migrations"
+	"github.com/nireo/go-blog-api/lib/config"
 )
 
 // Initialize the database
-func Initialize() (*gorm.DB, error) {
-	db, err := gorm.Open("sqlite3", "./database.db")
+func Initialize(settings config.Database) (*gorm.DB, error) {
+	db, err := Open(settings)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	if err := migrate(db, settings.AutoMigrate); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	models.Migrate(db)
 	return db, err
 }
+
+// migrate applies the pending migrations or, when that's left to the migrate command,
+// makes sure there aren't any
+func migrate(db *gorm.DB, auto bool) error {
+	if auto {
+		applied, err := migrations.Up(db)
+		for _, migration := range applied {
+			log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+		}
+
+		return err
+	}
+
+	states, err := migrations.Status(db)
+	if err != nil {
+		return err
+	}
+
+	for _, state := range states {
+		if !state.Applied {
+			return fmt.Errorf("Migration %04d_%s is pending, apply it with `migrate up`", state.Version, state.Name)
+		}
+	}
+
+	return nil
+}
+
+// Open connects to the configured database without migrating it
+func Open(settings config.Database) (*gorm.DB, error) {
+	dsn, err := connectionString(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return gorm.Open(settings.Driver, dsn)
+}
+
+// connectionString adjusts the DSN for the driver. MySQL returns dates as raw bytes unless
+// the DSN asks them to be parsed, so that option is always set.
+func connectionString(settings config.Database) (string, error) {
+	if settings.Driver != "mysql" {
+		return settings.DSN, nil
+	}
+
+	mysqlConfig, err := mysql.ParseDSN(settings.DSN)
+	if err != nil {
+		return "", err
+	}
+
+	mysqlConfig.ParseTime = true
+	if mysqlConfig.Params == nil {
+		mysqlConfig.Params = map[string]string{}
+	}
+
+	// utf8mb4 stores every unicode character, unlike MySQL's utf8
+	if _, ok := mysqlConfig.Params["charset"]; !ok {
+		mysqlConfig.Params["charset"] = "utf8mb4"
+	}
+
+	return mysqlConfig.FormatDSN(), nil
+}
@@ -0,0 +1,380 @@
+package database_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/database"
+	"github.com/nireo/go-blog-api/database/migrations"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/search"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+// The suite always runs against a temporary SQLite database. Setting BLOG_TEST_DB_DRIVER and
+// BLOG_TEST_DB_DSN runs it against that database too, for example:
+//
+//	BLOG_TEST_DB_DRIVER=postgres BLOG_TEST_DB_DSN="host=localhost user=blog dbname=blog_test sslmode=disable" go test ./database/
+//
+// The database is migrated but never cleaned, every test creates its own uniquely named rows.
+
+// forEachDatabase runs the test once for every database the suite is configured with
+func forEachDatabase(t *testing.T, test func(t *testing.T, db *gorm.DB)) {
+	settings := []config.Database{
+		{Driver: "sqlite3", DSN: filepath.Join(t.TempDir(), "test.db")},
+	}
+
+	if driver := os.Getenv("BLOG_TEST_DB_DRIVER"); driver != "" {
+		settings = append(settings, config.Database{Driver: driver, DSN: os.Getenv("BLOG_TEST_DB_DSN")})
+	}
+
+	for _, setting := range settings {
+		setting := setting
+		t.Run(setting.Driver, func(t *testing.T) {
+			db, err := database.Open(setting)
+			if err != nil {
+				t.Fatalf("Could not open the database: %v", err)
+			}
+			defer db.Close()
+
+			if _, err := migrations.Up(db); err != nil {
+				t.Fatalf("Could not migrate the database: %v", err)
+			}
+
+			test(t, db)
+		})
+	}
+}
+
+// fixture creates uniquely named users, topics and posts
+type fixture struct {
+	t      *testing.T
+	stores store.Stores
+	suffix string
+}
+
+func newFixture(t *testing.T, db *gorm.DB) *fixture {
+	return &fixture{t: t, stores: store.NewGormStores(db), suffix: common.CreateUUID()[:8]}
+}
+
+func (f *fixture) user(name string) models.User {
+	user := models.User{Username: name + f.suffix, UUID: common.CreateUUID(), URL: name + f.suffix}
+	if err := f.stores.Users.Create(&user); err != nil {
+		f.t.Fatalf("Could not create user %s: %v", name, err)
+	}
+
+	return user
+}
+
+func (f *fixture) topic(title string, owner models.User) models.Topic {
+	topic := models.Topic{Title: title + f.suffix, UUID: common.CreateUUID(), URL: title + f.suffix, UserID: owner.ID}
+	if err := f.stores.Topics.Create(&topic); err != nil {
+		f.t.Fatalf("Could not create topic %s: %v", title, err)
+	}
+
+	return topic
+}
+
+func (f *fixture) post(author models.User, topic models.Topic, title, description string, contents ...string) models.Post {
+	now := time.Now().UTC()
+	post := models.Post{
+		Title:       title,
+		Description: description,
+		UUID:        common.CreateUUID(),
+		UserID:      author.ID,
+		TopicID:     topic.ID,
+		Status:      models.PostStatusPublished,
+		PublishAt:   &now,
+	}
+
+	paragraphs := make([]models.Paragraph, len(contents), len(contents))
+	for index := range contents {
+		paragraphs[index] = models.Paragraph{Type: "paragraph", Content: contents[index], UUID: common.CreateUUID()}
+	}
+
+	if err := f.stores.Posts.Create(&post, paragraphs); err != nil {
+		f.t.Fatalf("Could not create post %s: %v", title, err)
+	}
+
+	return post
+}
+
+func postIDs(posts []models.Post) map[uint]bool {
+	ids := map[uint]bool{}
+	for _, post := range posts {
+		ids[post.ID] = true
+	}
+
+	return ids
+}
+
+func TestLikeSearch(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		f := newFixture(t, db)
+		author := f.user("searcher")
+		topic := f.topic("search", author)
+
+		word := "zq" + f.suffix
+		inTitle := f.post(author, topic, "About "+word, "nothing here")
+		inDescription := f.post(author, topic, "Another post", "Mentions "+word+" once")
+		inParagraph := f.post(author, topic, "Third post", "nothing here", "first paragraph", "it's "+word+" in the end")
+		f.post(author, topic, "Unrelated", "nothing here", "no match")
+
+		draft := f.post(author, topic, "Draft "+word, "nothing here")
+		draft.Status = models.PostStatusDraft
+		if err := f.stores.Posts.Save(&draft); err != nil {
+			t.Fatalf("Could not save the draft: %v", err)
+		}
+
+		engine := search.NewLikeEngine(db)
+		results, err := engine.Search(search.Query{Text: word, Limit: 10})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+
+		if len(results) != 3 {
+			t.Fatalf("Expected 3 results, got %d", len(results))
+		}
+
+		// the title ranks above the description, which ranks above the paragraphs
+		expected := []uint{inTitle.ID, inDescription.ID, inParagraph.ID}
+		for index := range expected {
+			if results[index].Post.ID != expected[index] {
+				t.Errorf("Result %d should be post %d, got %d", index, expected[index], results[index].Post.ID)
+			}
+		}
+
+		// the terms are matched case insensitively
+		upper, err := engine.Search(search.Query{Text: "ZQ" + f.suffix, Limit: 10})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+
+		if len(upper) != 3 {
+			t.Errorf("Expected 3 results for the uppercase term, got %d", len(upper))
+		}
+
+		byTopic, err := engine.Search(search.Query{Text: word, TopicID: topic.ID + 1000000, Limit: 10})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+
+		if len(byTopic) != 0 {
+			t.Errorf("Expected no results in another topic, got %d", len(byTopic))
+		}
+	})
+}
+
+func TestRelatedLookups(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		f := newFixture(t, db)
+		author := f.user("writer")
+		other := f.user("other")
+		topic := f.topic("related", author)
+		otherTopic := f.topic("elsewhere", other)
+
+		post := f.post(author, topic, "Ordered", "d", "one", "two", "three")
+		f.post(other, otherTopic, "Someone else's", "d")
+
+		paragraphs, err := f.stores.Posts.Paragraphs(post)
+		if err != nil || len(paragraphs) != 3 {
+			t.Fatalf("Expected the post's 3 paragraphs, got %d", len(paragraphs))
+		}
+
+		for index, content := range []string{"one", "two", "three"} {
+			if paragraphs[index].Content != content || paragraphs[index].Position != index {
+				t.Errorf("Paragraph %d should be %q at %d, got %q at %d", index, content, index, paragraphs[index].Content, paragraphs[index].Position)
+			}
+		}
+
+		page := common.Page{Limit: 10}
+		byUser, _, err := f.stores.Posts.ListByUser(author, page, true)
+		if err != nil {
+			t.Fatalf("Listing the user's posts failed: %v", err)
+		}
+
+		if len(byUser) != 1 || byUser[0].ID != post.ID {
+			t.Errorf("Expected only the author's post, got %d posts", len(byUser))
+		}
+
+		byTopic, _, err := f.stores.Posts.ListByTopic(topic, page)
+		if err != nil {
+			t.Fatalf("Listing the topic's posts failed: %v", err)
+		}
+
+		if len(byTopic) != 1 || byTopic[0].ID != post.ID {
+			t.Errorf("Expected only the topic's post, got %d posts", len(byTopic))
+		}
+
+		topics, _, err := f.stores.Topics.ListByUser(author, page)
+		if err != nil {
+			t.Fatalf("Listing the user's topics failed: %v", err)
+		}
+
+		if len(topics) != 1 || topics[0].ID != topic.ID {
+			t.Errorf("Expected only the author's topic, got %d topics", len(topics))
+		}
+	})
+}
+
+func TestFollows(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		f := newFixture(t, db)
+		reader := f.user("reader")
+		followed := f.user("followed")
+		stranger := f.user("stranger")
+		topic := f.topic("followed", stranger)
+		strangerTopic := f.topic("unfollowed", stranger)
+
+		if err := f.stores.Follows.Follow(reader, followed); err != nil {
+			t.Fatalf("Follow failed: %v", err)
+		}
+
+		if err := f.stores.Follows.FollowTopic(reader, topic); err != nil {
+			t.Fatalf("Following the topic failed: %v", err)
+		}
+
+		if following, err := f.stores.Follows.IsFollowing(reader, followed); err != nil || !following {
+			t.Errorf("The reader should follow the user, got %v and %v", following, err)
+		}
+
+		if following, err := f.stores.Follows.IsFollowing(followed, reader); err != nil || following {
+			t.Errorf("Follows shouldn't go both ways, got %v and %v", following, err)
+		}
+
+		page := common.Page{Limit: 10}
+		users, _, err := f.stores.Follows.FollowedUsers(reader, page)
+		if err != nil || len(users) != 1 || users[0].ID != followed.ID {
+			t.Errorf("Expected the followed user, got %d users and %v", len(users), err)
+		}
+
+		topics, _, err := f.stores.Follows.FollowedTopics(reader, page)
+		if err != nil || len(topics) != 1 || topics[0].ID != topic.ID {
+			t.Errorf("Expected the followed topic, got %d topics and %v", len(topics), err)
+		}
+
+		// the post by the followed user in the followed topic shows up only once
+		both := f.post(followed, topic, "Both", "d")
+		byUser := f.post(followed, strangerTopic, "By the user", "d")
+		inTopic := f.post(stranger, topic, "In the topic", "d")
+		unrelated := f.post(stranger, strangerTopic, "Unrelated", "d")
+
+		feed, _, err := f.stores.Posts.Feed(reader, page)
+		if err != nil {
+			t.Fatalf("Loading the feed failed: %v", err)
+		}
+
+		ids := postIDs(feed)
+		if len(feed) != 3 || !ids[both.ID] || !ids[byUser.ID] || !ids[inTopic.ID] || ids[unrelated.ID] {
+			t.Errorf("The feed should have the 3 followed posts, got %d posts", len(feed))
+		}
+
+		if err := f.stores.Follows.Unfollow(reader, followed); err != nil {
+			t.Fatalf("Unfollow failed: %v", err)
+		}
+
+		if err := f.stores.Follows.Unfollow(reader, followed); err != store.ErrNotFound {
+			t.Errorf("Unfollowing twice should return ErrNotFound, got %v", err)
+		}
+
+		// unfollowing deletes the row for good, so following again doesn't conflict with it
+		if err := f.stores.Follows.Follow(reader, followed); err != nil {
+			t.Errorf("Following again failed: %v", err)
+		}
+	})
+}
+
+func TestDeleteFreesNames(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		f := newFixture(t, db)
+		email := "deleted" + f.suffix + "@example.com"
+		user := models.User{Username: "deleted" + f.suffix, URL: "deleted" + f.suffix, UUID: common.CreateUUID(), Email: &email}
+		if err := f.stores.Users.Create(&user); err != nil {
+			t.Fatalf("Could not create the user: %v", err)
+		}
+
+		topic := f.topic("deleted", user)
+		if err := f.stores.Topics.Delete(&topic); err != nil {
+			t.Fatalf("Deleting the topic failed: %v", err)
+		}
+
+		if err := f.stores.Users.Delete(&user); err != nil {
+			t.Fatalf("Deleting the user failed: %v", err)
+		}
+
+		// someone else registers with the deleted user's username, url and email address
+		again := models.User{Username: user.Username, URL: user.URL, UUID: common.CreateUUID(), Email: &email}
+		if err := f.stores.Users.Create(&again); err != nil {
+			t.Fatalf("The deleted user's username should be free, got %v", err)
+		}
+
+		if found, err := f.stores.Users.FindByUsername(user.Username); err != nil || found.ID != again.ID {
+			t.Errorf("Expected the new user, got %d and %v", found.ID, err)
+		}
+
+		f.topic("deleted", again)
+	})
+}
+
+func TestSerializePage(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		f := newFixture(t, db)
+		author := f.user("serialized")
+		reader := f.user("viewer")
+		topic := f.topic("serialized", author)
+		otherTopic := f.topic("serialized-other", author)
+
+		commented := f.post(author, topic, "Commented", "d")
+		quiet := f.post(author, topic, "Quiet", "d")
+
+		for index := 0; index < 3; index++ {
+			comment := models.Comment{UUID: common.CreateUUID(), Content: "c", PostID: commented.ID, UserID: reader.ID}
+			if err := f.stores.Comments.Create(&comment); err != nil {
+				t.Fatalf("Could not comment: %v", err)
+			}
+
+			// removed comments aren't counted
+			if index == 0 {
+				if err := f.stores.Comments.Remove(&comment); err != nil {
+					t.Fatalf("Could not remove the comment: %v", err)
+				}
+			}
+		}
+
+		if err := f.stores.Likes.Like(reader, &quiet); err != nil {
+			t.Fatalf("Could not like the post: %v", err)
+		}
+
+		serialized := f.stores.Posts.Serialize([]models.Post{commented, quiet}, &reader)
+		if serialized[0]["comments"] != 2 || serialized[1]["comments"] != 0 {
+			t.Errorf("Expected 2 and 0 comments, got %v and %v", serialized[0]["comments"], serialized[1]["comments"])
+		}
+
+		if serialized[0]["liked_by_me"] != false || serialized[1]["liked_by_me"] != true {
+			t.Errorf("Expected only the second post liked, got %v and %v", serialized[0]["liked_by_me"], serialized[1]["liked_by_me"])
+		}
+
+		if _, ok := f.stores.Posts.Serialize([]models.Post{quiet}, nil)[0]["liked_by_me"]; ok {
+			t.Error("Posts serialized without a viewer shouldn't say if they're liked")
+		}
+
+		// the followed topics keep the order they were followed in
+		if err := f.stores.Follows.FollowTopic(reader, otherTopic); err != nil {
+			t.Fatalf("Following the topic failed: %v", err)
+		}
+
+		if err := f.stores.Follows.FollowTopic(reader, topic); err != nil {
+			t.Fatalf("Following the topic failed: %v", err)
+		}
+
+		topics, _, err := f.stores.Follows.FollowedTopics(reader, common.Page{Limit: 10})
+		if err != nil || len(topics) != 2 || topics[0].ID != topic.ID || topics[1].ID != otherTopic.ID {
+			t.Errorf("Expected both topics, the latest followed first, got %d topics and %v", len(topics), err)
+		}
+	})
+}
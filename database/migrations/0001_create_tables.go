@@ -0,0 +1,134 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The tables as they were when migrations were introduced. Later migrations change them, so
+// these snapshots must not follow the models.
+
+type v1User struct {
+	gorm.Model
+	Username     string
+	PasswordHash string
+	UUID         string
+	URL          string
+}
+
+func (v1User) TableName() string { return "users" }
+
+type v1Post struct {
+	gorm.Model
+	Text        string `gorm:"type:text"`
+	Title       string
+	Likes       int
+	Description string `gorm:"type:text"`
+	ImageURL    string
+	UserID      uint
+	UUID        string
+	TopicID     uint
+	Status      string `gorm:"default:'published'"`
+	PublishAt   *time.Time
+}
+
+func (v1Post) TableName() string { return "posts" }
+
+type v1Topic struct {
+	gorm.Model
+	Title       string
+	Description string `gorm:"type:text"`
+	UUID        string
+	URL         string
+	UserID      uint
+}
+
+func (v1Topic) TableName() string { return "topics" }
+
+type v1Paragraph struct {
+	gorm.Model
+	Type     string
+	Content  string `gorm:"type:text"`
+	PostID   uint
+	UUID     string
+	Position int
+	Data     string `gorm:"type:text"`
+}
+
+func (v1Paragraph) TableName() string { return "paragraphs" }
+
+type v1Follow struct {
+	gorm.Model
+	FollowingID  uint
+	FollowedByID uint
+}
+
+func (v1Follow) TableName() string { return "follows" }
+
+type v1FollowedTopic struct {
+	gorm.Model
+	UserID  uint
+	TopicID uint
+}
+
+func (v1FollowedTopic) TableName() string { return "followed_topics" }
+
+type v1PostLike struct {
+	gorm.Model
+	LikedPostID uint
+	UserID      uint
+}
+
+func (v1PostLike) TableName() string { return "post_likes" }
+
+type v1Comment struct {
+	gorm.Model
+	UUID     string
+	Content  string `gorm:"type:text"`
+	PostID   uint
+	UserID   uint
+	ParentID uint
+	Removed  bool
+}
+
+func (v1Comment) TableName() string { return "comments" }
+
+type v1PostRevision struct {
+	gorm.Model
+	PostID      uint
+	UserID      uint
+	Number      int
+	Title       string
+	Description string `gorm:"type:text"`
+	Text        string `gorm:"type:text"`
+	Paragraphs  string `gorm:"type:text"`
+}
+
+func (v1PostRevision) TableName() string { return "post_revisions" }
+
+// The tables used to be created with AutoMigrate at startup. AutoMigrate only adds what's
+// missing, so databases created that way can run this migration as well.
+func init() {
+	tables := []interface{}{
+		&v1User{}, &v1Post{}, &v1Topic{}, &v1Paragraph{}, &v1Follow{},
+		&v1FollowedTopic{}, &v1PostLike{}, &v1Comment{}, &v1PostRevision{},
+	}
+
+	Register(Migration{
+		Version: 1,
+		Name:    "create_tables",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(tables...).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			for index := len(tables) - 1; index >= 0; index-- {
+				if err := tx.DropTableIfExists(tables[index]).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+}
@@ -0,0 +1,139 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// v2User is the part of a user the repair reads
+type v2User struct {
+	ID       uint
+	Username string
+	UUID     string
+	URL      string
+}
+
+func (v2User) TableName() string { return "users" }
+
+// uniqueIndex describes one of the unique indexes added by the migration
+type uniqueIndex struct {
+	table   string
+	name    string
+	columns []string
+}
+
+var uniqueIndexes = []uniqueIndex{
+	{"users", "idx_users_username", []string{"username"}},
+	{"users", "idx_users_url", []string{"url"}},
+	{"topics", "idx_topics_url", []string{"url"}},
+	{"posts", "idx_posts_uuid", []string{"uuid"}},
+	{"follows", "idx_follows_pair", []string{"followed_by_id", "following_id"}},
+	{"followed_topics", "idx_followed_topics_pair", []string{"user_id", "topic_id"}},
+	{"post_likes", "idx_post_likes_pair", []string{"user_id", "liked_post_id"}},
+}
+
+// Unfollowing used to soft delete the rows and following twice wasn't prevented, so the pair
+// tables are cleaned up before the indexes are added. Saving a post or a follow used to blank
+// the user's url and uuid, so those are recreated as well. Duplicate users, topics and posts can't
+// be merged automatically, so the migration stops and names the duplicated column instead.
+// Rolling back only drops the indexes, the removed duplicates aren't restored.
+func init() {
+	Register(Migration{
+		Version: 2,
+		Name:    "unique_indexes",
+		Up: func(tx *gorm.DB) error {
+			if err := repairUsers(tx); err != nil {
+				return err
+			}
+
+			for _, table := range []string{"follows", "followed_topics", "post_likes"} {
+				if err := tx.Exec("DELETE FROM " + table + " WHERE deleted_at IS NOT NULL").Error; err != nil {
+					return err
+				}
+			}
+
+			for _, index := range uniqueIndexes {
+				if len(index.columns) > 1 {
+					if err := removeDuplicates(tx, index); err != nil {
+						return err
+					}
+				} else if err := checkDuplicates(tx, index); err != nil {
+					return err
+				}
+
+				if err := tx.Table(index.table).AddUniqueIndex(index.name, index.columns...).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, index := range uniqueIndexes {
+				if err := tx.Table(index.table).RemoveIndex(index.name).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+}
+
+// repairUsers gives the users blanked by the old association saving their url and uuid back
+func repairUsers(tx *gorm.DB) error {
+	var users []v2User
+	if err := tx.Where("url = ? OR uuid = ?", "", "").Find(&users).Error; err != nil {
+		return err
+	}
+
+	for index := range users {
+		user := users[index]
+		if user.URL == "" {
+			user.URL = common.FormatString(user.Username)
+		}
+
+		if user.UUID == "" {
+			user.UUID = common.CreateUUID()
+		}
+
+		err := tx.Table("users").Where("id = ?", user.ID).UpdateColumns(map[string]interface{}{"url": user.URL, "uuid": user.UUID}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeDuplicates keeps the oldest row of every pair. The ids are selected through a derived
+// table, since MySQL doesn't allow a subquery to read the table being deleted from.
+func removeDuplicates(tx *gorm.DB, index uniqueIndex) error {
+	columns := strings.Join(index.columns, ", ")
+	return tx.Exec(fmt.Sprintf(
+		"DELETE FROM %s WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM %s GROUP BY %s) AS kept)",
+		index.table, index.table, columns,
+	)).Error
+}
+
+func checkDuplicates(tx *gorm.DB, index uniqueIndex) error {
+	column := index.columns[0]
+
+	var duplicates int
+	row := tx.Raw(fmt.Sprintf(
+		"SELECT COUNT(*) FROM (SELECT %s FROM %s GROUP BY %s HAVING COUNT(*) > 1) AS duplicates",
+		column, index.table, column,
+	)).Row()
+	if err := row.Scan(&duplicates); err != nil {
+		return err
+	}
+
+	if duplicates > 0 {
+		return fmt.Errorf("%s.%s has %d duplicated values, which have to be fixed by hand", index.table, column, duplicates)
+	}
+
+	return nil
+}
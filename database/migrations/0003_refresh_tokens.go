@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type v3User struct {
+	TokenVersion int `gorm:"not null;default:0"`
+}
+
+func (v3User) TableName() string { return "users" }
+
+type v3RefreshToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"unique_index"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+func (v3RefreshToken) TableName() string { return "refresh_tokens" }
+
+// Adds the users' token versions and the refresh token table
+func init() {
+	Register(Migration{
+		Version: 3,
+		Name:    "refresh_tokens",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v3User{}, &v3RefreshToken{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.DropTableIfExists(&v3RefreshToken{}).Error; err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "users", "token_version")
+		},
+	})
+}
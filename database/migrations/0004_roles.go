@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type v4User struct {
+	Role        string `gorm:"not null;default:'user'"`
+	SuspendedAt *time.Time
+}
+
+func (v4User) TableName() string { return "users" }
+
+// Adds the users' roles and suspensions. Existing users get the default role.
+func init() {
+	Register(Migration{
+		Version: 4,
+		Name:    "roles",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v4User{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "users", "role", "suspended_at")
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type v5User struct {
+	Email           *string `gorm:"unique_index"`
+	EmailVerifiedAt *time.Time
+}
+
+func (v5User) TableName() string { return "users" }
+
+// Adds the users' email addresses and their verification times. Existing users don't have an
+// address, which the unique index allows since it is null.
+func init() {
+	Register(Migration{
+		Version: 5,
+		Name:    "emails",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v5User{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Table("users").RemoveIndex("uix_users_email").Error; err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "users", "email", "email_verified_at")
+		},
+	})
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+type v6Identity struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	Provider string `gorm:"unique_index:idx_identities_provider_subject"`
+	Subject  string `gorm:"unique_index:idx_identities_provider_subject"`
+	Email    string
+}
+
+func (v6Identity) TableName() string { return "identities" }
+
+// Adds the table linking users to their accounts at external identity providers
+func init() {
+	Register(Migration{
+		Version: 6,
+		Name:    "identities",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v6Identity{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&v6Identity{}).Error
+		},
+	})
+}
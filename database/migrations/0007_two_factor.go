@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type v7User struct {
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	TOTPLastStep  int64 `gorm:"not null;default:0"`
+}
+
+func (v7User) TableName() string { return "users" }
+
+type v7RecoveryCode struct {
+	gorm.Model
+	UserID   uint `gorm:"index"`
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+func (v7RecoveryCode) TableName() string { return "recovery_codes" }
+
+// Adds the users' TOTP secrets and the recovery code table
+func init() {
+	Register(Migration{
+		Version: 7,
+		Name:    "two_factor",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v7User{}, &v7RecoveryCode{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.DropTableIfExists(&v7RecoveryCode{}).Error; err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "users", "totp_secret", "totp_enabled_at", "totp_last_step")
+		},
+	})
+}
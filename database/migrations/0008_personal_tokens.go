@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type v8PersonalToken struct {
+	gorm.Model
+	UserID     uint `gorm:"index"`
+	Name       string
+	TokenHash  string `gorm:"unique_index"`
+	Scopes     string
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+}
+
+func (v8PersonalToken) TableName() string { return "personal_tokens" }
+
+// Adds the table for the users' personal access tokens
+func init() {
+	Register(Migration{
+		Version: 8,
+		Name:    "personal_tokens",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v8PersonalToken{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&v8PersonalToken{}).Error
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type v9SecurityEvent struct {
+	gorm.Model
+	Kind      string `gorm:"index"`
+	Subject   string
+	UserID    *uint `gorm:"index"`
+	ActorID   *uint
+	IPAddress string
+	Until     *time.Time
+}
+
+func (v9SecurityEvent) TableName() string { return "security_events" }
+
+// Adds the security log, which records account lockouts
+func init() {
+	Register(Migration{
+		Version: 9,
+		Name:    "security_events",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v9SecurityEvent{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&v9SecurityEvent{}).Error
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type v10Session struct {
+	gorm.Model
+	UserID     uint `gorm:"index"`
+	UserAgent  string
+	IPAddress  string
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+func (v10Session) TableName() string { return "sessions" }
+
+type v10RefreshToken struct {
+	SessionID uint `gorm:"index"`
+}
+
+func (v10RefreshToken) TableName() string { return "refresh_tokens" }
+
+// Adds the sessions table and the session of every refresh token
+func init() {
+	Register(Migration{
+		Version: 10,
+		Name:    "sessions",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v10Session{}, &v10RefreshToken{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.DropTableIfExists(&v10Session{}).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Table("refresh_tokens").RemoveIndex("idx_refresh_tokens_session_id").Error; err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "refresh_tokens", "session_id")
+		},
+	})
+}
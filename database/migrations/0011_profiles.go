@@ -0,0 +1,29 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+type v11User struct {
+	DisplayName string
+	Bio         string `gorm:"type:text"`
+	Location    string
+	Website     string
+	Links       string `gorm:"type:text"`
+	AvatarKey   string
+	AvatarURL   string
+}
+
+func (v11User) TableName() string { return "users" }
+
+// Adds the users' profile and avatar columns
+func init() {
+	Register(Migration{
+		Version: 11,
+		Name:    "profiles",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v11User{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "users", "display_name", "bio", "location", "website", "links", "avatar_key", "avatar_url")
+		},
+	})
+}
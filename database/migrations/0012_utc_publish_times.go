@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// publishTime is the part of a post this migration reads
+type publishTime struct {
+	ID        uint
+	PublishAt *time.Time
+}
+
+// Rewrites the posts' publish times in UTC. SQLite keeps times as text in the zone the client
+// sent, so times with different offsets didn't compare in the right order. The other
+// databases store the instant itself, so there's nothing to rewrite there or to undo.
+func init() {
+	Register(Migration{
+		Version: 12,
+		Name:    "utc_publish_times",
+		Up: func(tx *gorm.DB) error {
+			if tx.Dialect().GetName() != "sqlite3" {
+				return nil
+			}
+
+			var rows []publishTime
+			if err := tx.Table("posts").Select("id, publish_at").Where("publish_at IS NOT NULL").Scan(&rows).Error; err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				if err := tx.Table("posts").Where("id = ?", row.ID).UpdateColumn("publish_at", row.PublishAt.UTC()).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
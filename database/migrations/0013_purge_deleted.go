@@ -0,0 +1,25 @@
+package migrations
+
+import "github.com/jinzhu/gorm"
+
+// Removes the users and topics which were soft deleted. Their rows kept the username, url
+// and email address, so the unique indexes stopped anyone from using them again. The stores
+// delete these rows for good now, and the removed rows can't be restored.
+func init() {
+	Register(Migration{
+		Version: 13,
+		Name:    "purge_deleted",
+		Up: func(tx *gorm.DB) error {
+			for _, table := range []string{"users", "topics"} {
+				if err := tx.Exec("DELETE FROM " + table + " WHERE deleted_at IS NOT NULL").Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
@@ -0,0 +1,180 @@
+package migrations
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// tableConstraints start the definitions in a CREATE TABLE statement which aren't columns
+var tableConstraints = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"}
+
+// dropColumns removes the columns from the table. The SQLite bundled with the driver can't
+// drop columns, so there the table is copied without them instead.
+func dropColumns(tx *gorm.DB, table string, columns ...string) error {
+	if tx.Dialect().GetName() == "sqlite3" {
+		return rebuildWithout(tx, table, columns)
+	}
+
+	for _, column := range columns {
+		if err := tx.Table(table).DropColumn(column).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildWithout creates the SQLite table again without the columns, copies the rows over and
+// recreates the indexes which don't use the removed columns
+func rebuildWithout(tx *gorm.DB, table string, columns []string) error {
+	var create string
+	if err := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Row().Scan(&create); err != nil {
+		return err
+	}
+
+	start, end := strings.Index(create, "("), strings.LastIndex(create, ")")
+	if start < 0 || end < start {
+		return fmt.Errorf("Could not read the columns of %s", table)
+	}
+
+	dropped := map[string]bool{}
+	for _, column := range columns {
+		dropped[column] = true
+	}
+
+	definitions := []string{}
+	kept := []string{}
+	for _, definition := range splitDefinitions(create[start+1 : end]) {
+		name, isColumn := definitionName(definition)
+		if isColumn && dropped[name] {
+			continue
+		}
+
+		definitions = append(definitions, definition)
+		if isColumn {
+			kept = append(kept, `"`+name+`"`)
+		}
+	}
+
+	indexes, err := indexesWithout(tx, table, columns)
+	if err != nil {
+		return err
+	}
+
+	rebuilt := table + "_rebuild"
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE "%s" (%s)`, rebuilt, strings.Join(definitions, ", ")),
+		fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM "%s"`, rebuilt, strings.Join(kept, ", "), strings.Join(kept, ", "), table),
+		fmt.Sprintf(`DROP TABLE "%s"`, table),
+		fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, rebuilt, table),
+	}
+
+	for _, statement := range append(statements, indexes...) {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexesWithout returns the statements creating the table's indexes which don't use any of
+// the columns
+func indexesWithout(tx *gorm.DB, table string, columns []string) ([]string, error) {
+	rows, err := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	patterns := make([]*regexp.Regexp, len(columns), len(columns))
+	for index, column := range columns {
+		patterns[index] = regexp.MustCompile(`[("\s,]` + regexp.QuoteMeta(column) + `["\s,)]`)
+	}
+
+	indexes := []string{}
+	for rows.Next() {
+		var statement string
+		if err := rows.Scan(&statement); err != nil {
+			return nil, err
+		}
+
+		// only the column list after the table's name can mention the columns
+		indexed := statement[strings.LastIndex(statement, "("):]
+		uses := false
+		for _, pattern := range patterns {
+			if pattern.MatchString(indexed) {
+				uses = true
+			}
+		}
+
+		if !uses {
+			indexes = append(indexes, statement)
+		}
+	}
+
+	return indexes, rows.Err()
+}
+
+// splitDefinitions splits the body of a CREATE TABLE statement at the commas which aren't
+// inside parentheses or quotes
+func splitDefinitions(body string) []string {
+	definitions := []string{}
+	depth := 0
+	var quote rune
+	start := 0
+	add := func(definition string) {
+		if definition = strings.TrimSpace(definition); definition != "" {
+			definitions = append(definitions, definition)
+		}
+	}
+
+	for index, char := range body {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'' || char == '`':
+			quote = char
+		case char == '[':
+			quote = ']'
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+		case char == ',' && depth == 0:
+			add(body[start:index])
+			start = index + 1
+		}
+	}
+
+	add(body[start:])
+	return definitions
+}
+
+// definitionName returns the name of the column the definition creates, the bool is false
+// for table constraints
+func definitionName(definition string) (string, bool) {
+	if strings.ContainsAny(definition[:1], "\"`[") {
+		closing := map[byte]string{'"': `"`, '`': "`", '[': "]"}[definition[0]]
+		end := strings.Index(definition[1:], closing)
+		if end < 0 {
+			return definition[1:], true
+		}
+
+		return definition[1 : end+1], true
+	}
+
+	name := strings.Fields(definition)[0]
+	for _, constraint := range tableConstraints {
+		if strings.EqualFold(name, constraint) {
+			return "", false
+		}
+	}
+
+	return name, true
+}
@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dir is where the migration files live relative to the repository's root
+const Dir = "database/migrations"
+
+var (
+	migrationFile = regexp.MustCompile(`^(\d+)_\w+\.go$`)
+	invalidName   = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+const template = `package migrations
+
+import "github.com/jinzhu/gorm"
+
+func init() {
+	Register(Migration{
+		Version: %d,
+		Name:    %q,
+		Up: func(tx *gorm.DB) error {
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
+`
+
+// Create writes an empty migration into dir, numbered after the newest migration found in
+// the directory or compiled into the binary, and returns the file's path
+func Create(dir, name string) (string, error) {
+	name = strings.Trim(invalidName.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", fmt.Errorf("The migration needs a name")
+	}
+
+	version := 0
+	for _, migration := range All() {
+		if migration.Version > version {
+			version = migration.Version
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		match := migrationFile.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+
+		if number, err := strconv.Atoi(match[1]); err == nil && number > version {
+			version = number
+		}
+	}
+
+	version++
+	path := filepath.Join(dir, fmt.Sprintf("%04d_%s.go", version, name))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, template, version, name); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
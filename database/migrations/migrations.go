@@ -0,0 +1,180 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration is a single numbered change to the schema or the data. Down undoes everything
+// done by Up, so that migrations can be rolled back one at a time.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records a migration which has been applied to the database
+type SchemaMigration struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName keeps the table's name the same regardless of gorm's pluralization
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// State tells if a migration has been applied and when
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// registry holds every known migration, sorted by version in All
+var registry = map[int]Migration{}
+
+// Register adds a migration to the registry. It's called from the init function of every
+// migration file and panics on duplicate versions, so mistakes are found at startup.
+func Register(migration Migration) {
+	if existing, ok := registry[migration.Version]; ok {
+		panic(fmt.Sprintf("Migrations %s and %s both have version %d", existing.Name, migration.Name, migration.Version))
+	}
+
+	registry[migration.Version] = migration
+}
+
+// All returns the registered migrations from the oldest to the newest
+func All() []Migration {
+	migrations := make([]Migration, 0, len(registry))
+	for _, migration := range registry {
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations
+}
+
+// applied returns the applied migrations keyed by version, creating the table if needed
+func applied(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	versions := map[int]SchemaMigration{}
+	for index := range rows {
+		versions[rows[index].Version] = rows[index]
+	}
+
+	return versions, nil
+}
+
+// Up applies every pending migration in order and returns the ones which were applied. Each
+// migration runs in its own transaction and the first failure stops the run.
+func Up(db *gorm.DB) ([]Migration, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, migration := range All() {
+		if _, ok := done[migration.Version]; ok {
+			continue
+		}
+
+		err := run(db, migration, migration.Up, func(tx *gorm.DB) error {
+			return tx.Create(&SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return ran, err
+		}
+
+		ran = append(ran, migration)
+	}
+
+	return ran, nil
+}
+
+// Down rolls back the given amount of the newest applied migrations and returns the ones
+// which were rolled back
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := All()
+	var ran []Migration
+	for index := len(migrations) - 1; index >= 0 && len(ran) < steps; index-- {
+		migration := migrations[index]
+		if _, ok := done[migration.Version]; !ok {
+			continue
+		}
+
+		err := run(db, migration, migration.Down, func(tx *gorm.DB) error {
+			return tx.Where("version = ?", migration.Version).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return ran, err
+		}
+
+		ran = append(ran, migration)
+	}
+
+	return ran, nil
+}
+
+// run executes the migration step and records it in the same transaction
+func run(db *gorm.DB, migration Migration, step, record func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if err := step(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Migration %04d_%s failed: %v", migration.Version, migration.Name, err)
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Could not record migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit().Error
+}
+
+// Status lists every registered migration and whether it has been applied
+func Status(db *gorm.DB) ([]State, error) {
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := All()
+	states := make([]State, len(migrations), len(migrations))
+	for index, migration := range migrations {
+		row, ok := done[migration.Version]
+		states[index] = State{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: row.AppliedAt,
+		}
+	}
+
+	return states, nil
+}
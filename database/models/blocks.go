@@ -0,0 +1,368 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// The kinds of values a block field can hold
+const (
+	FieldString  = "string"
+	FieldInteger = "integer"
+	FieldBoolean = "boolean"
+	FieldList    = "array"
+)
+
+// FieldSchema describes a single field in a block's data
+type FieldSchema struct {
+	Type     string
+	Required bool
+	// MaxLength limits the characters in strings and the amount of items in lists, zero means
+	// no limit
+	MaxLength int
+	// Minimum and Maximum limit integers when Maximum isn't zero
+	Minimum int
+	Maximum int
+	// URL requires strings to be absolute http or https urls
+	URL bool
+}
+
+// BlockType is a kind of content block which can be stored in a paragraph
+type BlockType struct {
+	Name   string
+	Fields map[string]FieldSchema
+	// ContentField is the field stored in Paragraph.Content, which is used for search and by
+	// clients that only send content
+	ContentField string
+}
+
+// blockTypes is the registry of the block types paragraphs can contain
+var blockTypes = map[string]BlockType{}
+
+// blockAliases maps older type names to the registered block types
+var blockAliases = map[string]string{
+	"text": "paragraph",
+}
+
+func init() {
+	RegisterBlockType(BlockType{
+		Name:         "paragraph",
+		ContentField: "text",
+		Fields: map[string]FieldSchema{
+			"text": {Type: FieldString, Required: true},
+		},
+	})
+
+	RegisterBlockType(BlockType{
+		Name:         "heading",
+		ContentField: "text",
+		Fields: map[string]FieldSchema{
+			"text":  {Type: FieldString, Required: true, MaxLength: 300},
+			"level": {Type: FieldInteger, Required: true, Minimum: 1, Maximum: 6},
+		},
+	})
+
+	RegisterBlockType(BlockType{
+		Name:         "code",
+		ContentField: "code",
+		Fields: map[string]FieldSchema{
+			"code":     {Type: FieldString, Required: true},
+			"language": {Type: FieldString, MaxLength: 32},
+		},
+	})
+
+	RegisterBlockType(BlockType{
+		Name:         "quote",
+		ContentField: "text",
+		Fields: map[string]FieldSchema{
+			"text": {Type: FieldString, Required: true},
+			"cite": {Type: FieldString, MaxLength: 300},
+		},
+	})
+
+	RegisterBlockType(BlockType{
+		Name:         "image",
+		ContentField: "caption",
+		Fields: map[string]FieldSchema{
+			"url":     {Type: FieldString, Required: true, URL: true},
+			"caption": {Type: FieldString, MaxLength: 500},
+			"alt":     {Type: FieldString, MaxLength: 300},
+		},
+	})
+
+	RegisterBlockType(BlockType{
+		Name:         "list",
+		ContentField: "items",
+		Fields: map[string]FieldSchema{
+			"items":   {Type: FieldList, Required: true, MaxLength: 500},
+			"ordered": {Type: FieldBoolean},
+		},
+	})
+
+	RegisterBlockType(BlockType{
+		Name:         "embed",
+		ContentField: "url",
+		Fields: map[string]FieldSchema{
+			"url":      {Type: FieldString, Required: true, URL: true},
+			"provider": {Type: FieldString, MaxLength: 64},
+		},
+	})
+}
+
+// RegisterBlockType adds a block type to the registry
+func RegisterBlockType(blockType BlockType) {
+	blockTypes[blockType.Name] = blockType
+}
+
+// FindBlockType finds a registered block type by its name or one of its aliases
+func FindBlockType(name string) (BlockType, bool) {
+	if alias, ok := blockAliases[name]; ok {
+		name = alias
+	}
+
+	blockType, ok := blockTypes[name]
+	return blockType, ok
+}
+
+// BuildParagraph validates the block and creates an unsaved paragraph from it. Clients which
+// only send content get it placed in the block type's content field.
+func BuildParagraph(typeName, content string, rawData json.RawMessage) (Paragraph, error) {
+	var paragraph Paragraph
+
+	blockType, ok := FindBlockType(typeName)
+	if !ok {
+		return paragraph, fmt.Errorf("Unknown block type: %s", typeName)
+	}
+
+	data := map[string]interface{}{}
+	if len(rawData) > 0 && string(rawData) != "null" {
+		if err := json.Unmarshal(rawData, &data); err != nil {
+			return paragraph, fmt.Errorf("Block data should be an object: %v", err)
+		}
+	} else if content != "" {
+		data[blockType.ContentField] = blockType.fromContent(content)
+	}
+
+	if err := blockType.Validate(data); err != nil {
+		return paragraph, err
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return paragraph, err
+	}
+
+	paragraph.Type = blockType.Name
+	paragraph.Content = blockType.content(data)
+	paragraph.Data = string(encoded)
+	return paragraph, nil
+}
+
+// legacyListSeparator ends every list item in the content sent by older editor versions
+const legacyListSeparator = "|LIST|"
+
+// fromContent turns content into a value for the content field. Lists have an item per line,
+// or items ending in the legacy separator.
+func (blockType BlockType) fromContent(content string) interface{} {
+	if blockType.Fields[blockType.ContentField].Type != FieldList {
+		return content
+	}
+
+	var lines []string
+	if strings.Contains(content, legacyListSeparator) {
+		lines = strings.Split(strings.TrimSuffix(content, legacyListSeparator), legacyListSeparator)
+	} else {
+		lines = strings.Split(content, "\n")
+	}
+
+	items := make([]interface{}, len(lines), len(lines))
+	for index := range lines {
+		items[index] = lines[index]
+	}
+
+	return items
+}
+
+// content returns the text stored in Paragraph.Content for the given data
+func (blockType BlockType) content(data map[string]interface{}) string {
+	switch value := data[blockType.ContentField].(type) {
+	case string:
+		return value
+	case []interface{}:
+		items := make([]string, len(value), len(value))
+		for index := range value {
+			items[index], _ = value[index].(string)
+		}
+
+		return strings.Join(items, "\n")
+	}
+
+	return ""
+}
+
+// Validate checks the data against the block type's fields
+func (blockType BlockType) Validate(data map[string]interface{}) error {
+	for name := range data {
+		if _, ok := blockType.Fields[name]; !ok {
+			return fmt.Errorf("Unknown field '%s' in %s block", name, blockType.Name)
+		}
+	}
+
+	for name, field := range blockType.Fields {
+		value, ok := data[name]
+		if !ok || value == nil {
+			if field.Required {
+				return fmt.Errorf("Field '%s' is required in %s block", name, blockType.Name)
+			}
+
+			delete(data, name)
+			continue
+		}
+
+		if err := field.validate(value); err != nil {
+			return fmt.Errorf("Field '%s' in %s block: %v", name, blockType.Name, err)
+		}
+
+		// JSON numbers are decoded as floats, store integers without a fraction
+		if field.Type == FieldInteger {
+			data[name] = int(value.(float64))
+		}
+	}
+
+	return nil
+}
+
+func (field FieldSchema) validate(value interface{}) error {
+	switch field.Type {
+	case FieldString:
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("should be a string")
+		}
+
+		if field.Required && strings.TrimSpace(text) == "" {
+			return fmt.Errorf("should not be empty")
+		}
+
+		if field.MaxLength > 0 && utf8.RuneCountInString(text) > field.MaxLength {
+			return fmt.Errorf("should be at most %d characters", field.MaxLength)
+		}
+
+		if field.URL {
+			parsed, err := url.Parse(text)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("should be an http or https url")
+			}
+		}
+	case FieldInteger:
+		number, ok := value.(float64)
+		if !ok || number != float64(int(number)) {
+			return fmt.Errorf("should be an integer")
+		}
+
+		if field.Maximum != 0 && (int(number) < field.Minimum || int(number) > field.Maximum) {
+			return fmt.Errorf("should be between %d and %d", field.Minimum, field.Maximum)
+		}
+	case FieldBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("should be a boolean")
+		}
+	case FieldList:
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("should be a list of strings")
+		}
+
+		if field.Required && len(items) == 0 {
+			return fmt.Errorf("should not be empty")
+		}
+
+		if field.MaxLength > 0 && len(items) > field.MaxLength {
+			return fmt.Errorf("should have at most %d items", field.MaxLength)
+		}
+
+		for index := range items {
+			if _, ok := items[index].(string); !ok {
+				return fmt.Errorf("should be a list of strings")
+			}
+		}
+	}
+
+	return nil
+}
+
+// JSONSchema describes the block's data as a JSON Schema document
+func (blockType BlockType) JSONSchema() common.JSON {
+	properties := common.JSON{}
+	required := []string{}
+	for name, field := range blockType.Fields {
+		property := common.JSON{"type": field.Type}
+		if field.Type == FieldList {
+			property["items"] = common.JSON{"type": FieldString}
+			if field.MaxLength > 0 {
+				property["maxItems"] = field.MaxLength
+			}
+		} else if field.MaxLength > 0 {
+			property["maxLength"] = field.MaxLength
+		}
+
+		if field.Maximum != 0 {
+			property["minimum"] = field.Minimum
+			property["maximum"] = field.Maximum
+		}
+
+		if field.URL {
+			property["format"] = "uri"
+		}
+
+		properties[name] = property
+		if field.Required {
+			required = append(required, name)
+		}
+	}
+
+	sort.Strings(required)
+	return common.JSON{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                blockType.Name,
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// BlockSchemas returns the JSON Schema of every registered block type
+func BlockSchemas() common.JSON {
+	schemas := common.JSON{}
+	for name, blockType := range blockTypes {
+		schemas[name] = blockType.JSONSchema()
+	}
+
+	return schemas
+}
+
+// Block returns the paragraph's structured block. Paragraphs saved before blocks were
+// validated don't have any data, so their content is used in the type's content field.
+func (p *Paragraph) Block() common.JSON {
+	data := map[string]interface{}{}
+	if p.Data == "" || json.Unmarshal([]byte(p.Data), &data) != nil {
+		typeName := p.Type
+		if blockType, ok := FindBlockType(p.Type); ok {
+			typeName = blockType.Name
+			data = map[string]interface{}{blockType.ContentField: blockType.fromContent(p.Content)}
+		} else {
+			data = map[string]interface{}{"text": p.Content}
+		}
+
+		return common.JSON{"type": typeName, "data": data}
+	}
+
+	return common.JSON{"type": p.Type, "data": data}
+}
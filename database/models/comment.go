@@ -0,0 +1,59 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// Comment data model. Replies point to their parent comment, top-level comments have no parent.
+type Comment struct {
+	gorm.Model
+	UUID     string
+	Content  string `gorm:"type:text"`
+	PostID   uint
+	UserID   uint
+	ParentID uint
+	// Removed comments are kept so that their replies stay in the thread
+	Removed bool
+}
+
+// CommentJSON formats the comment with its already loaded author, who can be nil. Removed
+// comments don't show their content or author.
+func CommentJSON(comment Comment, author *User) common.JSON {
+	serialized := common.JSON{
+		"uuid":       comment.UUID,
+		"removed":    comment.Removed,
+		"created_at": comment.CreatedAt,
+		"updated_at": comment.UpdatedAt,
+		"content":    nil,
+		"user":       nil,
+	}
+
+	if comment.Removed {
+		return serialized
+	}
+
+	serialized["content"] = comment.Content
+	if author != nil {
+		serialized["user"] = author.Serialize()
+	}
+
+	return serialized
+}
+
+// CommentTreeJSON formats the comments and nests their replies under them. The replies are
+// keyed by their parent's id and the authors by their id.
+func CommentTreeJSON(comments []Comment, replies map[uint][]Comment, authors map[uint]User) []common.JSON {
+	serialized := make([]common.JSON, len(comments), len(comments))
+	for index := range comments {
+		var author *User
+		if user, ok := authors[comments[index].UserID]; ok {
+			author = &user
+		}
+
+		serialized[index] = CommentJSON(comments[index], author)
+		serialized[index]["replies"] = CommentTreeJSON(replies[comments[index].ID], replies, authors)
+	}
+
+	return serialized
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// Identity links a user to their account at an external identity provider. The subject is
+// the provider's id for the account, and an account can only be linked to one user.
+type Identity struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	Provider string `gorm:"unique_index:idx_identities_provider_subject"`
+	Subject  string `gorm:"unique_index:idx_identities_provider_subject"`
+	Email    string
+}
+
+// Serialize formats the identity to JSON-format
+func (i *Identity) Serialize() common.JSON {
+	return common.JSON{
+		"provider": i.Provider,
+		"email":    i.Email,
+		"created":  i.CreatedAt,
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+// PlaceParagraph returns the post's paragraphs in their new order with the paragraph at the
+// given position. The paragraph is taken out of its old position first, so it can be both a
+// new and a moved paragraph. Positions outside of the post place the paragraph at the end,
+// the returned position is where it ended up.
+func PlaceParagraph(paragraphs []Paragraph, paragraph Paragraph, position int) ([]Paragraph, int) {
+	others := make([]Paragraph, 0, len(paragraphs))
+	for index := range paragraphs {
+		if paragraphs[index].ID != paragraph.ID {
+			others = append(others, paragraphs[index])
+		}
+	}
+
+	if position < 0 || position > len(others) {
+		position = len(others)
+	}
+
+	ordered := make([]Paragraph, 0, len(others)+1)
+	ordered = append(ordered, others[:position]...)
+	ordered = append(ordered, paragraph)
+	ordered = append(ordered, others[position:]...)
+	return ordered, position
+}
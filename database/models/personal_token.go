@@ -0,0 +1,57 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// PersonalTokenPrefix starts every personal access token, which tells them apart from JWTs
+const PersonalTokenPrefix = "blog_pat_"
+
+// PersonalToken lets scripts and integrations act as the user within the token's scopes.
+// Scopes are stored separated by spaces and only the token's hash is stored.
+type PersonalToken struct {
+	gorm.Model
+	UserID     uint `gorm:"index"`
+	Name       string
+	TokenHash  string `gorm:"unique_index"`
+	Scopes     string
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+}
+
+// ScopeList splits the scopes
+func (token *PersonalToken) ScopeList() []string {
+	return strings.Fields(token.Scopes)
+}
+
+// HasScope checks if the token was given the scope
+func (token *PersonalToken) HasScope(scope string) bool {
+	for _, granted := range token.ScopeList() {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Active checks if the token hasn't expired at the given time
+func (token *PersonalToken) Active(now time.Time) bool {
+	return token.ExpiresAt == nil || now.Before(*token.ExpiresAt)
+}
+
+// Serialize formats the token to JSON-format, without the token itself
+func (token *PersonalToken) Serialize() common.JSON {
+	return common.JSON{
+		"id":           token.ID,
+		"name":         token.Name,
+		"scopes":       token.ScopeList(),
+		"created":      token.CreatedAt,
+		"last_used_at": token.LastUsedAt,
+		"expires_at":   token.ExpiresAt,
+	}
+}
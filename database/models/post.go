@@ -1,32 +1,51 @@
 package models
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 	"github.com/nireo/go-blog-api/lib/common"
 )
 
-// Post data model
+// The states a post goes through. Only published posts are shown to other users and
+// scheduled posts are published by the scheduler once their PublishAt has passed.
+const (
+	PostStatusDraft     = "draft"
+	PostStatusScheduled = "scheduled"
+	PostStatusPublished = "published"
+	PostStatusArchived  = "archived"
+)
+
+// Post data model. The user isn't saved with the post, since it's usually the token's user
+// which only has an id and a username. Long texts are stored in text columns, since MySQL
+// limits strings to 255 characters.
 type Post struct {
 	gorm.Model
-	Text        string
+	Text        string `gorm:"type:text"`
 	Title       string
 	Likes       int
-	Description string
+	Description string `gorm:"type:text"`
 	ImageURL    string
-	User        User
+	User        User `gorm:"association_autoupdate:false;association_autocreate:false"`
 	UserID      uint
 	UUID        string
 	Paragraphs  []Paragraph
 	TopicID     uint
+	Status      string `gorm:"default:'published'"`
+	PublishAt   *time.Time
 }
 
-// Paragraph struct stores the post's content
+// Paragraph struct stores the post's content. Paragraphs are ordered by position, which
+// starts from zero in every post.
 type Paragraph struct {
 	gorm.Model
-	Type    string
-	Content string
-	PostID  uint
-	UUID    string
+	Type     string
+	Content  string `gorm:"type:text"`
+	PostID   uint
+	UUID     string
+	Position int
+	// Data is the JSON encoded block, validated against the block type's schema
+	Data string `gorm:"type:text"`
 }
 
 // PostLike model helps keeping track of if a user has already liked a post
@@ -36,17 +55,6 @@ type PostLike struct {
 	UserID      uint
 }
 
-// FindPostLikeModel finds a single postLike model matching given condition
-func FindPostLikeModel(condition interface{}) (PostLike, error) {
-	db := common.GetDatabase()
-	var postLike PostLike
-	if err := db.Where(condition).First(&postLike).Error; err != nil {
-		return postLike, err
-	}
-
-	return postLike, nil
-}
-
 // SerializeParagraphs serializes multiple paragraphs into JSON-format
 func SerializeParagraphs(paragraphs []Paragraph) []common.JSON {
 	serializedParagraphs := make([]common.JSON, len(paragraphs), len(paragraphs))
@@ -60,117 +68,57 @@ func SerializeParagraphs(paragraphs []Paragraph) []common.JSON {
 // Serialize paragraph data
 func (p *Paragraph) Serialize() common.JSON {
 	return common.JSON{
-		"type":    p.Type,
-		"content": p.Content,
-		"uuid":    p.UUID,
+		"type":     p.Type,
+		"content":  p.Content,
+		"uuid":     p.UUID,
+		"position": p.Position,
+		"block":    p.Block(),
 	}
 }
 
-func (post *Post) Save() {
-	db := common.GetDatabase()
-
-	db.NewRecord(post)
-	db.Create(&post)
-}
-
-func (post *Post) Delete() {
-	db := common.GetDatabase()
-
-	db.Delete(&post)
-}
-
-// SerializePosts serializes a list posts
-func SerializePosts(posts []Post) []common.JSON {
-	serializedPosts := make([]common.JSON, len(posts), len(posts))
-	for index := range posts {
-		serializedPosts[index] = posts[index].Serialize()
+// ValidPostStatus checks if the status is one of the post statuses
+func ValidPostStatus(status string) bool {
+	switch status {
+	case PostStatusDraft, PostStatusScheduled, PostStatusPublished, PostStatusArchived:
+		return true
 	}
 
-	return serializedPosts
+	return false
 }
 
-// GetPostWithID returns a post correspodding to given id
-func GetPostWithID(id string) (Post, bool) {
-	db := common.GetDatabase()
-	var post Post
-	if err := db.Where("uuid = ?", id).First(&post).Error; err != nil {
-		return post, false
-	}
-
-	return post, true
+// PublishedPosts limits a post query to published posts
+func PublishedPosts(db *gorm.DB) *gorm.DB {
+	return db.Where("posts.status = ?", PostStatusPublished)
 }
 
-// FindOnePost finds a post matching the given condition
-func FindOnePost(condition interface{}) (Post, error) {
-	db := common.GetDatabase()
-
-	var post Post
-	if err := db.Where(condition).First(&post).Error; err != nil {
-		return post, err
-	}
+// PagePosts drops the extra post fetched by common.Page.Apply and returns the cursor for the next page
+func PagePosts(posts []Post, page common.Page) ([]Post, *common.Cursor) {
+	next := page.Next(len(posts), func(index int) (time.Time, uint) {
+		return posts[index].CreatedAt, posts[index].ID
+	})
 
-	return post, nil
+	return posts[:page.Visible(len(posts))], next
 }
 
-// GetParagraphsRelatedToPost gets all the paragraphs in a post
-func GetParagraphsRelatedToPost(post Post) ([]Paragraph, bool) {
-	db := common.GetDatabase()
-	var paragraphs []Paragraph
-	if err := db.Model(&post).Related(&paragraphs).Error; err != nil {
-		return paragraphs, false
-	}
-
-	return paragraphs, true
-}
-
-// GetPostsFromUser gets all the posts related to a given user
-func GetPostsFromUser(user User) ([]Post, bool) {
-	db := common.GetDatabase()
-	var posts []Post
-	if err := db.Model(&user).Related(&posts).Error; err != nil {
-		return posts, false
-	}
-
-	return posts, true
-}
-
-// GetPosts returns a list of posts within a given offset and limit range
-func GetPosts(offset, limit int) ([]Post, bool) {
-	db := common.GetDatabase()
-	var posts []Post
-	if err := db.Find(&posts).Offset(offset).Limit(limit).Error; err != nil {
-		return posts, false
-	}
-
-	return posts, true
-}
-
-// Serialize post data
-func (p *Post) Serialize() common.JSON {
-	db := common.GetDatabase()
-	var user User
-	if err := db.Where("id = ?", p.UserID).First(&user).Error; err != nil {
-		return common.JSON{
-			"id":          p.ID,
-			"text":        p.Text,
-			"title":       p.Title,
-			"likes":       p.Likes,
-			"description": p.Description,
-			"created_at":  p.CreatedAt,
-			"image_url":   p.ImageURL,
-			"uuid":        p.UUID,
-		}
-	}
-
-	return common.JSON{
+// PostJSON formats the post with its already loaded author, who can be nil, and comment count
+func PostJSON(p Post, author *User, comments int) common.JSON {
+	serialized := common.JSON{
 		"id":          p.ID,
 		"text":        p.Text,
 		"title":       p.Title,
 		"likes":       p.Likes,
 		"description": p.Description,
-		"user":        user.Serialize(),
 		"created_at":  p.CreatedAt,
 		"image_url":   p.ImageURL,
 		"uuid":        p.UUID,
+		"comments":    comments,
+		"status":      p.Status,
+		"publish_at":  p.PublishAt,
+	}
+
+	if author != nil {
+		serialized["user"] = author.Serialize()
 	}
+
+	return serialized
 }
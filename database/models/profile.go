@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// ProfileLink points to the user's account elsewhere, like GitHub or Mastodon
+type ProfileLink struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// Profile is what users tell about themselves. It's embedded in the user, so the fields are
+// columns of the users table, and the links are stored as JSON.
+type Profile struct {
+	DisplayName string
+	Bio         string `gorm:"type:text"`
+	Location    string
+	Website     string
+	Links       string `gorm:"type:text"`
+}
+
+// ProfileLinks decodes the links, which is an empty list when there are none
+func (p *Profile) ProfileLinks() []ProfileLink {
+	links := []ProfileLink{}
+	if p.Links != "" {
+		json.Unmarshal([]byte(p.Links), &links)
+	}
+
+	return links
+}
+
+// SetLinks encodes the links
+func (p *Profile) SetLinks(links []ProfileLink) error {
+	if len(links) == 0 {
+		p.Links = ""
+		return nil
+	}
+
+	encoded, err := json.Marshal(links)
+	if err != nil {
+		return err
+	}
+
+	p.Links = string(encoded)
+	return nil
+}
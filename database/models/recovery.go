@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// RecoveryCode lets a user with two-factor authentication log in without their
+// authenticator. Only the code's hash is stored and every code works once.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint `gorm:"index"`
+	CodeHash string
+	UsedAt   *time.Time
+}
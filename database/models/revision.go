@@ -0,0 +1,176 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// PostRevision is a snapshot of a post and its paragraphs taken after every edit
+type PostRevision struct {
+	gorm.Model
+	PostID      uint
+	UserID      uint
+	Number      int
+	Title       string
+	Description string `gorm:"type:text"`
+	Text        string `gorm:"type:text"`
+	// Paragraphs is the JSON encoded, ordered list of ParagraphSnapshots
+	Paragraphs string `gorm:"type:text"`
+}
+
+// ParagraphSnapshot stores the content of a single paragraph in a revision
+type ParagraphSnapshot struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Data    string `json:"data,omitempty"`
+}
+
+// ParagraphChange is a single step in the difference between two revisions
+type ParagraphChange struct {
+	Operation string            `json:"operation"`
+	Paragraph ParagraphSnapshot `json:"paragraph"`
+}
+
+// The operations in a paragraph diff
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// NewRevision creates the unsaved revision with the given number from the post and its
+// paragraphs in order
+func NewRevision(post Post, user User, number int, paragraphs []Paragraph) (PostRevision, error) {
+	snapshots := make([]ParagraphSnapshot, len(paragraphs), len(paragraphs))
+	for index := range paragraphs {
+		snapshots[index] = ParagraphSnapshot{
+			Type:    paragraphs[index].Type,
+			Content: paragraphs[index].Content,
+			Data:    paragraphs[index].Data,
+		}
+	}
+
+	encoded, err := json.Marshal(snapshots)
+	if err != nil {
+		return PostRevision{}, err
+	}
+
+	return PostRevision{
+		PostID:      post.ID,
+		UserID:      user.ID,
+		Number:      number,
+		Title:       post.Title,
+		Description: post.Description,
+		Text:        post.Text,
+		Paragraphs:  string(encoded),
+	}, nil
+}
+
+// Snapshots decodes the revision's paragraphs
+func (revision *PostRevision) Snapshots() ([]ParagraphSnapshot, error) {
+	var snapshots []ParagraphSnapshot
+	err := json.Unmarshal([]byte(revision.Paragraphs), &snapshots)
+	return snapshots, err
+}
+
+// RestoredParagraphs creates unsaved paragraphs from the revision's snapshots
+func (revision *PostRevision) RestoredParagraphs() ([]Paragraph, error) {
+	snapshots, err := revision.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	paragraphs := make([]Paragraph, len(snapshots), len(snapshots))
+	for index := range snapshots {
+		paragraphs[index] = Paragraph{
+			Type:    snapshots[index].Type,
+			Content: snapshots[index].Content,
+			Data:    snapshots[index].Data,
+		}
+	}
+
+	return paragraphs, nil
+}
+
+// DiffParagraphs returns the steps which turn the from paragraphs into the to paragraphs.
+// It uses the longest common subsequence, so unchanged paragraphs are kept even when
+// paragraphs have been added or removed around them.
+func DiffParagraphs(from, to []ParagraphSnapshot) []ParagraphChange {
+	// lengths[i][j] is the length of the longest common subsequence of from[i:] and to[j:]
+	lengths := make([][]int, len(from)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(to)+1)
+	}
+
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	changes := make([]ParagraphChange, 0, len(from)+len(to))
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			changes = append(changes, ParagraphChange{Operation: DiffEqual, Paragraph: from[i]})
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			changes = append(changes, ParagraphChange{Operation: DiffDelete, Paragraph: from[i]})
+			i++
+		default:
+			changes = append(changes, ParagraphChange{Operation: DiffInsert, Paragraph: to[j]})
+			j++
+		}
+	}
+
+	for ; i < len(from); i++ {
+		changes = append(changes, ParagraphChange{Operation: DiffDelete, Paragraph: from[i]})
+	}
+
+	for ; j < len(to); j++ {
+		changes = append(changes, ParagraphChange{Operation: DiffInsert, Paragraph: to[j]})
+	}
+
+	return changes
+}
+
+// Serialize formats the revision to JSON-format
+func (revision *PostRevision) Serialize() common.JSON {
+	snapshots, err := revision.Snapshots()
+	if err != nil {
+		snapshots = []ParagraphSnapshot{}
+	}
+
+	return common.JSON{
+		"number":      revision.Number,
+		"title":       revision.Title,
+		"description": revision.Description,
+		"text":        revision.Text,
+		"paragraphs":  snapshots,
+		"created_at":  revision.CreatedAt,
+	}
+}
+
+// SerializeRevisions serializes a list of revisions without their paragraphs
+func SerializeRevisions(revisions []PostRevision) []common.JSON {
+	serializedRevisions := make([]common.JSON, len(revisions), len(revisions))
+	for index := range revisions {
+		serializedRevisions[index] = common.JSON{
+			"number":     revisions[index].Number,
+			"title":      revisions[index].Title,
+			"created_at": revisions[index].CreatedAt,
+		}
+	}
+
+	return serializedRevisions
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The kinds of security events. Lockouts are written when too many failed logins lock a
+// username or an ip and unlocks when someone lifts the lock.
+const (
+	SecurityEventLockout = "lockout"
+	SecurityEventUnlock  = "unlock"
+)
+
+// SecurityEvent is a row in the security log. Subject is the locked key, like user:alice or
+// ip:127.0.0.1, and UserID is set when the key belongs to a user. ActorID is the user who
+// caused the event, if it wasn't an anonymous client.
+type SecurityEvent struct {
+	gorm.Model
+	Kind      string `gorm:"index"`
+	Subject   string
+	UserID    *uint `gorm:"index"`
+	ActorID   *uint
+	IPAddress string
+	Until     *time.Time
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// Session is a single login on a device. Its refresh tokens and the access tokens issued
+// with them carry the session, so revoking it logs that device out right away. The session
+// ends when its latest refresh token expires.
+type Session struct {
+	gorm.Model
+	UserID     uint `gorm:"index"`
+	UserAgent  string
+	IPAddress  string
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+// Active checks if the session can still be used at the given time
+func (session *Session) Active(now time.Time) bool {
+	return session.RevokedAt == nil && now.Before(session.ExpiresAt)
+}
+
+// Serialize formats the session to JSON-format
+func (session *Session) Serialize() common.JSON {
+	return common.JSON{
+		"id":           session.ID,
+		"user_agent":   session.UserAgent,
+		"ip_address":   session.IPAddress,
+		"created":      session.CreatedAt,
+		"last_seen_at": session.LastSeenAt,
+		"expires_at":   session.ExpiresAt,
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// RefreshToken can be exchanged for a new access token until it expires or is revoked. Only
+// the token's hash is stored and every token is revoked once it has been used. SessionID is
+// zero for tokens given out before sessions were recorded.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	SessionID uint   `gorm:"index"`
+	TokenHash string `gorm:"unique_index"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// Active checks if the token can still be used at the given time
+func (token *RefreshToken) Active(now time.Time) bool {
+	return token.RevokedAt == nil && now.Before(token.ExpiresAt)
+}
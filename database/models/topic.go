@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 	"github.com/nireo/go-blog-api/lib/common"
 )
@@ -9,7 +11,7 @@ import (
 type Topic struct {
 	gorm.Model
 	Title       string
-	Description string
+	Description string `gorm:"type:text"`
 	UUID        string
 	URL         string
 	UserID      uint
@@ -26,63 +28,13 @@ func SerializeTopics(topics []Topic) []common.JSON {
 	return serializedTopics
 }
 
-// GetPostsRelatedToTopic finds all posts in the topic's category
-func GetPostsRelatedToTopic(topic Topic) ([]Post, bool) {
-	db := common.GetDatabase()
-	var posts []Post
-	if err := db.Model(&topic).Related(&posts).Error; err != nil {
-		return posts, false
-	}
-
-	return posts, true
-}
-
-// Delete deletes the given topic's entry from the database
-func (topic *Topic) Delete() {
-	db := common.GetDatabase()
-
-	db.Delete(&topic)
-}
-
-func (topic *Topic) Save() {
-	db := common.GetDatabase()
-
-	db.NewRecord(topic)
-	db.Create(&topic)
-}
-
-// GetAllTopics gets all in the database
-func GetAllTopics() ([]Topic, bool) {
-	db := common.GetDatabase()
-	var topics []Topic
-	if err := db.Find(&topics).Error; err != nil {
-		return topics, false
-	}
-
-	return topics, true
-}
-
-// FindOneTopic finds a single topic with the given condition
-func FindOneTopic(condition interface{}) (Topic, error) {
-	db := common.GetDatabase()
-
-	var topic Topic
-	if err := db.Where(condition).First(&topic).Error; err != nil {
-		return topic, err
-	}
-
-	return topic, nil
-}
-
-// GetAllUsersTopics returns all the topics created by the given user
-func GetAllUsersTopics(user User) ([]Topic, bool) {
-	db := common.GetDatabase()
-	var topics []Topic
-	if err := db.Model(&user).Related(&topics).Error; err != nil {
-		return topics, false
-	}
+// PageTopics drops the extra topic fetched by common.Page.Apply and returns the cursor for the next page
+func PageTopics(topics []Topic, page common.Page) ([]Topic, *common.Cursor) {
+	next := page.Next(len(topics), func(index int) (time.Time, uint) {
+		return topics[index].CreatedAt, topics[index].ID
+	})
 
-	return topics, true
+	return topics[:page.Visible(len(topics))], next
 }
 
 // Serialize formats topic to JSON-format
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// IsUniqueViolation tells if the database rejected a write because of a unique index. Two
+// requests can both pass the check for an existing row before either inserts, so the index
+// has the final say. Every driver reports the violation with its own error type.
+func IsUniqueViolation(err error) bool {
+	switch err := err.(type) {
+	case sqlite3.Error:
+		return err.ExtendedCode == sqlite3.ErrConstraintUnique || err.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	case *pq.Error:
+		return err.Code == "23505"
+	case *mysql.MySQLError:
+		return err.Number == 1062
+	}
+
+	return false
+}
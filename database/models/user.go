@@ -2,25 +2,88 @@ package models
 
 import (
 	"errors"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nireo/go-blog-api/lib/common"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// User data model
+// The roles a user can have. Moderators can remove other users' content and admins can
+// manage users as well.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// User data model. TokenVersion is embedded in access tokens and bumping it revokes every
+// access token given out before. Suspended users can't log in. Email is nil for users who
+// haven't given one, so that the unique index allows many of them.
+//
+// TOTPSecret is set when the user starts enrolling in two-factor authentication, which is
+// on once TOTPEnabledAt is set. TOTPLastStep is the time step of the last accepted code,
+// so that a code can't be used twice.
+//
+// AvatarKey is where the uploaded avatar is kept in the storage and AvatarURL is its public
+// address.
 type User struct {
 	gorm.Model
-	Username     string
-	PasswordHash string
-	UUID         string
-	URL          string
+	Username        string
+	PasswordHash    string
+	UUID            string
+	URL             string
+	TokenVersion    int    `gorm:"not null;default:0"`
+	Role            string `gorm:"not null;default:'user'"`
+	SuspendedAt     *time.Time
+	Email           *string `gorm:"unique_index"`
+	EmailVerifiedAt *time.Time
+	TOTPSecret      string
+	TOTPEnabledAt   *time.Time
+	TOTPLastStep    int64 `gorm:"not null;default:0"`
+	Profile
+	AvatarKey string
+	AvatarURL string
+}
+
+// ValidRole checks if the role is one of the user roles
+func ValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+
+	return false
+}
+
+// EmailAddress returns the user's email address or an empty string if they haven't given one
+func (u *User) EmailAddress() string {
+	if u.Email == nil {
+		return ""
+	}
+
+	return *u.Email
+}
+
+// EmailVerified checks if the user has confirmed that their email address belongs to them
+func (u *User) EmailVerified() bool {
+	return u.Email != nil && u.EmailVerifiedAt != nil
+}
+
+// TwoFactorEnabled checks if the user has to give a TOTP code when logging in
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPSecret != "" && u.TOTPEnabledAt != nil
+}
+
+// Suspended checks if the user has been suspended
+func (u *User) Suspended() bool {
+	return u.SuspendedAt != nil
 }
 
 // FollowedTopic bypasses using many2many and makes code cleaner
 type FollowedTopic struct {
 	gorm.Model
-	User          User
+	User          User `gorm:"association_autoupdate:false;association_autocreate:false"`
 	UserID        uint
 	FollowedTopic Topic
 	TopicID       uint
@@ -29,49 +92,43 @@ type FollowedTopic struct {
 // Follow data model
 type Follow struct {
 	gorm.Model
-	Following    User
+	Following    User `gorm:"association_autoupdate:false;association_autocreate:false"`
 	FollowingID  uint
-	FollowedBy   User
+	FollowedBy   User `gorm:"association_autoupdate:false;association_autocreate:false"`
 	FollowedByID uint
 }
 
-// Serialize serializes the followed model to just show user
-func (follow *Follow) Serialize() common.JSON {
-	db := common.GetDatabase()
-	var user User
-	if err := db.Where("id = ?", follow.FollowingID).First(&user).Error; err != nil {
-		return common.JSON{
-			"user": user,
-		}
-	}
-
-	return common.JSON{
-		"user": user.Serialize(),
-	}
-}
-
 // Serialize user data
 func (u *User) Serialize() common.JSON {
+	serialized := u.Claims()
+	serialized["display_name"] = u.DisplayName
+	serialized["bio"] = u.Bio
+	serialized["location"] = u.Location
+	serialized["website"] = u.Website
+	serialized["links"] = u.ProfileLinks()
+	serialized["avatar_url"] = u.AvatarURL
+	return serialized
+}
+
+// Claims formats the user for the access tokens, which leave out the profile to stay small
+func (u *User) Claims() common.JSON {
 	return common.JSON{
 		"uuid":     u.UUID,
 		"username": u.Username,
 		"url":      u.URL,
 		"created":  u.CreatedAt,
 		"id":       u.ID,
+		"role":     u.Role,
 	}
 }
 
-func (user *User) Save() {
-	db := common.GetDatabase()
+// PageUsers drops the extra user fetched by common.Page.Apply and returns the cursor for the next page
+func PageUsers(users []User, page common.Page) ([]User, *common.Cursor) {
+	next := page.Next(len(users), func(index int) (time.Time, uint) {
+		return users[index].CreatedAt, users[index].ID
+	})
 
-	db.NewRecord(user)
-	db.Save(&user)
-}
-
-func (user *User) Delete() {
-	db := common.GetDatabase()
-
-	db.Delete(&user)
+	return users[:page.Visible(len(users))], next
 }
 
 // SerializeUsers serializes a list of users
@@ -84,18 +141,6 @@ func SerializeUsers(users []User) []common.JSON {
 	return serializedUsers
 }
 
-// FindOneUser finds a single topic with the given condition
-func FindOneUser(condition interface{}) (User, error) {
-	db := common.GetDatabase()
-
-	var user User
-	if err := db.Where(condition).First(&user).Error; err != nil {
-		return user, err
-	}
-
-	return user, nil
-}
-
 // SetPassword sets a new hashed password to the user
 func (u *User) SetPassword(newPassword string) error {
 	if len(newPassword) > 5 {
@@ -112,43 +157,6 @@ func (u *User) CheckPassword(password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
 }
 
-// IsFollowing checks for a follow model between 2 users
-func (u *User) IsFollowing(following User) bool {
-	db := common.GetDatabase()
-	var follow Follow
-	db.Where(Follow{FollowedByID: u.ID, FollowingID: following.ID}).First(&follow)
-	return follow.ID != 0
-}
-
-// UnFollow removes a follow model between 2 users
-func (u *User) UnFollow(unFollowUser User) error {
-	if !u.IsFollowing(unFollowUser) {
-		return errors.New("User is not following this user")
-	}
-
-	db := common.GetDatabase()
-	err := db.Where(Follow{FollowedByID: u.ID, FollowingID: unFollowUser.ID}).Delete(Follow{}).Error
-	return err
-}
-
-// IsFollowingTopic checks if a user is following a certain topic
-func (u *User) IsFollowingTopic(topic Topic) bool {
-	db := common.GetDatabase()
-	var isFollowing FollowedTopic
-	db.Where(FollowedTopic{UserID: u.ID, TopicID: topic.ID})
-	return isFollowing.ID != 0
-}
-
-// UnFollowTopic removes a user's following of a topic
-func (u *User) UnFollowTopic(topic Topic) error {
-	if !u.IsFollowingTopic(topic) {
-		return errors.New("User is not following this topic")
-	}
-
-	db := common.GetDatabase()
-	return db.Where(FollowedTopic{UserID: u.ID, TopicID: topic.ID}).Delete(FollowedTopic{}).Error
-}
-
 func (u *User) Read(m common.JSON) {
 	u.ID = uint(m["id"].(float64))
 	u.Username = m["username"].(string)
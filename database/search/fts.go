@@ -0,0 +1,139 @@
+package search
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/database/models"
+)
+
+// FTSEngine searches posts using a SQLite FTS5 virtual table
+type FTSEngine struct {
+	db *gorm.DB
+}
+
+type ftsRow struct {
+	PostID     uint
+	SearchRank float64
+	Snippet    string
+}
+
+// NewFTSEngine creates the post_search table if needed. It fails if the SQLite driver has
+// been built without FTS5 support. When the table is new, all of the existing posts are indexed.
+func NewFTSEngine(db *gorm.DB) (*FTSEngine, error) {
+	engine := &FTSEngine{db: db}
+
+	var existing int
+	if err := db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'post_search'").Row().Scan(&existing); err != nil {
+		return nil, err
+	}
+
+	if existing > 0 {
+		return engine, nil
+	}
+
+	err := db.Exec("CREATE VIRTUAL TABLE post_search USING fts5(title, description, content, post_id UNINDEXED, tokenize = 'porter unicode61')").Error
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []models.Post
+	if err := db.Find(&posts).Error; err != nil {
+		return nil, err
+	}
+
+	for index := range posts {
+		if err := engine.Index(posts[index]); err != nil {
+			return nil, err
+		}
+	}
+
+	return engine, nil
+}
+
+// Index replaces the post's row in the FTS table
+func (engine *FTSEngine) Index(post models.Post) error {
+	paragraphs, err := postParagraphs(engine.db, post)
+	if err != nil {
+		return err
+	}
+
+	contents := make([]string, len(paragraphs), len(paragraphs))
+	for index := range paragraphs {
+		contents[index] = paragraphs[index].Content
+	}
+
+	tx := engine.db.Begin()
+	if err := tx.Exec("DELETE FROM post_search WHERE post_id = ?", post.ID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Exec("INSERT INTO post_search (title, description, content, post_id) VALUES (?, ?, ?, ?)",
+		post.Title, post.Description, strings.Join(contents, "\n"), post.ID).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// Remove deletes the post's row from the FTS table
+func (engine *FTSEngine) Remove(post models.Post) error {
+	return engine.db.Exec("DELETE FROM post_search WHERE post_id = ?", post.ID).Error
+}
+
+// Search ranks the matches with bm25, weighting title matches over description and content matches
+func (engine *FTSEngine) Search(query Query) ([]Result, error) {
+	words := terms(query.Text)
+	if len(words) == 0 {
+		return []Result{}, nil
+	}
+
+	// quote every term so that user input can't use the FTS5 query syntax
+	for index := range words {
+		words[index] = `"` + words[index] + `"*`
+	}
+
+	sql := "SELECT post_search.post_id AS post_id, bm25(post_search, 10.0, 5.0, 1.0) AS search_rank, " +
+		"snippet(post_search, -1, ?, ?, '...', 16) AS snippet " +
+		"FROM post_search JOIN posts ON posts.id = post_search.post_id " +
+		"WHERE post_search MATCH ? AND posts.deleted_at IS NULL AND posts.status = ?"
+	values := []interface{}{highlightStart, highlightEnd, strings.Join(words, " "), models.PostStatusPublished}
+
+	if query.TopicID != 0 {
+		sql += " AND posts.topic_id = ?"
+		values = append(values, query.TopicID)
+	}
+
+	if query.UserID != 0 {
+		sql += " AND posts.user_id = ?"
+		values = append(values, query.UserID)
+	}
+
+	sql += " ORDER BY search_rank LIMIT ? OFFSET ?"
+	values = append(values, query.Limit, query.Offset)
+
+	var rows []ftsRow
+	if err := engine.db.Raw(sql, values...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(rows))
+	for index := range rows {
+		var post models.Post
+		if err := engine.db.Where("id = ?", rows[index].PostID).First(&post).Error; err != nil {
+			continue
+		}
+
+		// bm25 gives better matches a lower score, flip it so that a bigger rank is better
+		results = append(results, Result{
+			Post:    post,
+			Rank:    -rows[index].SearchRank,
+			Snippet: renderSnippet(rows[index].Snippet),
+		})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,198 @@
+package search
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/database/models"
+)
+
+// snippetRadius is the amount of characters shown around the first match in a snippet
+const snippetRadius = 60
+
+// LikeEngine searches posts with LIKE queries. It works on every database, but it doesn't
+// need an index, so it reads the whole post table on every search.
+type LikeEngine struct {
+	db *gorm.DB
+}
+
+// NewLikeEngine creates a search engine which doesn't need any extra tables
+func NewLikeEngine(db *gorm.DB) *LikeEngine {
+	return &LikeEngine{db: db}
+}
+
+// Index does nothing since the LIKE engine queries the post tables directly
+func (engine *LikeEngine) Index(post models.Post) error {
+	return nil
+}
+
+// Remove does nothing since the LIKE engine queries the post tables directly
+func (engine *LikeEngine) Remove(post models.Post) error {
+	return nil
+}
+
+// Search requires every term to be found in the title, description or a paragraph. Posts are
+// ranked by the amount of terms found in the title and description.
+func (engine *LikeEngine) Search(query Query) ([]Result, error) {
+	words := terms(query.Text)
+	if len(words) == 0 {
+		return []Result{}, nil
+	}
+
+	db := models.PublishedPosts(engine.db.Model(&models.Post{}))
+	rank := make([]string, 0, len(words)*2)
+	rankValues := make([]interface{}, 0, len(words)*2)
+	for _, word := range words {
+		pattern := "%" + word + "%"
+		db = db.Where("LOWER(posts.title) LIKE ? OR LOWER(posts.description) LIKE ? OR EXISTS "+
+			"(SELECT 1 FROM paragraphs WHERE paragraphs.post_id = posts.id AND paragraphs.deleted_at IS NULL AND LOWER(paragraphs.content) LIKE ?)",
+			pattern, pattern, pattern)
+
+		rank = append(rank, "CASE WHEN LOWER(posts.title) LIKE ? THEN 3 ELSE 0 END", "CASE WHEN LOWER(posts.description) LIKE ? THEN 2 ELSE 0 END")
+		rankValues = append(rankValues, pattern, pattern)
+	}
+
+	if query.TopicID != 0 {
+		db = db.Where("posts.topic_id = ?", query.TopicID)
+	}
+
+	if query.UserID != 0 {
+		db = db.Where("posts.user_id = ?", query.UserID)
+	}
+
+	rankSQL := strings.Join(rank, " + ")
+	var posts []models.Post
+	err := db.Select("posts.*, ("+rankSQL+") AS search_rank", rankValues...).
+		Order("search_rank desc").Order("posts.created_at desc").
+		Limit(query.Limit).Offset(query.Offset).Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(posts), len(posts))
+	for index := range posts {
+		results[index] = Result{
+			Post:    posts[index],
+			Rank:    float64(engine.rank(posts[index], words)),
+			Snippet: renderSnippet(engine.snippet(posts[index], words)),
+		}
+	}
+
+	return results, nil
+}
+
+// rank mirrors the ranking done in SQL, so that it can be shown to the client
+func (engine *LikeEngine) rank(post models.Post, words []string) int {
+	title := strings.ToLower(post.Title)
+	description := strings.ToLower(post.Description)
+
+	rank := 0
+	for _, word := range words {
+		if strings.Contains(title, word) {
+			rank += 3
+		}
+
+		if strings.Contains(description, word) {
+			rank += 2
+		}
+	}
+
+	return rank
+}
+
+// snippet cuts the text around the first match and highlights every matching term in it
+func (engine *LikeEngine) snippet(post models.Post, words []string) string {
+	texts := []string{post.Title, post.Description}
+	if paragraphs, err := postParagraphs(engine.db, post); err == nil {
+		for index := range paragraphs {
+			texts = append(texts, paragraphs[index].Content)
+		}
+	}
+
+	for _, text := range texts {
+		lower := strings.ToLower(text)
+		if len(lower) != len(text) {
+			// lowercasing changed the byte offsets, so positions in lower can't be used in text
+			continue
+		}
+
+		for _, word := range words {
+			position := strings.Index(lower, word)
+			if position < 0 {
+				continue
+			}
+
+			start := position - snippetRadius
+			if start < 0 {
+				start = 0
+			}
+
+			end := position + len(word) + snippetRadius
+			if end > len(text) {
+				end = len(text)
+			}
+
+			// don't cut multi-byte characters in half
+			for start > 0 && !utf8.RuneStart(text[start]) {
+				start--
+			}
+
+			for end < len(text) && !utf8.RuneStart(text[end]) {
+				end++
+			}
+
+			snippet := highlight(text[start:end], words)
+			if start > 0 {
+				snippet = "..." + snippet
+			}
+
+			if end < len(text) {
+				snippet += "..."
+			}
+
+			return snippet
+		}
+	}
+
+	return ""
+}
+
+// highlight wraps every occurrence of the words in highlight markers
+func highlight(text string, words []string) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		return text
+	}
+
+	marked := make([]bool, len(text))
+	for _, word := range words {
+		for offset := 0; offset < len(lower); {
+			position := strings.Index(lower[offset:], word)
+			if position < 0 {
+				break
+			}
+
+			for index := offset + position; index < offset+position+len(word); index++ {
+				marked[index] = true
+			}
+
+			offset += position + len(word)
+		}
+	}
+
+	var builder strings.Builder
+	for index := 0; index < len(text); index++ {
+		if marked[index] && (index == 0 || !marked[index-1]) {
+			builder.WriteString(highlightStart)
+		}
+
+		builder.WriteByte(text[index])
+
+		if marked[index] && (index == len(text)-1 || !marked[index+1]) {
+			builder.WriteString(highlightEnd)
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,129 @@
+package search
+
+import (
+	"encoding/base64"
+	"errors"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/database/models"
+)
+
+// markers wrapped around matched terms before the snippet is escaped, they are later replaced with <mark> tags
+const (
+	highlightStart = "\x01"
+	highlightEnd   = "\x02"
+)
+
+// Query describes a single search request
+type Query struct {
+	Text    string
+	TopicID uint
+	UserID  uint
+	Limit   int
+	Offset  int
+}
+
+// Result is a single post matching a query
+type Result struct {
+	Post    models.Post
+	Rank    float64
+	Snippet string
+}
+
+// Engine is implemented by the different search backends
+type Engine interface {
+	// Index adds the post and its paragraphs to the index, replacing any earlier version
+	Index(post models.Post) error
+	// Remove drops the post from the index
+	Remove(post models.Post) error
+	// Search returns the published posts matching the query, most relevant first
+	Search(query Query) ([]Result, error)
+}
+
+// New picks the best search engine supported by the database. SQLite uses FTS5 when the
+// driver has been compiled with it, which needs the sqlite_fts5 build tag, and everything
+// else falls back to LIKE queries.
+func New(db *gorm.DB) Engine {
+	if db.Dialect().GetName() == "sqlite3" {
+		fts, err := NewFTSEngine(db)
+		if err == nil {
+			return fts
+		}
+
+		log.Println("FTS5 isn't available, searching with LIKE queries instead. Build with -tags sqlite_fts5 to enable it:", err)
+	}
+
+	return NewLikeEngine(db)
+}
+
+// Posts returns the posts of the results in order, so that they can be serialized together
+func Posts(results []Result) []models.Post {
+	posts := make([]models.Post, len(results), len(results))
+	for index := range results {
+		posts[index] = results[index].Post
+	}
+
+	return posts
+}
+
+// SerializeResults formats the results with their already serialized posts, which are in the
+// same order as the results
+func SerializeResults(results []Result, posts []map[string]interface{}) []map[string]interface{} {
+	serializedResults := make([]map[string]interface{}, len(results), len(results))
+	for index := range results {
+		serializedResults[index] = map[string]interface{}{
+			"post":    posts[index],
+			"rank":    results[index].Rank,
+			"snippet": results[index].Snippet,
+		}
+	}
+
+	return serializedResults
+}
+
+// postParagraphs loads the post's paragraphs in order
+func postParagraphs(db *gorm.DB, post models.Post) ([]models.Paragraph, error) {
+	var paragraphs []models.Paragraph
+	err := db.Where("post_id = ?", post.ID).Order("position asc").Order("id asc").Find(&paragraphs).Error
+	return paragraphs, err
+}
+
+// EncodeOffset turns a result offset into an opaque cursor. Results are ordered by relevance
+// instead of creation time, so the common created_at cursor cannot be used here.
+func EncodeOffset(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// DecodeOffset parses a cursor created by EncodeOffset
+func DecodeOffset(token string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errors.New("Invalid cursor")
+	}
+
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, errors.New("Invalid cursor")
+	}
+
+	return offset, nil
+}
+
+// terms splits the query text into lowercase words, dropping punctuation
+func terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// renderSnippet escapes the snippet and turns the highlight markers into <mark> tags
+func renderSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.Replace(escaped, highlightStart, "<mark>", -1)
+	return strings.Replace(escaped, highlightEnd, "</mark>", -1)
+}
@@ -0,0 +1,1419 @@
+package store
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/search"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// NewGormStores creates stores which keep everything in the given database
+func NewGormStores(db *gorm.DB) Stores {
+	return Stores{
+		Users:          &GormUserStore{db: db},
+		Posts:          &GormPostStore{db: db},
+		Topics:         &GormTopicStore{db: db},
+		Follows:        &GormFollowStore{db: db},
+		Likes:          &GormLikeStore{db: db},
+		Comments:       &GormCommentStore{db: db},
+		Revisions:      &GormRevisionStore{db: db},
+		Tokens:         &GormRefreshTokenStore{db: db},
+		Identities:     &GormIdentityStore{db: db},
+		RecoveryCodes:  &GormRecoveryCodeStore{db: db},
+		PersonalTokens: &GormPersonalTokenStore{db: db},
+		SecurityEvents: &GormSecurityEventStore{db: db},
+		Sessions:       &GormSessionStore{db: db},
+		Search:         search.New(db),
+	}
+}
+
+// notFound turns gorm's missing record error into ErrNotFound
+func notFound(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// GormUserStore stores users in a gorm database
+type GormUserStore struct {
+	db *gorm.DB
+}
+
+func (s *GormUserStore) findOne(query string, value interface{}) (User, error) {
+	var user User
+	err := s.db.Where(query, value).First(&user).Error
+	return user, notFound(err)
+}
+
+// FindByID finds the user with the given id
+func (s *GormUserStore) FindByID(id uint) (User, error) {
+	return s.findOne("id = ?", id)
+}
+
+// FindByIDs loads the users with the given ids in a single query
+func (s *GormUserStore) FindByIDs(ids []uint) (map[uint]User, error) {
+	users := map[uint]User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	var found []User
+	if err := s.db.Where("id IN (?)", ids).Find(&found).Error; err != nil {
+		return users, err
+	}
+
+	for index := range found {
+		users[found[index].ID] = found[index]
+	}
+
+	return users, nil
+}
+
+// FindByUsername finds the user with the given username
+func (s *GormUserStore) FindByUsername(username string) (User, error) {
+	return s.findOne("username = ?", username)
+}
+
+// FindByURL finds the user with the given url
+func (s *GormUserStore) FindByURL(url string) (User, error) {
+	return s.findOne("url = ?", url)
+}
+
+// FindByEmail finds the user with the given email address
+func (s *GormUserStore) FindByEmail(email string) (User, error) {
+	return s.findOne("email = ?", email)
+}
+
+// Create saves a new user. The unique indexes reject taken usernames and urls.
+func (s *GormUserStore) Create(user *User) error {
+	return s.db.Create(user).Error
+}
+
+// UpdateUsername changes the user's username
+func (s *GormUserStore) UpdateUsername(user *User, username string) error {
+	if err := s.db.Model(user).Update("username", username).Error; err != nil {
+		return err
+	}
+
+	user.Username = username
+	return nil
+}
+
+// UpdatePassword replaces the user's password hash
+func (s *GormUserStore) UpdatePassword(user *User, passwordHash string) error {
+	if err := s.db.Model(user).Update("password_hash", passwordHash).Error; err != nil {
+		return err
+	}
+
+	user.PasswordHash = passwordHash
+	return nil
+}
+
+// UpdateEmail changes the user's email address and clears its verification. The unique index
+// rejects addresses used by someone else.
+func (s *GormUserStore) UpdateEmail(user *User, email string) error {
+	err := s.db.Model(user).Updates(map[string]interface{}{"email": email, "email_verified_at": nil}).Error
+	if err != nil {
+		return err
+	}
+
+	user.Email = &email
+	user.EmailVerifiedAt = nil
+	return nil
+}
+
+// MarkEmailVerified stores the time the user verified their email address
+func (s *GormUserStore) MarkEmailVerified(user *User) error {
+	now := time.Now()
+	if err := s.db.Model(user).Update("email_verified_at", now).Error; err != nil {
+		return err
+	}
+
+	user.EmailVerifiedAt = &now
+	return nil
+}
+
+// SetTOTPSecret saves the secret of a new enrollment and turns two-factor authentication off
+// until the enrollment is confirmed
+func (s *GormUserStore) SetTOTPSecret(user *User, secret string) error {
+	err := s.db.Model(user).Updates(map[string]interface{}{
+		"totp_secret":     secret,
+		"totp_enabled_at": nil,
+		"totp_last_step":  0,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	return nil
+}
+
+// EnableTOTP turns two-factor authentication on
+func (s *GormUserStore) EnableTOTP(user *User) error {
+	now := time.Now()
+	if err := s.db.Model(user).Update("totp_enabled_at", now).Error; err != nil {
+		return err
+	}
+
+	user.TOTPEnabledAt = &now
+	return nil
+}
+
+// UseTOTPStep records the step only if it's newer than the stored one, so that two requests
+// can't use the same code
+func (s *GormUserStore) UseTOTPStep(user *User, step int64) error {
+	result := s.db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		UpdateColumn("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrConflict
+	}
+
+	user.TOTPLastStep = step
+	return nil
+}
+
+// UpdateProfile replaces every profile field of the user. The fields are updated with a map,
+// so that clearing a field saves the empty value.
+func (s *GormUserStore) UpdateProfile(user *User, profile Profile) error {
+	err := s.db.Model(user).Updates(map[string]interface{}{
+		"display_name": profile.DisplayName,
+		"bio":          profile.Bio,
+		"location":     profile.Location,
+		"website":      profile.Website,
+		"links":        profile.Links,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	user.Profile = profile
+	return nil
+}
+
+// UpdateAvatar saves where the user's avatar is stored
+func (s *GormUserStore) UpdateAvatar(user *User, key, url string) error {
+	if err := s.db.Model(user).Updates(map[string]interface{}{"avatar_key": key, "avatar_url": url}).Error; err != nil {
+		return err
+	}
+
+	user.AvatarKey = key
+	user.AvatarURL = url
+	return nil
+}
+
+// BumpTokenVersion increments the user's token version in the database, so that concurrent
+// bumps aren't lost
+func (s *GormUserStore) BumpTokenVersion(user *User) error {
+	if err := s.db.Model(user).UpdateColumn("token_version", gorm.Expr("token_version + ?", 1)).Error; err != nil {
+		return err
+	}
+
+	var stored User
+	if err := s.db.Select("token_version").Where("id = ?", user.ID).First(&stored).Error; err != nil {
+		return notFound(err)
+	}
+
+	user.TokenVersion = stored.TokenVersion
+	return nil
+}
+
+// List returns a page of all users
+func (s *GormUserStore) List(page common.Page) ([]User, *common.Cursor, error) {
+	var users []User
+	if err := page.Apply(s.db, "users").Find(&users).Error; err != nil {
+		return nil, nil, err
+	}
+
+	users, next := models.PageUsers(users, page)
+	return users, next, nil
+}
+
+// UpdateRole changes the user's role
+func (s *GormUserStore) UpdateRole(user *User, role string) error {
+	if err := s.db.Model(user).Update("role", role).Error; err != nil {
+		return err
+	}
+
+	user.Role = role
+	return nil
+}
+
+// SetSuspended stores the time the user was suspended at, or clears it
+func (s *GormUserStore) SetSuspended(user *User, suspended bool) error {
+	var suspendedAt *time.Time
+	if suspended {
+		now := time.Now()
+		suspendedAt = &now
+	}
+
+	if err := s.db.Model(user).Update("suspended_at", suspendedAt).Error; err != nil {
+		return err
+	}
+
+	user.SuspendedAt = suspendedAt
+	return nil
+}
+
+// Delete removes the user, their posts, identities, recovery codes, personal tokens and
+// sessions in a single transaction. The user's row is deleted for good, so that the unique
+// indexes let someone else take the username, url and email address.
+func (s *GormUserStore) Delete(user *User) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&Post{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&Identity{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&PersonalToken{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(user).Error
+	})
+}
+
+// GormPostStore stores posts in a gorm database
+type GormPostStore struct {
+	db *gorm.DB
+}
+
+func (s *GormPostStore) findOne(query string, value interface{}) (Post, error) {
+	var post Post
+	err := s.db.Where(query, value).First(&post).Error
+	return post, notFound(err)
+}
+
+// FindByID finds the post with the given id
+func (s *GormPostStore) FindByID(id uint) (Post, error) {
+	return s.findOne("id = ?", id)
+}
+
+// FindByUUID finds the post with the given uuid
+func (s *GormPostStore) FindByUUID(uuid string) (Post, error) {
+	return s.findOne("uuid = ?", uuid)
+}
+
+// Create saves the post and its paragraphs in a single transaction
+func (s *GormPostStore) Create(post *Post, paragraphs []Paragraph) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(post).Error; err != nil {
+			return err
+		}
+
+		for index := range paragraphs {
+			paragraphs[index].PostID = post.ID
+			paragraphs[index].Position = index
+			if err := tx.Create(&paragraphs[index]).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Save updates the post's fields
+func (s *GormPostStore) Save(post *Post) error {
+	return s.db.Save(post).Error
+}
+
+// Delete removes the post
+func (s *GormPostStore) Delete(post *Post) error {
+	return s.db.Delete(post).Error
+}
+
+// orderedParagraphs queries the post's paragraphs in order. Paragraphs created before positions
+// were stored all have position zero, so the id keeps them in their original order.
+func orderedParagraphs(db *gorm.DB, postID uint) ([]Paragraph, error) {
+	var paragraphs []Paragraph
+	err := db.Where("post_id = ?", postID).Order("position asc").Order("id asc").Find(&paragraphs).Error
+	return paragraphs, err
+}
+
+// renumberParagraphs stores the index of each paragraph as its position
+func renumberParagraphs(tx *gorm.DB, paragraphs []Paragraph) error {
+	for index := range paragraphs {
+		if paragraphs[index].Position == index {
+			continue
+		}
+
+		if err := tx.Model(&paragraphs[index]).UpdateColumn("position", index).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaceParagraphs makes the paragraphs the post's content in the given order. The post's
+// paragraphs which weren't given are deleted for good.
+func replaceParagraphs(tx *gorm.DB, postID uint, paragraphs []Paragraph) error {
+	var existing []Paragraph
+	if err := tx.Where("post_id = ?", postID).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	current := make(map[string]Paragraph, len(existing))
+	for _, paragraph := range existing {
+		if paragraph.UUID != "" {
+			current[paragraph.UUID] = paragraph
+		}
+	}
+
+	kept := make(map[uint]bool, len(paragraphs))
+	for index := range paragraphs {
+		paragraphs[index].PostID = postID
+		paragraphs[index].Position = index
+
+		previous, ok := current[paragraphs[index].UUID]
+		if !ok {
+			paragraphs[index].Model = gorm.Model{}
+			paragraphs[index].UUID = common.CreateUUID()
+			if err := tx.Create(&paragraphs[index]).Error; err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// a uuid given twice only keeps the first paragraph
+		delete(current, previous.UUID)
+		kept[previous.ID] = true
+
+		paragraphs[index].Model = previous.Model
+		if err := tx.Model(&paragraphs[index]).Updates(map[string]interface{}{
+			"type":     paragraphs[index].Type,
+			"content":  paragraphs[index].Content,
+			"data":     paragraphs[index].Data,
+			"position": index,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	for index := range existing {
+		if kept[existing[index].ID] {
+			continue
+		}
+
+		if err := tx.Unscoped().Delete(&existing[index]).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Paragraphs returns the post's paragraphs in order
+func (s *GormPostStore) Paragraphs(post Post) ([]Paragraph, error) {
+	return orderedParagraphs(s.db, post.ID)
+}
+
+// FindParagraph finds the paragraph with the given uuid
+func (s *GormPostStore) FindParagraph(uuid string) (Paragraph, error) {
+	var paragraph Paragraph
+	err := s.db.Where("uuid = ?", uuid).First(&paragraph).Error
+	return paragraph, notFound(err)
+}
+
+// InsertParagraph creates the paragraph and renumbers the post's paragraphs in a single
+// transaction
+func (s *GormPostStore) InsertParagraph(post Post, paragraph *Paragraph, position int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		paragraphs, err := orderedParagraphs(tx, post.ID)
+		if err != nil {
+			return err
+		}
+
+		paragraph.PostID = post.ID
+		if err := tx.Create(paragraph).Error; err != nil {
+			return err
+		}
+
+		ordered, placed := models.PlaceParagraph(paragraphs, *paragraph, position)
+		if err := renumberParagraphs(tx, ordered); err != nil {
+			return err
+		}
+
+		paragraph.Position = placed
+		return nil
+	})
+}
+
+// SaveParagraph updates the paragraph's fields
+func (s *GormPostStore) SaveParagraph(paragraph *Paragraph) error {
+	return s.db.Save(paragraph).Error
+}
+
+// MoveParagraph renumbers the post's paragraphs in a single transaction
+func (s *GormPostStore) MoveParagraph(paragraph *Paragraph, position int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		paragraphs, err := orderedParagraphs(tx, paragraph.PostID)
+		if err != nil {
+			return err
+		}
+
+		ordered, placed := models.PlaceParagraph(paragraphs, *paragraph, position)
+		if err := renumberParagraphs(tx, ordered); err != nil {
+			return err
+		}
+
+		paragraph.Position = placed
+		return nil
+	})
+}
+
+// DeleteParagraph removes the paragraph and renumbers the rest in a single transaction
+func (s *GormPostStore) DeleteParagraph(paragraph Paragraph) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&paragraph).Error; err != nil {
+			return err
+		}
+
+		paragraphs, err := orderedParagraphs(tx, paragraph.PostID)
+		if err != nil {
+			return err
+		}
+
+		return renumberParagraphs(tx, paragraphs)
+	})
+}
+
+// ReplaceContent saves the post and replaces its paragraphs in a single transaction, so
+// that the editor can save the whole document at once
+func (s *GormPostStore) ReplaceContent(post *Post, paragraphs []Paragraph) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(post).Error; err != nil {
+			return err
+		}
+
+		return replaceParagraphs(tx, post.ID, paragraphs)
+	})
+}
+
+func (s *GormPostStore) page(query *gorm.DB, page common.Page) ([]Post, *common.Cursor, error) {
+	var posts []Post
+	if err := page.Apply(query, "posts").Find(&posts).Error; err != nil {
+		return nil, nil, err
+	}
+
+	posts, next := models.PagePosts(posts, page)
+	return posts, next, nil
+}
+
+// List returns a page of the published posts
+func (s *GormPostStore) List(page common.Page) ([]Post, *common.Cursor, error) {
+	return s.page(models.PublishedPosts(s.db), page)
+}
+
+// ListByUser returns a page of the user's posts
+func (s *GormPostStore) ListByUser(user User, page common.Page, onlyPublished bool) ([]Post, *common.Cursor, error) {
+	query := s.db.Where("user_id = ?", user.ID)
+	if onlyPublished {
+		query = models.PublishedPosts(query)
+	}
+
+	return s.page(query, page)
+}
+
+// ListByTopic returns a page of the published posts in the topic
+func (s *GormPostStore) ListByTopic(topic Topic, page common.Page) ([]Post, *common.Cursor, error) {
+	return s.page(models.PublishedPosts(s.db.Where("topic_id = ?", topic.ID)), page)
+}
+
+// Feed returns a page of the published posts by followed users or in followed topics. Posts
+// matching both are only returned once, since the post table is only queried once.
+func (s *GormPostStore) Feed(user User, page common.Page) ([]Post, *common.Cursor, error) {
+	followedUsers := s.db.Model(&models.Follow{}).Select("following_id").Where("followed_by_id = ?", user.ID).QueryExpr()
+	followedTopics := s.db.Model(&models.FollowedTopic{}).Select("topic_id").Where("user_id = ?", user.ID).QueryExpr()
+
+	return s.page(models.PublishedPosts(s.db.Where("user_id IN (?) OR topic_id IN (?)", followedUsers, followedTopics)), page)
+}
+
+// PublishDue publishes the scheduled posts whose publish time is before now
+func (s *GormPostStore) PublishDue(now time.Time) (int64, error) {
+	result := s.db.Model(&Post{}).
+		Where("status = ? AND publish_at <= ?", models.PostStatusScheduled, now).
+		UpdateColumn("status", models.PostStatusPublished)
+
+	return result.RowsAffected, result.Error
+}
+
+// commentCounts counts the comments which haven't been removed on each of the posts
+func commentCounts(db *gorm.DB, postIDs []uint) map[uint]int {
+	counts := map[uint]int{}
+	if len(postIDs) == 0 {
+		return counts
+	}
+
+	var rows []struct {
+		PostID uint
+		Count  int
+	}
+
+	db.Model(&models.Comment{}).
+		Select("post_id, COUNT(*) AS count").
+		Where("post_id IN (?) AND removed = ?", postIDs, false).
+		Group("post_id").
+		Scan(&rows)
+
+	for _, row := range rows {
+		counts[row.PostID] = row.Count
+	}
+
+	return counts
+}
+
+// likedPosts finds which of the posts the user has liked
+func likedPosts(db *gorm.DB, user User, postIDs []uint) map[uint]bool {
+	liked := map[uint]bool{}
+	if len(postIDs) == 0 {
+		return liked
+	}
+
+	var ids []uint
+	db.Model(&models.PostLike{}).Where("user_id = ? AND liked_post_id IN (?)", user.ID, postIDs).Pluck("liked_post_id", &ids)
+
+	for _, id := range ids {
+		liked[id] = true
+	}
+
+	return liked
+}
+
+// Serialize formats the posts with their authors, comment counts and, when there's a viewer,
+// whether the viewer has liked them. The authors, counts and likes of the whole page are
+// loaded with a query each.
+func (s *GormPostStore) Serialize(posts []Post, viewer *User) []common.JSON {
+	authorIDs := make([]uint, len(posts), len(posts))
+	postIDs := make([]uint, len(posts), len(posts))
+	for index := range posts {
+		authorIDs[index] = posts[index].UserID
+		postIDs[index] = posts[index].ID
+	}
+
+	authors, _ := (&GormUserStore{db: s.db}).FindByIDs(authorIDs)
+	comments := commentCounts(s.db, postIDs)
+
+	var liked map[uint]bool
+	if viewer != nil {
+		liked = likedPosts(s.db, *viewer, postIDs)
+	}
+
+	serialized := make([]common.JSON, len(posts), len(posts))
+	for index := range posts {
+		post := posts[index]
+
+		var author *User
+		if user, ok := authors[post.UserID]; ok {
+			author = &user
+		}
+
+		serialized[index] = models.PostJSON(post, author, comments[post.ID])
+		if viewer != nil {
+			serialized[index]["liked_by_me"] = liked[post.ID]
+		}
+	}
+
+	return serialized
+}
+
+// GormTopicStore stores topics in a gorm database
+type GormTopicStore struct {
+	db *gorm.DB
+}
+
+func (s *GormTopicStore) findOne(query string, value interface{}) (Topic, error) {
+	var topic Topic
+	err := s.db.Where(query, value).First(&topic).Error
+	return topic, notFound(err)
+}
+
+// FindByUUID finds the topic with the given uuid
+func (s *GormTopicStore) FindByUUID(uuid string) (Topic, error) {
+	return s.findOne("uuid = ?", uuid)
+}
+
+// FindByURL finds the topic with the given url
+func (s *GormTopicStore) FindByURL(url string) (Topic, error) {
+	return s.findOne("url = ?", url)
+}
+
+// FindByTitle finds the topic with the given title
+func (s *GormTopicStore) FindByTitle(title string) (Topic, error) {
+	return s.findOne("title = ?", title)
+}
+
+// Create saves a new topic. The unique index rejects taken urls.
+func (s *GormTopicStore) Create(topic *Topic) error {
+	return s.db.Create(topic).Error
+}
+
+// Save updates the topic's fields
+func (s *GormTopicStore) Save(topic *Topic) error {
+	return s.db.Save(topic).Error
+}
+
+// Delete removes the topic for good, so that its url can be used again
+func (s *GormTopicStore) Delete(topic *Topic) error {
+	return s.db.Unscoped().Delete(topic).Error
+}
+
+func (s *GormTopicStore) page(query *gorm.DB, page common.Page) ([]Topic, *common.Cursor, error) {
+	var topics []Topic
+	if err := page.Apply(query, "topics").Find(&topics).Error; err != nil {
+		return nil, nil, err
+	}
+
+	topics, next := models.PageTopics(topics, page)
+	return topics, next, nil
+}
+
+// List returns a page of all topics
+func (s *GormTopicStore) List(page common.Page) ([]Topic, *common.Cursor, error) {
+	return s.page(s.db, page)
+}
+
+// ListByUser returns a page of the topics created by the user
+func (s *GormTopicStore) ListByUser(user User, page common.Page) ([]Topic, *common.Cursor, error) {
+	return s.page(s.db.Where("user_id = ?", user.ID), page)
+}
+
+// GormFollowStore stores follows in a gorm database
+type GormFollowStore struct {
+	db *gorm.DB
+}
+
+// IsFollowing checks if the follower follows the followed user
+func (s *GormFollowStore) IsFollowing(follower, followed User) (bool, error) {
+	var count int
+	err := s.db.Model(&models.Follow{}).Where("followed_by_id = ? AND following_id = ?", follower.ID, followed.ID).Count(&count).Error
+	return count > 0, err
+}
+
+// Follow makes the follower follow the followed user
+func (s *GormFollowStore) Follow(follower, followed User) error {
+	return s.db.Create(&models.Follow{FollowedByID: follower.ID, FollowingID: followed.ID}).Error
+}
+
+// Unfollow removes the follow for good, so that it doesn't block following the user again
+func (s *GormFollowStore) Unfollow(follower, followed User) error {
+	result := s.db.Unscoped().Where("followed_by_id = ? AND following_id = ?", follower.ID, followed.ID).Delete(&models.Follow{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return result.Error
+}
+
+// IsFollowingTopic checks if the user follows the topic
+func (s *GormFollowStore) IsFollowingTopic(user User, topic Topic) (bool, error) {
+	var count int
+	err := s.db.Model(&models.FollowedTopic{}).Where("user_id = ? AND topic_id = ?", user.ID, topic.ID).Count(&count).Error
+	return count > 0, err
+}
+
+// FollowTopic makes the user follow the topic
+func (s *GormFollowStore) FollowTopic(user User, topic Topic) error {
+	return s.db.Create(&models.FollowedTopic{UserID: user.ID, TopicID: topic.ID}).Error
+}
+
+// UnfollowTopic removes the topic follow for good, like Unfollow
+func (s *GormFollowStore) UnfollowTopic(user User, topic Topic) error {
+	result := s.db.Unscoped().Where("user_id = ? AND topic_id = ?", user.ID, topic.ID).Delete(&models.FollowedTopic{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return result.Error
+}
+
+// FollowedUsers returns a page of the users the user follows, most recently followed first
+func (s *GormFollowStore) FollowedUsers(user User, page common.Page) ([]User, *common.Cursor, error) {
+	var follows []models.Follow
+	if err := page.Apply(s.db.Where("followed_by_id = ?", user.ID), "follows").Find(&follows).Error; err != nil {
+		return nil, nil, err
+	}
+
+	next := page.Next(len(follows), func(index int) (time.Time, uint) {
+		return follows[index].CreatedAt, follows[index].ID
+	})
+	follows = follows[:page.Visible(len(follows))]
+
+	ids := make([]uint, len(follows), len(follows))
+	for index := range follows {
+		ids[index] = follows[index].FollowingID
+	}
+
+	found, err := (&GormUserStore{db: s.db}).FindByIDs(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := make([]User, 0, len(follows))
+	for _, id := range ids {
+		if followed, ok := found[id]; ok {
+			users = append(users, followed)
+		}
+	}
+
+	return users, next, nil
+}
+
+// FollowedTopics returns a page of the topics the user follows, most recently followed first
+func (s *GormFollowStore) FollowedTopics(user User, page common.Page) ([]Topic, *common.Cursor, error) {
+	var followed []models.FollowedTopic
+	if err := page.Apply(s.db.Where("user_id = ?", user.ID), "followed_topics").Find(&followed).Error; err != nil {
+		return nil, nil, err
+	}
+
+	next := page.Next(len(followed), func(index int) (time.Time, uint) {
+		return followed[index].CreatedAt, followed[index].ID
+	})
+	followed = followed[:page.Visible(len(followed))]
+
+	ids := make([]uint, len(followed), len(followed))
+	for index := range followed {
+		ids[index] = followed[index].TopicID
+	}
+
+	var found []Topic
+	if len(ids) > 0 {
+		if err := s.db.Where("id IN (?)", ids).Find(&found).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	byID := make(map[uint]Topic, len(found))
+	for index := range found {
+		byID[found[index].ID] = found[index]
+	}
+
+	// the topics keep the order they were followed in, deleted topics are left out
+	topics := make([]Topic, 0, len(followed))
+	for index := range followed {
+		if topic, ok := byID[followed[index].TopicID]; ok {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics, next, nil
+}
+
+// GormLikeStore stores likes in a gorm database
+type GormLikeStore struct {
+	db *gorm.DB
+}
+
+// refreshLikeCount sets the post's likes to the amount of like rows
+func refreshLikeCount(tx *gorm.DB, post *Post) error {
+	var count int
+	if err := tx.Model(&PostLike{}).Where("liked_post_id = ?", post.ID).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(post).UpdateColumn("likes", count).Error; err != nil {
+		return err
+	}
+
+	post.Likes = count
+	return nil
+}
+
+// Like creates the like and refreshes the post's like count in a single transaction
+func (s *GormLikeStore) Like(user User, post *Post) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count int
+		if err := tx.Model(&PostLike{}).Where("user_id = ? AND liked_post_id = ?", user.ID, post.ID).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrConflict
+		}
+
+		if err := tx.Create(&PostLike{UserID: user.ID, LikedPostID: post.ID}).Error; err != nil {
+			if models.IsUniqueViolation(err) {
+				return ErrConflict
+			}
+
+			return err
+		}
+
+		return refreshLikeCount(tx, post)
+	})
+}
+
+// Unlike removes the like and refreshes the post's like count in a single transaction. The
+// like is deleted for good, so that it doesn't block liking the post again.
+func (s *GormLikeStore) Unlike(user User, post *Post) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("user_id = ? AND liked_post_id = ?", user.ID, post.ID).Delete(&PostLike{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return refreshLikeCount(tx, post)
+	})
+}
+
+// Likers returns a page of the users who have liked the post
+func (s *GormLikeStore) Likers(post Post, page common.Page) ([]User, *common.Cursor, error) {
+	var likes []PostLike
+	if err := page.Apply(s.db.Where("liked_post_id = ?", post.ID), "post_likes").Find(&likes).Error; err != nil {
+		return nil, nil, err
+	}
+
+	next := page.Next(len(likes), func(index int) (time.Time, uint) {
+		return likes[index].CreatedAt, likes[index].ID
+	})
+	likes = likes[:page.Visible(len(likes))]
+
+	ids := make([]uint, len(likes), len(likes))
+	for index := range likes {
+		ids[index] = likes[index].UserID
+	}
+
+	found, err := (&GormUserStore{db: s.db}).FindByIDs(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := make([]User, 0, len(likes))
+	for _, id := range ids {
+		if liker, ok := found[id]; ok {
+			users = append(users, liker)
+		}
+	}
+
+	return users, next, nil
+}
+
+// GormCommentStore stores comments in a gorm database
+type GormCommentStore struct {
+	db *gorm.DB
+}
+
+// FindByUUID finds the comment with the given uuid
+func (s *GormCommentStore) FindByUUID(uuid string) (Comment, error) {
+	var comment Comment
+	err := s.db.Where("uuid = ?", uuid).First(&comment).Error
+	return comment, notFound(err)
+}
+
+// Create saves a new comment
+func (s *GormCommentStore) Create(comment *Comment) error {
+	return s.db.Create(comment).Error
+}
+
+// Save updates the comment's fields
+func (s *GormCommentStore) Save(comment *Comment) error {
+	return s.db.Save(comment).Error
+}
+
+// Remove clears the comment's content and marks it removed
+func (s *GormCommentStore) Remove(comment *Comment) error {
+	comment.Content = ""
+	comment.Removed = true
+	return s.db.Save(comment).Error
+}
+
+// Tree returns a page of the post's top-level comments with their replies. The replies are
+// loaded one level at a time and the authors in a single query.
+func (s *GormCommentStore) Tree(post Post, page common.Page) ([]common.JSON, *common.Cursor, error) {
+	var roots []Comment
+	query := s.db.Where("post_id = ? AND parent_id = ?", post.ID, 0)
+	if err := page.Apply(query, "comments").Find(&roots).Error; err != nil {
+		return nil, nil, err
+	}
+
+	next := page.Next(len(roots), func(index int) (time.Time, uint) {
+		return roots[index].CreatedAt, roots[index].ID
+	})
+	roots = roots[:page.Visible(len(roots))]
+
+	replies := make(map[uint][]Comment)
+	userIDs := make([]uint, 0, len(roots))
+	parentIDs := make([]uint, len(roots), len(roots))
+	for index := range roots {
+		parentIDs[index] = roots[index].ID
+		userIDs = append(userIDs, roots[index].UserID)
+	}
+
+	for len(parentIDs) > 0 {
+		var level []Comment
+		if err := s.db.Where("parent_id IN (?)", parentIDs).Order("created_at asc").Order("id asc").Find(&level).Error; err != nil {
+			return nil, nil, err
+		}
+
+		parentIDs = make([]uint, len(level), len(level))
+		for index := range level {
+			replies[level[index].ParentID] = append(replies[level[index].ParentID], level[index])
+			parentIDs[index] = level[index].ID
+			userIDs = append(userIDs, level[index].UserID)
+		}
+	}
+
+	authors, err := (&GormUserStore{db: s.db}).FindByIDs(userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return models.CommentTreeJSON(roots, replies, authors), next, nil
+}
+
+// Serialize formats the comment with its author
+func (s *GormCommentStore) Serialize(comment Comment) common.JSON {
+	var author *User
+	if user, err := (&GormUserStore{db: s.db}).FindByID(comment.UserID); err == nil {
+		author = &user
+	}
+
+	return models.CommentJSON(comment, author)
+}
+
+// GormRevisionStore stores revisions in a gorm database
+type GormRevisionStore struct {
+	db *gorm.DB
+}
+
+// snapshot stores the post's current content as the revision after its latest one
+func snapshot(tx *gorm.DB, post Post, user User) (PostRevision, error) {
+	paragraphs, err := orderedParagraphs(tx, post.ID)
+	if err != nil {
+		return PostRevision{}, err
+	}
+
+	var latest PostRevision
+	number := 1
+	if err := tx.Where("post_id = ?", post.ID).Order("number desc").First(&latest).Error; err == nil {
+		number = latest.Number + 1
+	}
+
+	revision, err := models.NewRevision(post, user, number, paragraphs)
+	if err != nil {
+		return revision, err
+	}
+
+	err = tx.Create(&revision).Error
+	return revision, err
+}
+
+// Snapshot stores the post's content in a single transaction, so that the paragraphs and the
+// revision number are read together
+func (s *GormRevisionStore) Snapshot(post Post, user User) (PostRevision, error) {
+	var revision PostRevision
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		revision, err = snapshot(tx, post, user)
+		return err
+	})
+
+	return revision, err
+}
+
+// Ensure snapshots the post as its author if it doesn't have any revisions
+func (s *GormRevisionStore) Ensure(post Post) error {
+	var count int
+	if err := s.db.Model(&PostRevision{}).Where("post_id = ?", post.ID).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	_, err := s.Snapshot(post, User{Model: gorm.Model{ID: post.UserID}})
+	return err
+}
+
+// List returns a page of the post's revisions
+func (s *GormRevisionStore) List(post Post, page common.Page) ([]PostRevision, *common.Cursor, error) {
+	var revisions []PostRevision
+	if err := page.Apply(s.db.Where("post_id = ?", post.ID), "post_revisions").Find(&revisions).Error; err != nil {
+		return nil, nil, err
+	}
+
+	next := page.Next(len(revisions), func(index int) (time.Time, uint) {
+		return revisions[index].CreatedAt, revisions[index].ID
+	})
+
+	return revisions[:page.Visible(len(revisions))], next, nil
+}
+
+// Find finds the post's revision with the number
+func (s *GormRevisionStore) Find(post Post, number int) (PostRevision, error) {
+	var revision PostRevision
+	err := s.db.Where("post_id = ? AND number = ?", post.ID, number).First(&revision).Error
+	return revision, notFound(err)
+}
+
+// Restore replaces the post's content and snapshots it in a single transaction
+func (s *GormRevisionStore) Restore(post *Post, revision PostRevision, user User) (PostRevision, error) {
+	paragraphs, err := revision.RestoredParagraphs()
+	if err != nil {
+		return PostRevision{}, err
+	}
+
+	post.Title = revision.Title
+	post.Description = revision.Description
+	post.Text = revision.Text
+
+	var restored PostRevision
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := replaceParagraphs(tx, post.ID, paragraphs); err != nil {
+			return err
+		}
+
+		if err := tx.Save(post).Error; err != nil {
+			return err
+		}
+
+		var err error
+		restored, err = snapshot(tx, *post, user)
+		return err
+	})
+
+	return restored, err
+}
+
+// GormRefreshTokenStore stores refresh tokens in a gorm database
+type GormRefreshTokenStore struct {
+	db *gorm.DB
+}
+
+// Create saves a new refresh token
+func (s *GormRefreshTokenStore) Create(token *RefreshToken) error {
+	return s.db.Create(token).Error
+}
+
+// FindByHash finds the refresh token with the given hash, including revoked ones
+func (s *GormRefreshTokenStore) FindByHash(hash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := s.db.Where("token_hash = ?", hash).First(&token).Error
+	return token, notFound(err)
+}
+
+// revokeTokens marks the matching active tokens revoked and returns how many there were
+func revokeTokens(db *gorm.DB, query string, value interface{}) (int64, error) {
+	result := db.Model(&RefreshToken{}).
+		Where(query+" AND revoked_at IS NULL", value).
+		UpdateColumn("revoked_at", time.Now())
+
+	return result.RowsAffected, result.Error
+}
+
+// Rotate revokes the old token and saves the replacement in a single transaction. The old
+// token is only revoked if it's still active, so two requests can't both rotate it.
+func (s *GormRefreshTokenStore) Rotate(old *RefreshToken, replacement *RefreshToken) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		revoked, err := revokeTokens(tx, "id = ?", old.ID)
+		if err != nil {
+			return err
+		}
+
+		if revoked == 0 {
+			return ErrConflict
+		}
+
+		return tx.Create(replacement).Error
+	})
+}
+
+// Revoke revokes the refresh token
+func (s *GormRefreshTokenStore) Revoke(token *RefreshToken) error {
+	_, err := revokeTokens(s.db, "id = ?", token.ID)
+	return err
+}
+
+// RevokeAll revokes every active refresh token of the user
+func (s *GormRefreshTokenStore) RevokeAll(user User) error {
+	_, err := revokeTokens(s.db, "user_id = ?", user.ID)
+	return err
+}
+
+// GormIdentityStore stores identities in a gorm database
+type GormIdentityStore struct {
+	db *gorm.DB
+}
+
+// Find finds the identity with the provider's subject
+func (s *GormIdentityStore) Find(provider, subject string) (Identity, error) {
+	var identity Identity
+	err := s.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	return identity, notFound(err)
+}
+
+// createIdentity saves the identity unless it has been linked already. The unique index
+// catches identities linked by a concurrent request.
+func createIdentity(db *gorm.DB, identity *Identity) error {
+	var count int
+	if err := db.Model(&Identity{}).Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrConflict
+	}
+
+	if err := db.Create(identity).Error; err != nil {
+		if models.IsUniqueViolation(err) {
+			return ErrConflict
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Create links the identity
+func (s *GormIdentityStore) Create(identity *Identity) error {
+	return createIdentity(s.db, identity)
+}
+
+// CreateWithUser creates the user and the identity in a single transaction. The ids gorm
+// set are cleared when it's rolled back, so that the user can be created again.
+func (s *GormIdentityStore) CreateWithUser(user *User, identity *Identity) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+
+		identity.UserID = user.ID
+		return createIdentity(tx, identity)
+	})
+
+	if err != nil {
+		user.Model = gorm.Model{}
+		identity.Model = gorm.Model{}
+	}
+
+	return err
+}
+
+// ListByUser returns the user's identities, oldest first
+func (s *GormIdentityStore) ListByUser(user User) ([]Identity, error) {
+	var identities []Identity
+	err := s.db.Where("user_id = ?", user.ID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+// Delete unlinks the identity. The row is removed for good, so that the identity can be
+// linked again.
+func (s *GormIdentityStore) Delete(identity *Identity) error {
+	return s.db.Unscoped().Delete(identity).Error
+}
+
+// GormRecoveryCodeStore stores recovery codes in a gorm database
+type GormRecoveryCodeStore struct {
+	db *gorm.DB
+}
+
+// Replace swaps the user's codes for the new ones in a single transaction
+func (s *GormRecoveryCodeStore) Replace(user User, hashes []string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			if err := tx.Create(&RecoveryCode{UserID: user.ID, CodeHash: hash}).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Use marks the code used. The update only matches unused codes, so a code can't be used by
+// two requests.
+func (s *GormRecoveryCodeStore) Use(user User, hash string) error {
+	result := s.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hash).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// CountUnused counts the user's codes which haven't been used
+func (s *GormRecoveryCodeStore) CountUnused(user User) (int, error) {
+	var count int
+	err := s.db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&count).Error
+	return count, err
+}
+
+// GormPersonalTokenStore stores personal access tokens in a gorm database
+type GormPersonalTokenStore struct {
+	db *gorm.DB
+}
+
+// Create saves a new token
+func (s *GormPersonalTokenStore) Create(token *PersonalToken) error {
+	return s.db.Create(token).Error
+}
+
+// FindByHash finds the token with the hash
+func (s *GormPersonalTokenStore) FindByHash(hash string) (PersonalToken, error) {
+	var token PersonalToken
+	err := s.db.Where("token_hash = ?", hash).First(&token).Error
+	return token, notFound(err)
+}
+
+// FindForUser finds the user's token with the id
+func (s *GormPersonalTokenStore) FindForUser(user User, id uint) (PersonalToken, error) {
+	var token PersonalToken
+	err := s.db.Where("id = ? AND user_id = ?", id, user.ID).First(&token).Error
+	return token, notFound(err)
+}
+
+// ListByUser returns the user's tokens, oldest first
+func (s *GormPersonalTokenStore) ListByUser(user User) ([]PersonalToken, error) {
+	var tokens []PersonalToken
+	err := s.db.Where("user_id = ?", user.ID).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+// Delete revokes the token by removing it for good
+func (s *GormPersonalTokenStore) Delete(token *PersonalToken) error {
+	return s.db.Unscoped().Delete(token).Error
+}
+
+// DeleteAll revokes every token of the user
+func (s *GormPersonalTokenStore) DeleteAll(user User) error {
+	return s.db.Unscoped().Where("user_id = ?", user.ID).Delete(&PersonalToken{}).Error
+}
+
+// Touch records when the token was last used without changing its updated_at
+func (s *GormPersonalTokenStore) Touch(token *PersonalToken, at time.Time) error {
+	if err := s.db.Model(token).UpdateColumn("last_used_at", at).Error; err != nil {
+		return err
+	}
+
+	token.LastUsedAt = &at
+	return nil
+}
+
+// GormSecurityEventStore stores the security log in a gorm database
+type GormSecurityEventStore struct {
+	db *gorm.DB
+}
+
+// Create saves the event
+func (s *GormSecurityEventStore) Create(event *SecurityEvent) error {
+	return s.db.Create(event).Error
+}
+
+// GormSessionStore stores sessions in a gorm database
+type GormSessionStore struct {
+	db *gorm.DB
+}
+
+// Create saves a new session
+func (s *GormSessionStore) Create(session *Session) error {
+	return s.db.Create(session).Error
+}
+
+// FindByID finds the session with the id, including revoked ones
+func (s *GormSessionStore) FindByID(id uint) (Session, error) {
+	var session Session
+	err := s.db.Where("id = ?", id).First(&session).Error
+	return session, notFound(err)
+}
+
+// ListActive returns the user's active sessions, the most recently seen first
+func (s *GormSessionStore) ListActive(user User, now time.Time) ([]Session, error) {
+	var sessions []Session
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, now).
+		Order("last_seen_at DESC, id DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Touch records when the session was last seen
+func (s *GormSessionStore) Touch(session *Session, at time.Time) error {
+	if err := s.db.Model(session).UpdateColumn("last_seen_at", at).Error; err != nil {
+		return err
+	}
+
+	session.LastSeenAt = at
+	return nil
+}
+
+// Extend moves the session's expiry and records it as seen
+func (s *GormSessionStore) Extend(session *Session, at time.Time, expiresAt time.Time) error {
+	err := s.db.Model(session).UpdateColumns(map[string]interface{}{
+		"last_seen_at": at,
+		"expires_at":   expiresAt,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	session.LastSeenAt = at
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// revokeSessions ends the matching active sessions and revokes their refresh tokens
+func revokeSessions(db *gorm.DB, query string, values ...interface{}) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		err := tx.Model(&Session{}).Where(query+" AND revoked_at IS NULL", values...).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		if err := tx.Model(&Session{}).Where("id IN (?)", ids).UpdateColumn("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		_, err = revokeTokens(tx, "session_id IN (?)", ids)
+		return err
+	})
+}
+
+// Revoke ends the session and revokes its refresh tokens in a single transaction
+func (s *GormSessionStore) Revoke(session *Session) error {
+	return revokeSessions(s.db, "id = ?", session.ID)
+}
+
+// RevokeAll ends the user's sessions except one in a single transaction
+func (s *GormSessionStore) RevokeAll(user User, except uint) error {
+	return revokeSessions(s.db, "user_id = ? AND id <> ?", user.ID, except)
+}
@@ -0,0 +1,1902 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/search"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// errUserTaken is returned when a user is created together with another record, where
+// ErrConflict is about the other record
+var errUserTaken = errors.New("The username, url or email address is taken")
+
+// memory holds the records shared by the in-memory stores. Records are copied in and out,
+// so callers can't change the stored data without going through a store.
+type memory struct {
+	mutex          sync.RWMutex
+	nextID         uint
+	users          map[uint]User
+	posts          map[uint]Post
+	paragraphs     map[uint][]Paragraph
+	topics         map[uint]Topic
+	follows        map[[2]uint]time.Time
+	followedTopics map[[2]uint]time.Time
+	likes          map[uint]PostLike
+	comments       map[uint]Comment
+	revisions      map[uint]PostRevision
+	tokens         map[uint]RefreshToken
+	identities     map[uint]Identity
+	recoveryCodes  map[uint]RecoveryCode
+	personalTokens map[uint]PersonalToken
+	securityEvents map[uint]SecurityEvent
+	sessions       map[uint]Session
+}
+
+// NewMemoryStores creates stores which keep everything in memory. They're meant for tests and
+// local development, so nothing is persisted.
+func NewMemoryStores() Stores {
+	data := &memory{
+		users:          map[uint]User{},
+		posts:          map[uint]Post{},
+		paragraphs:     map[uint][]Paragraph{},
+		topics:         map[uint]Topic{},
+		follows:        map[[2]uint]time.Time{},
+		followedTopics: map[[2]uint]time.Time{},
+		likes:          map[uint]PostLike{},
+		comments:       map[uint]Comment{},
+		revisions:      map[uint]PostRevision{},
+		tokens:         map[uint]RefreshToken{},
+		identities:     map[uint]Identity{},
+		recoveryCodes:  map[uint]RecoveryCode{},
+		personalTokens: map[uint]PersonalToken{},
+		securityEvents: map[uint]SecurityEvent{},
+		sessions:       map[uint]Session{},
+	}
+
+	return Stores{
+		Users:          &MemoryUserStore{data: data},
+		Posts:          &MemoryPostStore{data: data},
+		Topics:         &MemoryTopicStore{data: data},
+		Follows:        &MemoryFollowStore{data: data},
+		Likes:          &MemoryLikeStore{data: data},
+		Comments:       &MemoryCommentStore{data: data},
+		Revisions:      &MemoryRevisionStore{data: data},
+		Tokens:         &MemoryRefreshTokenStore{data: data},
+		Identities:     &MemoryIdentityStore{data: data},
+		RecoveryCodes:  &MemoryRecoveryCodeStore{data: data},
+		PersonalTokens: &MemoryPersonalTokenStore{data: data},
+		SecurityEvents: &MemorySecurityEventStore{data: data},
+		Sessions:       &MemorySessionStore{data: data},
+		Search:         &MemorySearchEngine{data: data},
+	}
+}
+
+// create sets the id and timestamps like gorm does on insert. The caller holds the lock.
+func (data *memory) create(model *gorm.Model) {
+	data.nextID++
+	now := time.Now()
+
+	model.ID = data.nextID
+	model.CreatedAt = now
+	model.UpdatedAt = now
+}
+
+// after checks if the row comes after the page's cursor in the newest first order
+func after(page common.Page, createdAt time.Time, id uint) bool {
+	if page.After == nil {
+		return true
+	}
+
+	return createdAt.Before(page.After.CreatedAt) || (createdAt.Equal(page.After.CreatedAt) && id < page.After.ID)
+}
+
+// newestFirst sorts rows like common.Page.Apply orders queries
+func newestFirst(createdAt func(index int) time.Time, id func(index int) uint) func(i, j int) bool {
+	return func(i, j int) bool {
+		if !createdAt(i).Equal(createdAt(j)) {
+			return createdAt(i).After(createdAt(j))
+		}
+
+		return id(i) > id(j)
+	}
+}
+
+// MemoryUserStore stores users in memory
+type MemoryUserStore struct {
+	data *memory
+}
+
+func (s *MemoryUserStore) find(match func(user User) bool) (User, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, user := range s.data.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+
+	return User{}, ErrNotFound
+}
+
+// FindByID finds the user with the given id
+func (s *MemoryUserStore) FindByID(id uint) (User, error) {
+	return s.find(func(user User) bool { return user.ID == id })
+}
+
+// FindByIDs returns the users with the given ids
+func (s *MemoryUserStore) FindByIDs(ids []uint) (map[uint]User, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	users := map[uint]User{}
+	for _, id := range ids {
+		if user, ok := s.data.users[id]; ok {
+			users[id] = user
+		}
+	}
+
+	return users, nil
+}
+
+// FindByUsername finds the user with the given username
+func (s *MemoryUserStore) FindByUsername(username string) (User, error) {
+	return s.find(func(user User) bool { return user.Username == username })
+}
+
+// FindByURL finds the user with the given url
+func (s *MemoryUserStore) FindByURL(url string) (User, error) {
+	return s.find(func(user User) bool { return user.URL == url })
+}
+
+// FindByEmail finds the user with the given email address
+func (s *MemoryUserStore) FindByEmail(email string) (User, error) {
+	return s.find(func(user User) bool { return user.Email != nil && *user.Email == email })
+}
+
+// Create saves a new user, unless the username, url or email address is taken
+func (s *MemoryUserStore) Create(user *User) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if s.data.taken(*user) {
+		return ErrConflict
+	}
+
+	s.data.create(&user.Model)
+	s.data.users[user.ID] = *user
+	return nil
+}
+
+// taken checks if someone has the user's username, url or email address like the unique
+// indexes would. The caller holds the lock.
+func (data *memory) taken(user User) bool {
+	for _, existing := range data.users {
+		if existing.Username == user.Username || existing.URL == user.URL {
+			return true
+		}
+
+		if existing.Email != nil && user.Email != nil && *existing.Email == *user.Email {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpdateUsername changes the user's username, unless someone else has it
+func (s *MemoryUserStore) UpdateUsername(user *User, username string) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	stored, ok := s.data.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	for _, existing := range s.data.users {
+		if existing.ID != user.ID && existing.Username == username {
+			return ErrConflict
+		}
+	}
+
+	stored.Username = username
+	stored.UpdatedAt = time.Now()
+	s.data.users[user.ID] = stored
+	user.Username = username
+	return nil
+}
+
+// UpdatePassword replaces the user's password hash
+func (s *MemoryUserStore) UpdatePassword(user *User, passwordHash string) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	stored, ok := s.data.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	stored.PasswordHash = passwordHash
+	stored.UpdatedAt = time.Now()
+	s.data.users[user.ID] = stored
+	user.PasswordHash = passwordHash
+	return nil
+}
+
+// UpdateEmail changes the user's email address and clears its verification, unless someone
+// else uses the address
+func (s *MemoryUserStore) UpdateEmail(user *User, email string) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	stored, ok := s.data.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	for _, existing := range s.data.users {
+		if existing.ID != user.ID && existing.Email != nil && *existing.Email == email {
+			return ErrConflict
+		}
+	}
+
+	stored.Email = &email
+	stored.EmailVerifiedAt = nil
+	stored.UpdatedAt = time.Now()
+	s.data.users[user.ID] = stored
+	user.Email = &email
+	user.EmailVerifiedAt = nil
+	return nil
+}
+
+// MarkEmailVerified stores the time the user verified their email address
+func (s *MemoryUserStore) MarkEmailVerified(user *User) error {
+	now := time.Now()
+	return s.update(user, func(user *User) { user.EmailVerifiedAt = &now })
+}
+
+// SetTOTPSecret saves the secret of a new enrollment and turns two-factor authentication off
+// until the enrollment is confirmed
+func (s *MemoryUserStore) SetTOTPSecret(user *User, secret string) error {
+	return s.update(user, func(user *User) {
+		user.TOTPSecret = secret
+		user.TOTPEnabledAt = nil
+		user.TOTPLastStep = 0
+	})
+}
+
+// EnableTOTP turns two-factor authentication on
+func (s *MemoryUserStore) EnableTOTP(user *User) error {
+	now := time.Now()
+	return s.update(user, func(user *User) { user.TOTPEnabledAt = &now })
+}
+
+// UseTOTPStep records the step if it's newer than the stored one
+func (s *MemoryUserStore) UseTOTPStep(user *User, step int64) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	stored, ok := s.data.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if stored.TOTPLastStep >= step {
+		return ErrConflict
+	}
+
+	stored.TOTPLastStep = step
+	s.data.users[user.ID] = stored
+	user.TOTPLastStep = step
+	return nil
+}
+
+// UpdateProfile replaces every profile field of the user
+func (s *MemoryUserStore) UpdateProfile(user *User, profile Profile) error {
+	return s.update(user, func(user *User) { user.Profile = profile })
+}
+
+// UpdateAvatar saves where the user's avatar is stored
+func (s *MemoryUserStore) UpdateAvatar(user *User, key, url string) error {
+	return s.update(user, func(user *User) {
+		user.AvatarKey = key
+		user.AvatarURL = url
+	})
+}
+
+// BumpTokenVersion increments the user's token version
+func (s *MemoryUserStore) BumpTokenVersion(user *User) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	stored, ok := s.data.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	stored.TokenVersion++
+	s.data.users[user.ID] = stored
+	user.TokenVersion = stored.TokenVersion
+	return nil
+}
+
+// List returns a page of all users
+func (s *MemoryUserStore) List(page common.Page) ([]User, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	var users []User
+	for _, user := range s.data.users {
+		if after(page, user.CreatedAt, user.ID) {
+			users = append(users, user)
+		}
+	}
+
+	sort.Slice(users, newestFirst(
+		func(index int) time.Time { return users[index].CreatedAt },
+		func(index int) uint { return users[index].ID },
+	))
+
+	if len(users) > page.Limit+1 {
+		users = users[:page.Limit+1]
+	}
+
+	users, next := models.PageUsers(users, page)
+	return users, next, nil
+}
+
+// update applies the change to the stored user and the given copy
+func (s *MemoryUserStore) update(user *User, change func(user *User)) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	stored, ok := s.data.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	change(&stored)
+	change(user)
+	stored.UpdatedAt = time.Now()
+	s.data.users[user.ID] = stored
+	return nil
+}
+
+// UpdateRole changes the user's role
+func (s *MemoryUserStore) UpdateRole(user *User, role string) error {
+	return s.update(user, func(user *User) { user.Role = role })
+}
+
+// SetSuspended stores the time the user was suspended at, or clears it
+func (s *MemoryUserStore) SetSuspended(user *User, suspended bool) error {
+	var suspendedAt *time.Time
+	if suspended {
+		now := time.Now()
+		suspendedAt = &now
+	}
+
+	return s.update(user, func(user *User) { user.SuspendedAt = suspendedAt })
+}
+
+// Delete removes the user, their posts, identities, recovery codes, personal tokens and
+// sessions
+func (s *MemoryUserStore) Delete(user *User) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.users[user.ID]; !ok {
+		return ErrNotFound
+	}
+
+	for id, post := range s.data.posts {
+		if post.UserID == user.ID {
+			delete(s.data.posts, id)
+			delete(s.data.paragraphs, id)
+		}
+	}
+
+	for id, identity := range s.data.identities {
+		if identity.UserID == user.ID {
+			delete(s.data.identities, id)
+		}
+	}
+
+	for id, code := range s.data.recoveryCodes {
+		if code.UserID == user.ID {
+			delete(s.data.recoveryCodes, id)
+		}
+	}
+
+	for id, token := range s.data.personalTokens {
+		if token.UserID == user.ID {
+			delete(s.data.personalTokens, id)
+		}
+	}
+
+	for id, session := range s.data.sessions {
+		if session.UserID == user.ID {
+			delete(s.data.sessions, id)
+		}
+	}
+
+	delete(s.data.users, user.ID)
+	return nil
+}
+
+// MemoryPostStore stores posts in memory
+type MemoryPostStore struct {
+	data *memory
+}
+
+// published checks the post's status, which gorm would default to published
+func published(post Post) bool {
+	return post.Status == "" || post.Status == models.PostStatusPublished
+}
+
+// FindByID finds the post with the given id
+func (s *MemoryPostStore) FindByID(id uint) (Post, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	post, ok := s.data.posts[id]
+	if !ok {
+		return post, ErrNotFound
+	}
+
+	return post, nil
+}
+
+// FindByUUID finds the post with the given uuid
+func (s *MemoryPostStore) FindByUUID(uuid string) (Post, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, post := range s.data.posts {
+		if post.UUID == uuid {
+			return post, nil
+		}
+	}
+
+	return Post{}, ErrNotFound
+}
+
+// Create saves the post and its paragraphs, unless the uuid is taken
+func (s *MemoryPostStore) Create(post *Post, paragraphs []Paragraph) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for _, existing := range s.data.posts {
+		if existing.UUID == post.UUID {
+			return ErrConflict
+		}
+	}
+
+	if post.Status == "" {
+		post.Status = models.PostStatusPublished
+	}
+
+	s.data.create(&post.Model)
+	for index := range paragraphs {
+		s.data.create(&paragraphs[index].Model)
+		paragraphs[index].PostID = post.ID
+		paragraphs[index].Position = index
+	}
+
+	s.data.posts[post.ID] = *post
+	s.data.paragraphs[post.ID] = append([]Paragraph(nil), paragraphs...)
+	return nil
+}
+
+// Save updates the post's fields
+func (s *MemoryPostStore) Save(post *Post) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.posts[post.ID]; !ok {
+		return ErrNotFound
+	}
+
+	post.UpdatedAt = time.Now()
+	s.data.posts[post.ID] = *post
+	return nil
+}
+
+// Delete removes the post and its paragraphs
+func (s *MemoryPostStore) Delete(post *Post) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.posts[post.ID]; !ok {
+		return ErrNotFound
+	}
+
+	delete(s.data.posts, post.ID)
+	delete(s.data.paragraphs, post.ID)
+	return nil
+}
+
+// Paragraphs returns the post's paragraphs in order
+func (s *MemoryPostStore) Paragraphs(post Post) ([]Paragraph, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	return append([]Paragraph(nil), s.data.paragraphs[post.ID]...), nil
+}
+
+// FindParagraph finds the paragraph with the given uuid
+func (s *MemoryPostStore) FindParagraph(uuid string) (Paragraph, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, paragraphs := range s.data.paragraphs {
+		for _, paragraph := range paragraphs {
+			if paragraph.UUID == uuid {
+				return paragraph, nil
+			}
+		}
+	}
+
+	return Paragraph{}, ErrNotFound
+}
+
+// renumber stores the paragraphs as the post's content in the given order. The caller holds
+// the lock.
+func (s *MemoryPostStore) renumber(postID uint, paragraphs []Paragraph) {
+	for index := range paragraphs {
+		paragraphs[index].Position = index
+	}
+
+	s.data.paragraphs[postID] = paragraphs
+}
+
+// InsertParagraph adds the paragraph to the post at the position
+func (s *MemoryPostStore) InsertParagraph(post Post, paragraph *Paragraph, position int) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.posts[post.ID]; !ok {
+		return ErrNotFound
+	}
+
+	s.data.create(&paragraph.Model)
+	paragraph.PostID = post.ID
+
+	ordered, placed := models.PlaceParagraph(s.data.paragraphs[post.ID], *paragraph, position)
+	s.renumber(post.ID, ordered)
+	paragraph.Position = placed
+	return nil
+}
+
+// SaveParagraph updates the paragraph's fields
+func (s *MemoryPostStore) SaveParagraph(paragraph *Paragraph) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	paragraphs := s.data.paragraphs[paragraph.PostID]
+	for index := range paragraphs {
+		if paragraphs[index].ID == paragraph.ID {
+			paragraph.UpdatedAt = time.Now()
+			paragraphs[index] = *paragraph
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+// MoveParagraph moves the paragraph to a new position in its post
+func (s *MemoryPostStore) MoveParagraph(paragraph *Paragraph, position int) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	ordered, placed := models.PlaceParagraph(s.data.paragraphs[paragraph.PostID], *paragraph, position)
+	s.renumber(paragraph.PostID, ordered)
+	paragraph.Position = placed
+	return nil
+}
+
+// DeleteParagraph removes the paragraph and renumbers the rest
+func (s *MemoryPostStore) DeleteParagraph(paragraph Paragraph) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	paragraphs := s.data.paragraphs[paragraph.PostID]
+	remaining := make([]Paragraph, 0, len(paragraphs))
+	for index := range paragraphs {
+		if paragraphs[index].ID != paragraph.ID {
+			remaining = append(remaining, paragraphs[index])
+		}
+	}
+
+	if len(remaining) == len(paragraphs) {
+		return ErrNotFound
+	}
+
+	s.renumber(paragraph.PostID, remaining)
+	return nil
+}
+
+// ReplaceContent saves the post and replaces its paragraphs
+func (s *MemoryPostStore) ReplaceContent(post *Post, paragraphs []Paragraph) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.posts[post.ID]; !ok {
+		return ErrNotFound
+	}
+
+	post.UpdatedAt = time.Now()
+	s.data.posts[post.ID] = *post
+	s.replace(post.ID, paragraphs)
+	return nil
+}
+
+// replace makes the paragraphs the post's content like replaceParagraphs does in the gorm
+// store. The caller holds the lock.
+func (s *MemoryPostStore) replace(postID uint, paragraphs []Paragraph) {
+	current := map[string]Paragraph{}
+	for _, paragraph := range s.data.paragraphs[postID] {
+		if paragraph.UUID != "" {
+			current[paragraph.UUID] = paragraph
+		}
+	}
+
+	for index := range paragraphs {
+		paragraphs[index].PostID = postID
+
+		previous, ok := current[paragraphs[index].UUID]
+		if !ok {
+			paragraphs[index].Model = gorm.Model{}
+			paragraphs[index].UUID = common.CreateUUID()
+			s.data.create(&paragraphs[index].Model)
+			continue
+		}
+
+		// a uuid given twice only keeps the first paragraph
+		delete(current, previous.UUID)
+		paragraphs[index].Model = previous.Model
+		paragraphs[index].UpdatedAt = time.Now()
+	}
+
+	s.renumber(postID, append([]Paragraph(nil), paragraphs...))
+	for index := range paragraphs {
+		paragraphs[index].Position = index
+	}
+}
+
+// page returns a page of the posts matching the filter
+func (s *MemoryPostStore) page(page common.Page, match func(post Post) bool) ([]Post, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	var posts []Post
+	for _, post := range s.data.posts {
+		if match(post) && after(page, post.CreatedAt, post.ID) {
+			posts = append(posts, post)
+		}
+	}
+
+	sort.Slice(posts, newestFirst(
+		func(index int) time.Time { return posts[index].CreatedAt },
+		func(index int) uint { return posts[index].ID },
+	))
+
+	if len(posts) > page.Limit+1 {
+		posts = posts[:page.Limit+1]
+	}
+
+	posts, next := models.PagePosts(posts, page)
+	return posts, next, nil
+}
+
+// List returns a page of the published posts
+func (s *MemoryPostStore) List(page common.Page) ([]Post, *common.Cursor, error) {
+	return s.page(page, published)
+}
+
+// ListByUser returns a page of the user's posts
+func (s *MemoryPostStore) ListByUser(user User, page common.Page, onlyPublished bool) ([]Post, *common.Cursor, error) {
+	return s.page(page, func(post Post) bool {
+		return post.UserID == user.ID && (!onlyPublished || published(post))
+	})
+}
+
+// ListByTopic returns a page of the published posts in the topic
+func (s *MemoryPostStore) ListByTopic(topic Topic, page common.Page) ([]Post, *common.Cursor, error) {
+	return s.page(page, func(post Post) bool {
+		return post.TopicID == topic.ID && published(post)
+	})
+}
+
+// Feed returns a page of the published posts by followed users or in followed topics
+func (s *MemoryPostStore) Feed(user User, page common.Page) ([]Post, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	users := map[uint]bool{}
+	topics := map[uint]bool{}
+	for pair := range s.data.follows {
+		if pair[0] == user.ID {
+			users[pair[1]] = true
+		}
+	}
+
+	for pair := range s.data.followedTopics {
+		if pair[0] == user.ID {
+			topics[pair[1]] = true
+		}
+	}
+	s.data.mutex.RUnlock()
+
+	return s.page(page, func(post Post) bool {
+		return (users[post.UserID] || topics[post.TopicID]) && published(post)
+	})
+}
+
+// PublishDue publishes the scheduled posts whose publish time is before now
+func (s *MemoryPostStore) PublishDue(now time.Time) (int64, error) {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	var count int64
+	for id, post := range s.data.posts {
+		if post.Status != models.PostStatusScheduled || post.PublishAt == nil || post.PublishAt.After(now) {
+			continue
+		}
+
+		post.Status = models.PostStatusPublished
+		s.data.posts[id] = post
+		count++
+	}
+
+	return count, nil
+}
+
+// Serialize formats the posts with their authors, comment counts and, when there's a viewer,
+// whether the viewer has liked them
+func (s *MemoryPostStore) Serialize(posts []Post, viewer *User) []common.JSON {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	serialized := make([]common.JSON, len(posts), len(posts))
+	for index := range posts {
+		var author *User
+		if user, ok := s.data.users[posts[index].UserID]; ok {
+			author = &user
+		}
+
+		comments := 0
+		for _, comment := range s.data.comments {
+			if comment.PostID == posts[index].ID && !comment.Removed {
+				comments++
+			}
+		}
+
+		serialized[index] = models.PostJSON(posts[index], author, comments)
+		if viewer != nil {
+			liked := false
+			for _, like := range s.data.likes {
+				if like.UserID == viewer.ID && like.LikedPostID == posts[index].ID {
+					liked = true
+				}
+			}
+
+			serialized[index]["liked_by_me"] = liked
+		}
+	}
+
+	return serialized
+}
+
+// MemoryTopicStore stores topics in memory
+type MemoryTopicStore struct {
+	data *memory
+}
+
+func (s *MemoryTopicStore) find(match func(topic Topic) bool) (Topic, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, topic := range s.data.topics {
+		if match(topic) {
+			return topic, nil
+		}
+	}
+
+	return Topic{}, ErrNotFound
+}
+
+// FindByUUID finds the topic with the given uuid
+func (s *MemoryTopicStore) FindByUUID(uuid string) (Topic, error) {
+	return s.find(func(topic Topic) bool { return topic.UUID == uuid })
+}
+
+// FindByURL finds the topic with the given url
+func (s *MemoryTopicStore) FindByURL(url string) (Topic, error) {
+	return s.find(func(topic Topic) bool { return topic.URL == url })
+}
+
+// FindByTitle finds the topic with the given title
+func (s *MemoryTopicStore) FindByTitle(title string) (Topic, error) {
+	return s.find(func(topic Topic) bool { return topic.Title == title })
+}
+
+// Create saves a new topic, unless the url is taken
+func (s *MemoryTopicStore) Create(topic *Topic) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for _, existing := range s.data.topics {
+		if existing.URL == topic.URL {
+			return ErrConflict
+		}
+	}
+
+	s.data.create(&topic.Model)
+	s.data.topics[topic.ID] = *topic
+	return nil
+}
+
+// Save updates the topic's fields, unless another topic has the url
+func (s *MemoryTopicStore) Save(topic *Topic) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.topics[topic.ID]; !ok {
+		return ErrNotFound
+	}
+
+	for _, existing := range s.data.topics {
+		if existing.ID != topic.ID && existing.URL == topic.URL {
+			return ErrConflict
+		}
+	}
+
+	topic.UpdatedAt = time.Now()
+	s.data.topics[topic.ID] = *topic
+	return nil
+}
+
+// Delete removes the topic
+func (s *MemoryTopicStore) Delete(topic *Topic) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.topics[topic.ID]; !ok {
+		return ErrNotFound
+	}
+
+	delete(s.data.topics, topic.ID)
+	return nil
+}
+
+// page returns a page of the topics matching the filter
+func (s *MemoryTopicStore) page(page common.Page, match func(topic Topic) bool) ([]Topic, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	var topics []Topic
+	for _, topic := range s.data.topics {
+		if match(topic) && after(page, topic.CreatedAt, topic.ID) {
+			topics = append(topics, topic)
+		}
+	}
+
+	sort.Slice(topics, newestFirst(
+		func(index int) time.Time { return topics[index].CreatedAt },
+		func(index int) uint { return topics[index].ID },
+	))
+
+	if len(topics) > page.Limit+1 {
+		topics = topics[:page.Limit+1]
+	}
+
+	topics, next := models.PageTopics(topics, page)
+	return topics, next, nil
+}
+
+// List returns a page of all topics
+func (s *MemoryTopicStore) List(page common.Page) ([]Topic, *common.Cursor, error) {
+	return s.page(page, func(topic Topic) bool { return true })
+}
+
+// ListByUser returns a page of the topics created by the user
+func (s *MemoryTopicStore) ListByUser(user User, page common.Page) ([]Topic, *common.Cursor, error) {
+	return s.page(page, func(topic Topic) bool { return topic.UserID == user.ID })
+}
+
+// MemoryFollowStore stores follows in memory. The maps are keyed by the follower's id and the
+// followed user's or topic's id.
+type MemoryFollowStore struct {
+	data *memory
+}
+
+func (s *MemoryFollowStore) exists(follows map[[2]uint]time.Time, key [2]uint) bool {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	_, ok := follows[key]
+	return ok
+}
+
+func (s *MemoryFollowStore) add(follows map[[2]uint]time.Time, key [2]uint) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := follows[key]; ok {
+		return ErrConflict
+	}
+
+	follows[key] = time.Now()
+	return nil
+}
+
+func (s *MemoryFollowStore) remove(follows map[[2]uint]time.Time, key [2]uint) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := follows[key]; !ok {
+		return ErrNotFound
+	}
+
+	delete(follows, key)
+	return nil
+}
+
+// followed returns a page of the ids the user follows in the map, most recently followed first
+func (s *MemoryFollowStore) followed(follows map[[2]uint]time.Time, user User, page common.Page) ([]uint, *common.Cursor) {
+	var ids []uint
+	var times []time.Time
+	for pair, followedAt := range follows {
+		if pair[0] == user.ID && after(page, followedAt, pair[1]) {
+			ids = append(ids, pair[1])
+			times = append(times, followedAt)
+		}
+	}
+
+	order := make([]int, len(ids), len(ids))
+	for index := range order {
+		order[index] = index
+	}
+
+	sort.Slice(order, newestFirst(
+		func(index int) time.Time { return times[order[index]] },
+		func(index int) uint { return ids[order[index]] },
+	))
+
+	sorted := make([]uint, len(order), len(order))
+	for index := range order {
+		sorted[index] = ids[order[index]]
+	}
+
+	next := page.Next(len(sorted), func(index int) (time.Time, uint) {
+		return times[order[index]], sorted[index]
+	})
+
+	return sorted[:page.Visible(len(sorted))], next
+}
+
+// IsFollowing checks if the follower follows the followed user
+func (s *MemoryFollowStore) IsFollowing(follower, followed User) (bool, error) {
+	return s.exists(s.data.follows, [2]uint{follower.ID, followed.ID}), nil
+}
+
+// Follow makes the follower follow the followed user
+func (s *MemoryFollowStore) Follow(follower, followed User) error {
+	return s.add(s.data.follows, [2]uint{follower.ID, followed.ID})
+}
+
+// Unfollow removes the follow
+func (s *MemoryFollowStore) Unfollow(follower, followed User) error {
+	return s.remove(s.data.follows, [2]uint{follower.ID, followed.ID})
+}
+
+// IsFollowingTopic checks if the user follows the topic
+func (s *MemoryFollowStore) IsFollowingTopic(user User, topic Topic) (bool, error) {
+	return s.exists(s.data.followedTopics, [2]uint{user.ID, topic.ID}), nil
+}
+
+// FollowTopic makes the user follow the topic
+func (s *MemoryFollowStore) FollowTopic(user User, topic Topic) error {
+	return s.add(s.data.followedTopics, [2]uint{user.ID, topic.ID})
+}
+
+// UnfollowTopic removes the topic follow
+func (s *MemoryFollowStore) UnfollowTopic(user User, topic Topic) error {
+	return s.remove(s.data.followedTopics, [2]uint{user.ID, topic.ID})
+}
+
+// FollowedUsers returns a page of the users the user follows
+func (s *MemoryFollowStore) FollowedUsers(user User, page common.Page) ([]User, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	ids, next := s.followed(s.data.follows, user, page)
+	users := make([]User, 0, len(ids))
+	for _, id := range ids {
+		if followed, ok := s.data.users[id]; ok {
+			users = append(users, followed)
+		}
+	}
+
+	return users, next, nil
+}
+
+// FollowedTopics returns a page of the topics the user follows
+func (s *MemoryFollowStore) FollowedTopics(user User, page common.Page) ([]Topic, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	ids, next := s.followed(s.data.followedTopics, user, page)
+	topics := make([]Topic, 0, len(ids))
+	for _, id := range ids {
+		if topic, ok := s.data.topics[id]; ok {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics, next, nil
+}
+
+// MemoryLikeStore stores likes in memory
+type MemoryLikeStore struct {
+	data *memory
+}
+
+// refreshLikeCount sets the post's likes to the amount of likes it has. The caller holds
+// the lock.
+func (s *MemoryLikeStore) refreshLikeCount(post *Post) {
+	count := 0
+	for _, like := range s.data.likes {
+		if like.LikedPostID == post.ID {
+			count++
+		}
+	}
+
+	if stored, ok := s.data.posts[post.ID]; ok {
+		stored.Likes = count
+		s.data.posts[post.ID] = stored
+	}
+
+	post.Likes = count
+}
+
+// Like adds the user's like and refreshes the post's like count
+func (s *MemoryLikeStore) Like(user User, post *Post) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for _, like := range s.data.likes {
+		if like.UserID == user.ID && like.LikedPostID == post.ID {
+			return ErrConflict
+		}
+	}
+
+	like := PostLike{UserID: user.ID, LikedPostID: post.ID}
+	s.data.create(&like.Model)
+	s.data.likes[like.ID] = like
+	s.refreshLikeCount(post)
+	return nil
+}
+
+// Unlike removes the user's like and refreshes the post's like count
+func (s *MemoryLikeStore) Unlike(user User, post *Post) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for id, like := range s.data.likes {
+		if like.UserID == user.ID && like.LikedPostID == post.ID {
+			delete(s.data.likes, id)
+			s.refreshLikeCount(post)
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+// Likers returns a page of the users who have liked the post
+func (s *MemoryLikeStore) Likers(post Post, page common.Page) ([]User, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	var likes []PostLike
+	for _, like := range s.data.likes {
+		if like.LikedPostID == post.ID && after(page, like.CreatedAt, like.ID) {
+			likes = append(likes, like)
+		}
+	}
+
+	sort.Slice(likes, newestFirst(
+		func(index int) time.Time { return likes[index].CreatedAt },
+		func(index int) uint { return likes[index].ID },
+	))
+
+	next := page.Next(len(likes), func(index int) (time.Time, uint) {
+		return likes[index].CreatedAt, likes[index].ID
+	})
+	likes = likes[:page.Visible(len(likes))]
+
+	users := make([]User, 0, len(likes))
+	for _, like := range likes {
+		if user, ok := s.data.users[like.UserID]; ok {
+			users = append(users, user)
+		}
+	}
+
+	return users, next, nil
+}
+
+// MemoryCommentStore stores comments in memory
+type MemoryCommentStore struct {
+	data *memory
+}
+
+// FindByUUID finds the comment with the given uuid
+func (s *MemoryCommentStore) FindByUUID(uuid string) (Comment, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, comment := range s.data.comments {
+		if comment.UUID == uuid {
+			return comment, nil
+		}
+	}
+
+	return Comment{}, ErrNotFound
+}
+
+// Create saves a new comment
+func (s *MemoryCommentStore) Create(comment *Comment) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	s.data.create(&comment.Model)
+	s.data.comments[comment.ID] = *comment
+	return nil
+}
+
+// Save updates the comment's fields
+func (s *MemoryCommentStore) Save(comment *Comment) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.comments[comment.ID]; !ok {
+		return ErrNotFound
+	}
+
+	comment.UpdatedAt = time.Now()
+	s.data.comments[comment.ID] = *comment
+	return nil
+}
+
+// Remove clears the comment's content and marks it removed
+func (s *MemoryCommentStore) Remove(comment *Comment) error {
+	comment.Content = ""
+	comment.Removed = true
+	return s.Save(comment)
+}
+
+// Tree returns a page of the post's top-level comments with their replies
+func (s *MemoryCommentStore) Tree(post Post, page common.Page) ([]common.JSON, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	var roots []Comment
+	var replies []Comment
+	for _, comment := range s.data.comments {
+		if comment.PostID != post.ID {
+			continue
+		}
+
+		if comment.ParentID != 0 {
+			replies = append(replies, comment)
+		} else if after(page, comment.CreatedAt, comment.ID) {
+			roots = append(roots, comment)
+		}
+	}
+
+	sort.Slice(roots, newestFirst(
+		func(index int) time.Time { return roots[index].CreatedAt },
+		func(index int) uint { return roots[index].ID },
+	))
+
+	next := page.Next(len(roots), func(index int) (time.Time, uint) {
+		return roots[index].CreatedAt, roots[index].ID
+	})
+	roots = roots[:page.Visible(len(roots))]
+
+	// replies are shown oldest first, and the ids grow in the order they were created
+	sort.Slice(replies, func(i, j int) bool { return replies[i].ID < replies[j].ID })
+	children := make(map[uint][]Comment)
+	for _, reply := range replies {
+		children[reply.ParentID] = append(children[reply.ParentID], reply)
+	}
+
+	return models.CommentTreeJSON(roots, children, s.data.users), next, nil
+}
+
+// Serialize formats the comment with its author
+func (s *MemoryCommentStore) Serialize(comment Comment) common.JSON {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	var author *User
+	if user, ok := s.data.users[comment.UserID]; ok {
+		author = &user
+	}
+
+	return models.CommentJSON(comment, author)
+}
+
+// MemoryRevisionStore stores revisions in memory
+type MemoryRevisionStore struct {
+	data *memory
+}
+
+// snapshot stores the post's current content as its next revision. The caller holds the lock.
+func (s *MemoryRevisionStore) snapshot(post Post, user User) (PostRevision, error) {
+	number := 1
+	for _, revision := range s.data.revisions {
+		if revision.PostID == post.ID && revision.Number >= number {
+			number = revision.Number + 1
+		}
+	}
+
+	revision, err := models.NewRevision(post, user, number, s.data.paragraphs[post.ID])
+	if err != nil {
+		return revision, err
+	}
+
+	s.data.create(&revision.Model)
+	s.data.revisions[revision.ID] = revision
+	return revision, nil
+}
+
+// Snapshot stores the post's content as its next revision
+func (s *MemoryRevisionStore) Snapshot(post Post, user User) (PostRevision, error) {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	return s.snapshot(post, user)
+}
+
+// Ensure snapshots the post as its author if it doesn't have any revisions
+func (s *MemoryRevisionStore) Ensure(post Post) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for _, revision := range s.data.revisions {
+		if revision.PostID == post.ID {
+			return nil
+		}
+	}
+
+	_, err := s.snapshot(post, User{Model: gorm.Model{ID: post.UserID}})
+	return err
+}
+
+// List returns a page of the post's revisions
+func (s *MemoryRevisionStore) List(post Post, page common.Page) ([]PostRevision, *common.Cursor, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	var revisions []PostRevision
+	for _, revision := range s.data.revisions {
+		if revision.PostID == post.ID && after(page, revision.CreatedAt, revision.ID) {
+			revisions = append(revisions, revision)
+		}
+	}
+
+	sort.Slice(revisions, newestFirst(
+		func(index int) time.Time { return revisions[index].CreatedAt },
+		func(index int) uint { return revisions[index].ID },
+	))
+
+	next := page.Next(len(revisions), func(index int) (time.Time, uint) {
+		return revisions[index].CreatedAt, revisions[index].ID
+	})
+
+	return revisions[:page.Visible(len(revisions))], next, nil
+}
+
+// Find finds the post's revision with the number
+func (s *MemoryRevisionStore) Find(post Post, number int) (PostRevision, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, revision := range s.data.revisions {
+		if revision.PostID == post.ID && revision.Number == number {
+			return revision, nil
+		}
+	}
+
+	return PostRevision{}, ErrNotFound
+}
+
+// Restore replaces the post's content and snapshots it
+func (s *MemoryRevisionStore) Restore(post *Post, revision PostRevision, user User) (PostRevision, error) {
+	paragraphs, err := revision.RestoredParagraphs()
+	if err != nil {
+		return PostRevision{}, err
+	}
+
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.posts[post.ID]; !ok {
+		return PostRevision{}, ErrNotFound
+	}
+
+	post.Title = revision.Title
+	post.Description = revision.Description
+	post.Text = revision.Text
+	post.UpdatedAt = time.Now()
+	s.data.posts[post.ID] = *post
+
+	(&MemoryPostStore{data: s.data}).replace(post.ID, paragraphs)
+	return s.snapshot(*post, user)
+}
+
+// MemorySearchEngine searches the posts kept in memory. Like the LIKE engine it needs every
+// term in the title, description or a paragraph and ranks by the terms in the title and
+// description, but it doesn't make snippets.
+type MemorySearchEngine struct {
+	data *memory
+}
+
+// Index does nothing since the posts are searched directly
+func (engine *MemorySearchEngine) Index(post Post) error {
+	return nil
+}
+
+// Remove does nothing since the posts are searched directly
+func (engine *MemorySearchEngine) Remove(post Post) error {
+	return nil
+}
+
+// Search returns the published posts with every term of the query
+func (engine *MemorySearchEngine) Search(query search.Query) ([]search.Result, error) {
+	words := strings.Fields(strings.ToLower(query.Text))
+	if len(words) == 0 {
+		return []search.Result{}, nil
+	}
+
+	engine.data.mutex.RLock()
+	defer engine.data.mutex.RUnlock()
+
+	results := []search.Result{}
+	for _, post := range engine.data.posts {
+		if !published(post) || (query.TopicID != 0 && post.TopicID != query.TopicID) || (query.UserID != 0 && post.UserID != query.UserID) {
+			continue
+		}
+
+		heading := strings.ToLower(post.Title + "\n" + post.Description)
+		content := heading
+		for _, paragraph := range engine.data.paragraphs[post.ID] {
+			content += "\n" + strings.ToLower(paragraph.Content)
+		}
+
+		rank, found := 0, true
+		for _, word := range words {
+			found = found && strings.Contains(content, word)
+			if strings.Contains(heading, word) {
+				rank++
+			}
+		}
+
+		if found {
+			results = append(results, search.Result{Post: post, Rank: float64(rank)})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+
+		return results[i].Post.ID > results[j].Post.ID
+	})
+
+	if query.Offset >= len(results) {
+		return []search.Result{}, nil
+	}
+
+	results = results[query.Offset:]
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+
+	return results, nil
+}
+
+// MemoryRefreshTokenStore stores refresh tokens in memory
+type MemoryRefreshTokenStore struct {
+	data *memory
+}
+
+// Create saves a new refresh token, unless the hash is taken
+func (s *MemoryRefreshTokenStore) Create(token *RefreshToken) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	return s.create(token)
+}
+
+// create saves the token. The caller holds the lock.
+func (s *MemoryRefreshTokenStore) create(token *RefreshToken) error {
+	for _, existing := range s.data.tokens {
+		if existing.TokenHash == token.TokenHash {
+			return ErrConflict
+		}
+	}
+
+	s.data.create(&token.Model)
+	s.data.tokens[token.ID] = *token
+	return nil
+}
+
+// FindByHash finds the refresh token with the given hash, including revoked ones
+func (s *MemoryRefreshTokenStore) FindByHash(hash string) (RefreshToken, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, token := range s.data.tokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+
+	return RefreshToken{}, ErrNotFound
+}
+
+// revoke marks the token revoked if it's still active. The caller holds the lock.
+func (s *MemoryRefreshTokenStore) revoke(id uint) bool {
+	token, ok := s.data.tokens[id]
+	if !ok || token.RevokedAt != nil {
+		return false
+	}
+
+	now := time.Now()
+	token.RevokedAt = &now
+	s.data.tokens[id] = token
+	return true
+}
+
+// Rotate revokes the old token and saves the replacement, unless the old token has already
+// been revoked
+func (s *MemoryRefreshTokenStore) Rotate(old *RefreshToken, replacement *RefreshToken) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if !s.revoke(old.ID) {
+		return ErrConflict
+	}
+
+	return s.create(replacement)
+}
+
+// Revoke revokes the refresh token
+func (s *MemoryRefreshTokenStore) Revoke(token *RefreshToken) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	s.revoke(token.ID)
+	return nil
+}
+
+// RevokeAll revokes every active refresh token of the user
+func (s *MemoryRefreshTokenStore) RevokeAll(user User) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for id, token := range s.data.tokens {
+		if token.UserID == user.ID {
+			s.revoke(id)
+		}
+	}
+
+	return nil
+}
+
+// MemoryIdentityStore stores identities in memory
+type MemoryIdentityStore struct {
+	data *memory
+}
+
+// Find finds the identity with the provider's subject
+func (s *MemoryIdentityStore) Find(provider, subject string) (Identity, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, identity := range s.data.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+
+	return Identity{}, ErrNotFound
+}
+
+// Create links the identity, unless it's linked already
+func (s *MemoryIdentityStore) Create(identity *Identity) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if s.linked(*identity) {
+		return ErrConflict
+	}
+
+	s.data.create(&identity.Model)
+	s.data.identities[identity.ID] = *identity
+	return nil
+}
+
+// linked checks if the identity has been linked to someone. The caller holds the lock.
+func (s *MemoryIdentityStore) linked(identity Identity) bool {
+	for _, existing := range s.data.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateWithUser creates the user and the identity, unless either of them is taken
+func (s *MemoryIdentityStore) CreateWithUser(user *User, identity *Identity) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if s.linked(*identity) {
+		return ErrConflict
+	}
+
+	if s.data.taken(*user) {
+		return errUserTaken
+	}
+
+	s.data.create(&user.Model)
+	s.data.users[user.ID] = *user
+
+	identity.UserID = user.ID
+	s.data.create(&identity.Model)
+	s.data.identities[identity.ID] = *identity
+	return nil
+}
+
+// ListByUser returns the user's identities, oldest first
+func (s *MemoryIdentityStore) ListByUser(user User) ([]Identity, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	identities := []Identity{}
+	for _, identity := range s.data.identities {
+		if identity.UserID == user.ID {
+			identities = append(identities, identity)
+		}
+	}
+
+	sort.Slice(identities, func(i, j int) bool { return identities[i].ID < identities[j].ID })
+	return identities, nil
+}
+
+// Delete unlinks the identity
+func (s *MemoryIdentityStore) Delete(identity *Identity) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.identities[identity.ID]; !ok {
+		return ErrNotFound
+	}
+
+	delete(s.data.identities, identity.ID)
+	return nil
+}
+
+// MemoryRecoveryCodeStore stores recovery codes in memory
+type MemoryRecoveryCodeStore struct {
+	data *memory
+}
+
+// Replace swaps the user's codes for the new ones
+func (s *MemoryRecoveryCodeStore) Replace(user User, hashes []string) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for id, code := range s.data.recoveryCodes {
+		if code.UserID == user.ID {
+			delete(s.data.recoveryCodes, id)
+		}
+	}
+
+	for _, hash := range hashes {
+		code := RecoveryCode{UserID: user.ID, CodeHash: hash}
+		s.data.create(&code.Model)
+		s.data.recoveryCodes[code.ID] = code
+	}
+
+	return nil
+}
+
+// Use marks the unused code with the hash used
+func (s *MemoryRecoveryCodeStore) Use(user User, hash string) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for id, code := range s.data.recoveryCodes {
+		if code.UserID == user.ID && code.CodeHash == hash && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+			s.data.recoveryCodes[id] = code
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+// CountUnused counts the user's codes which haven't been used
+func (s *MemoryRecoveryCodeStore) CountUnused(user User) (int, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	count := 0
+	for _, code := range s.data.recoveryCodes {
+		if code.UserID == user.ID && code.UsedAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MemoryPersonalTokenStore stores personal access tokens in memory
+type MemoryPersonalTokenStore struct {
+	data *memory
+}
+
+// Create saves a new token
+func (s *MemoryPersonalTokenStore) Create(token *PersonalToken) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for _, existing := range s.data.personalTokens {
+		if existing.TokenHash == token.TokenHash {
+			return ErrConflict
+		}
+	}
+
+	s.data.create(&token.Model)
+	s.data.personalTokens[token.ID] = *token
+	return nil
+}
+
+// FindByHash finds the token with the hash
+func (s *MemoryPersonalTokenStore) FindByHash(hash string) (PersonalToken, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	for _, token := range s.data.personalTokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+
+	return PersonalToken{}, ErrNotFound
+}
+
+// FindForUser finds the user's token with the id
+func (s *MemoryPersonalTokenStore) FindForUser(user User, id uint) (PersonalToken, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	token, ok := s.data.personalTokens[id]
+	if !ok || token.UserID != user.ID {
+		return PersonalToken{}, ErrNotFound
+	}
+
+	return token, nil
+}
+
+// ListByUser returns the user's tokens, oldest first
+func (s *MemoryPersonalTokenStore) ListByUser(user User) ([]PersonalToken, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	tokens := []PersonalToken{}
+	for _, token := range s.data.personalTokens {
+		if token.UserID == user.ID {
+			tokens = append(tokens, token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+// Delete revokes the token
+func (s *MemoryPersonalTokenStore) Delete(token *PersonalToken) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	if _, ok := s.data.personalTokens[token.ID]; !ok {
+		return ErrNotFound
+	}
+
+	delete(s.data.personalTokens, token.ID)
+	return nil
+}
+
+// DeleteAll revokes every token of the user
+func (s *MemoryPersonalTokenStore) DeleteAll(user User) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for id, token := range s.data.personalTokens {
+		if token.UserID == user.ID {
+			delete(s.data.personalTokens, id)
+		}
+	}
+
+	return nil
+}
+
+// Touch records when the token was last used
+func (s *MemoryPersonalTokenStore) Touch(token *PersonalToken, at time.Time) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	stored, ok := s.data.personalTokens[token.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	stored.LastUsedAt = &at
+	s.data.personalTokens[token.ID] = stored
+	token.LastUsedAt = &at
+	return nil
+}
+
+// MemorySecurityEventStore stores the security log in memory
+type MemorySecurityEventStore struct {
+	data *memory
+}
+
+// Create saves the event
+func (s *MemorySecurityEventStore) Create(event *SecurityEvent) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	s.data.create(&event.Model)
+	s.data.securityEvents[event.ID] = *event
+	return nil
+}
+
+// MemorySessionStore stores sessions in memory
+type MemorySessionStore struct {
+	data *memory
+}
+
+// Create saves a new session
+func (s *MemorySessionStore) Create(session *Session) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	s.data.create(&session.Model)
+	s.data.sessions[session.ID] = *session
+	return nil
+}
+
+// FindByID finds the session with the id, including revoked ones
+func (s *MemorySessionStore) FindByID(id uint) (Session, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	session, ok := s.data.sessions[id]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+
+	return session, nil
+}
+
+// ListActive returns the user's active sessions, the most recently seen first
+func (s *MemorySessionStore) ListActive(user User, now time.Time) ([]Session, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+
+	sessions := []Session{}
+	for _, session := range s.data.sessions {
+		if session.UserID == user.ID && session.Active(now) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+// update changes the stored session and the given copy
+func (s *MemorySessionStore) update(session *Session, change func(session *Session)) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	stored, ok := s.data.sessions[session.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	change(&stored)
+	s.data.sessions[session.ID] = stored
+	change(session)
+	return nil
+}
+
+// Touch records when the session was last seen
+func (s *MemorySessionStore) Touch(session *Session, at time.Time) error {
+	return s.update(session, func(session *Session) { session.LastSeenAt = at })
+}
+
+// Extend moves the session's expiry and records it as seen
+func (s *MemorySessionStore) Extend(session *Session, at time.Time, expiresAt time.Time) error {
+	return s.update(session, func(session *Session) {
+		session.LastSeenAt = at
+		session.ExpiresAt = expiresAt
+	})
+}
+
+// revoke ends the session if it's still active and revokes its refresh tokens. The caller
+// holds the lock.
+func (s *MemorySessionStore) revoke(id uint) {
+	session, ok := s.data.sessions[id]
+	if !ok || session.RevokedAt != nil {
+		return
+	}
+
+	now := time.Now()
+	session.RevokedAt = &now
+	s.data.sessions[id] = session
+
+	for tokenID, token := range s.data.tokens {
+		if token.SessionID == id && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			s.data.tokens[tokenID] = token
+		}
+	}
+}
+
+// Revoke ends the session and revokes its refresh tokens
+func (s *MemorySessionStore) Revoke(session *Session) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	s.revoke(session.ID)
+	return nil
+}
+
+// RevokeAll ends the user's sessions except one
+func (s *MemorySessionStore) RevokeAll(user User, except uint) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+
+	for id, session := range s.data.sessions {
+		if session.UserID == user.ID && id != except {
+			s.revoke(id)
+		}
+	}
+
+	return nil
+}
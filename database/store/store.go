@@ -0,0 +1,304 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/search"
+	"github.com/nireo/go-blog-api/lib/common"
+)
+
+// ErrNotFound is returned when the looked up record doesn't exist
+var ErrNotFound = errors.New("Record not found")
+
+// ErrConflict is returned when a record would break a uniqueness constraint
+var ErrConflict = errors.New("Record already exists")
+
+// User model alias
+type User = models.User
+
+// Post model alias
+type Post = models.Post
+
+// Paragraph model alias
+type Paragraph = models.Paragraph
+
+// Topic model alias
+type Topic = models.Topic
+
+// Comment model alias
+type Comment = models.Comment
+
+// PostLike model alias
+type PostLike = models.PostLike
+
+// PostRevision model alias
+type PostRevision = models.PostRevision
+
+// RefreshToken model alias
+type RefreshToken = models.RefreshToken
+
+// Identity model alias
+type Identity = models.Identity
+
+// RecoveryCode model alias
+type RecoveryCode = models.RecoveryCode
+
+// PersonalToken model alias
+type PersonalToken = models.PersonalToken
+
+// SecurityEvent model alias
+type SecurityEvent = models.SecurityEvent
+
+// Session model alias
+type Session = models.Session
+
+// Profile model alias
+type Profile = models.Profile
+
+// UserStore finds and saves users
+type UserStore interface {
+	FindByID(id uint) (User, error)
+	// FindByIDs loads the users with the given ids, keyed by their id. Missing users are left out.
+	FindByIDs(ids []uint) (map[uint]User, error)
+	FindByUsername(username string) (User, error)
+	FindByURL(url string) (User, error)
+	FindByEmail(email string) (User, error)
+	Create(user *User) error
+	UpdateUsername(user *User, username string) error
+	UpdatePassword(user *User, passwordHash string) error
+	// UpdateEmail changes the user's email address, which has to be verified again
+	UpdateEmail(user *User, email string) error
+	MarkEmailVerified(user *User) error
+	// SetTOTPSecret starts a new two-factor enrollment, an empty secret turns two-factor
+	// authentication off
+	SetTOTPSecret(user *User, secret string) error
+	EnableTOTP(user *User) error
+	// UseTOTPStep records the time step of an accepted code. ErrConflict is returned when the
+	// step isn't newer than the last one, which means the code has been used already.
+	UseTOTPStep(user *User, step int64) error
+	// UpdateProfile replaces every profile field of the user
+	UpdateProfile(user *User, profile Profile) error
+	// UpdateAvatar saves where the user's avatar is stored, empty ones remove it
+	UpdateAvatar(user *User, key, url string) error
+	// BumpTokenVersion revokes every access token given out to the user
+	BumpTokenVersion(user *User) error
+	// List returns a page of all users, newest first
+	List(page common.Page) ([]User, *common.Cursor, error)
+	UpdateRole(user *User, role string) error
+	// SetSuspended suspends the user or lifts their suspension
+	SetSuspended(user *User, suspended bool) error
+	// Delete removes the user together with their posts and linked identities
+	Delete(user *User) error
+}
+
+// PostStore finds, lists and saves posts. Listings are paginated newest first and only
+// include published posts unless stated otherwise.
+type PostStore interface {
+	FindByID(id uint) (Post, error)
+	FindByUUID(uuid string) (Post, error)
+	// Create saves the post and its paragraphs in the given order
+	Create(post *Post, paragraphs []Paragraph) error
+	// Save updates the post's own fields, but not its paragraphs
+	Save(post *Post) error
+	Delete(post *Post) error
+	Paragraphs(post Post) ([]Paragraph, error)
+	FindParagraph(uuid string) (Paragraph, error)
+	// InsertParagraph adds the paragraph to the post at the position, moving the following
+	// paragraphs forward. Positions outside of the post add it to the end.
+	InsertParagraph(post Post, paragraph *Paragraph, position int) error
+	// SaveParagraph updates the paragraph's block
+	SaveParagraph(paragraph *Paragraph) error
+	// MoveParagraph moves the paragraph to a new position in its post. Positions outside of
+	// the post move it to the end.
+	MoveParagraph(paragraph *Paragraph, position int) error
+	// DeleteParagraph removes the paragraph and closes the gap it leaves in the post
+	DeleteParagraph(paragraph Paragraph) error
+	// ReplaceContent saves the post and makes the paragraphs its content in the given order.
+	// Paragraphs with the uuid of one of the post's paragraphs update it, the others are
+	// created and the post's remaining paragraphs are deleted.
+	ReplaceContent(post *Post, paragraphs []Paragraph) error
+	List(page common.Page) ([]Post, *common.Cursor, error)
+	ListByUser(user User, page common.Page, onlyPublished bool) ([]Post, *common.Cursor, error)
+	ListByTopic(topic Topic, page common.Page) ([]Post, *common.Cursor, error)
+	// Feed lists the posts written by users or posted in topics the user follows
+	Feed(user User, page common.Page) ([]Post, *common.Cursor, error)
+	// PublishDue publishes the scheduled posts whose publish time has passed. The time has to
+	// be in UTC like the stored publish times.
+	PublishDue(now time.Time) (int64, error)
+	// Serialize formats the posts to JSON-format for the viewer, who can be nil
+	Serialize(posts []Post, viewer *User) []common.JSON
+}
+
+// TopicStore finds, lists and saves topics
+type TopicStore interface {
+	FindByUUID(uuid string) (Topic, error)
+	FindByURL(url string) (Topic, error)
+	FindByTitle(title string) (Topic, error)
+	Create(topic *Topic) error
+	Save(topic *Topic) error
+	Delete(topic *Topic) error
+	List(page common.Page) ([]Topic, *common.Cursor, error)
+	ListByUser(user User, page common.Page) ([]Topic, *common.Cursor, error)
+}
+
+// FollowStore keeps track of the users and topics users follow
+type FollowStore interface {
+	IsFollowing(follower, followed User) (bool, error)
+	Follow(follower, followed User) error
+	Unfollow(follower, followed User) error
+	IsFollowingTopic(user User, topic Topic) (bool, error)
+	FollowTopic(user User, topic Topic) error
+	UnfollowTopic(user User, topic Topic) error
+	FollowedUsers(user User, page common.Page) ([]User, *common.Cursor, error)
+	FollowedTopics(user User, page common.Page) ([]Topic, *common.Cursor, error)
+}
+
+// LikeStore keeps track of the posts users have liked. The post's like count is refreshed
+// together with the like, so that it can't drift.
+type LikeStore interface {
+	// Like adds the user's like, ErrConflict is returned when they've already liked the post
+	Like(user User, post *Post) error
+	// Unlike removes the user's like, ErrNotFound is returned when there isn't one
+	Unlike(user User, post *Post) error
+	// Likers returns a page of the users who have liked the post, most recent likes first
+	Likers(post Post, page common.Page) ([]User, *common.Cursor, error)
+}
+
+// CommentStore keeps the comment threads of posts
+type CommentStore interface {
+	FindByUUID(uuid string) (Comment, error)
+	Create(comment *Comment) error
+	Save(comment *Comment) error
+	// Remove clears the comment's content, but keeps it in the thread for its replies
+	Remove(comment *Comment) error
+	// Tree returns a page of the post's top-level comments formatted with all of their
+	// replies, oldest replies first
+	Tree(post Post, page common.Page) ([]common.JSON, *common.Cursor, error)
+	// Serialize formats the comment with its author
+	Serialize(comment Comment) common.JSON
+}
+
+// RevisionStore keeps the snapshots taken of posts after every edit
+type RevisionStore interface {
+	// Snapshot stores the post's current content as its next revision
+	Snapshot(post Post, user User) (PostRevision, error)
+	// Ensure snapshots posts which were created before revisions were tracked, so that their
+	// original content isn't lost when they're edited for the first time
+	Ensure(post Post) error
+	// List returns a page of the post's revisions, newest first
+	List(post Post, page common.Page) ([]PostRevision, *common.Cursor, error)
+	Find(post Post, number int) (PostRevision, error)
+	// Restore replaces the post's content with the revision's and stores the result as a new
+	// revision, so that the restore itself can be undone
+	Restore(post *Post, revision PostRevision, user User) (PostRevision, error)
+}
+
+// RefreshTokenStore keeps the refresh tokens given out to users. Tokens are looked up by
+// their hash and revoked tokens are kept, so that reusing one can be noticed.
+type RefreshTokenStore interface {
+	Create(token *RefreshToken) error
+	FindByHash(hash string) (RefreshToken, error)
+	// Rotate revokes the old token and saves its replacement. ErrConflict is returned when
+	// the old token has already been revoked, for example by a concurrent refresh.
+	Rotate(old *RefreshToken, replacement *RefreshToken) error
+	Revoke(token *RefreshToken) error
+	// RevokeAll revokes every active refresh token of the user
+	RevokeAll(user User) error
+}
+
+// IdentityStore links users to their accounts at external identity providers
+type IdentityStore interface {
+	Find(provider, subject string) (Identity, error)
+	// Create links the identity, ErrConflict is returned when it's already linked to someone
+	Create(identity *Identity) error
+	// CreateWithUser creates the user and links the identity to them in a single transaction.
+	// ErrConflict is returned when the identity is already linked to someone. On any error
+	// neither is saved, for example when the username is taken.
+	CreateWithUser(user *User, identity *Identity) error
+	ListByUser(user User) ([]Identity, error)
+	Delete(identity *Identity) error
+}
+
+// RecoveryCodeStore keeps the hashes of the users' two-factor recovery codes
+type RecoveryCodeStore interface {
+	// Replace removes the user's codes and saves the new ones
+	Replace(user User, hashes []string) error
+	// Use marks the unused code with the hash used, or returns ErrNotFound
+	Use(user User, hash string) error
+	CountUnused(user User) (int, error)
+}
+
+// PersonalTokenStore keeps the users' personal access tokens
+type PersonalTokenStore interface {
+	Create(token *PersonalToken) error
+	FindByHash(hash string) (PersonalToken, error)
+	// FindForUser finds the user's token with the id, other users' tokens aren't found
+	FindForUser(user User, id uint) (PersonalToken, error)
+	ListByUser(user User) ([]PersonalToken, error)
+	Delete(token *PersonalToken) error
+	// DeleteAll revokes every token of the user
+	DeleteAll(user User) error
+	// Touch records when the token was last used
+	Touch(token *PersonalToken, at time.Time) error
+}
+
+// SessionStore keeps the devices users are logged in on
+type SessionStore interface {
+	Create(session *Session) error
+	FindByID(id uint) (Session, error)
+	// ListActive returns the user's sessions which haven't been revoked or expired, the most
+	// recently seen first
+	ListActive(user User, now time.Time) ([]Session, error)
+	// Touch records when the session was last seen
+	Touch(session *Session, at time.Time) error
+	// Extend moves the session's expiry when its refresh token is rotated
+	Extend(session *Session, at time.Time, expiresAt time.Time) error
+	// Revoke ends the session and revokes its refresh tokens
+	Revoke(session *Session) error
+	// RevokeAll ends every session of the user except the one with the id, which can be zero
+	RevokeAll(user User, except uint) error
+}
+
+// SecurityEventStore appends to the security log
+type SecurityEventStore interface {
+	Create(event *SecurityEvent) error
+}
+
+// Stores groups the stores handed to the route packages
+type Stores struct {
+	Users          UserStore
+	Posts          PostStore
+	Topics         TopicStore
+	Follows        FollowStore
+	Likes          LikeStore
+	Comments       CommentStore
+	Revisions      RevisionStore
+	Tokens         RefreshTokenStore
+	Identities     IdentityStore
+	RecoveryCodes  RecoveryCodeStore
+	PersonalTokens PersonalTokenStore
+	SecurityEvents SecurityEventStore
+	Sessions       SessionStore
+	// Search indexes the posts and searches them
+	Search search.Engine
+}
+
+// RevokeSessions ends every session of the user and makes every access, refresh and personal
+// access token given out to them unusable
+func (stores Stores) RevokeSessions(user *User) error {
+	if err := stores.Users.BumpTokenVersion(user); err != nil {
+		return err
+	}
+
+	if err := stores.Sessions.RevokeAll(*user, 0); err != nil {
+		return err
+	}
+
+	if err := stores.Tokens.RevokeAll(*user); err != nil {
+		return err
+	}
+
+	return stores.PersonalTokens.DeleteAll(*user)
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultPageLimit is used when the client doesn't provide a limit
+const DefaultPageLimit = 20
+
+// MaxPageLimit is the largest page the server will return, no matter what the client asks for
+const MaxPageLimit = 100
+
+// Cursor marks the position of the last row a client has already received. Rows are always
+// ordered newest first by created_at and id is used to break ties.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+// Encode turns the cursor into an opaque token which can be sent to the client
+func (cursor Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", cursor.CreatedAt.UnixNano(), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a token created by Cursor.Encode
+func DecodeCursor(token string) (Cursor, error) {
+	var cursor Cursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, errors.New("Invalid cursor")
+	}
+
+	var nanoseconds int64
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &nanoseconds, &cursor.ID); err != nil {
+		return cursor, errors.New("Invalid cursor")
+	}
+
+	cursor.CreatedAt = time.Unix(0, nanoseconds)
+	return cursor, nil
+}
+
+// Page describes which slice of a list the client wants
+type Page struct {
+	Limit int
+	After *Cursor
+}
+
+// FirstPage returns the first page with the default limit
+func FirstPage() Page {
+	return Page{Limit: DefaultPageLimit}
+}
+
+// ParseLimit reads the limit query parameter and caps it to MaxPageLimit
+func ParseLimit(c *gin.Context) (int, error) {
+	rawLimit := c.Query("limit")
+	if rawLimit == "" {
+		return DefaultPageLimit, nil
+	}
+
+	limit, err := strconv.Atoi(rawLimit)
+	if err != nil || limit < 1 {
+		return 0, errors.New("Invalid limit")
+	}
+
+	if limit > MaxPageLimit {
+		return MaxPageLimit, nil
+	}
+
+	return limit, nil
+}
+
+// ParsePage reads the limit and cursor query parameters. When a name is given the cursor is
+// read from '<name>_cursor' instead, so that a single response can contain multiple lists.
+func ParsePage(c *gin.Context, name string) (Page, error) {
+	page := FirstPage()
+
+	limit, err := ParseLimit(c)
+	if err != nil {
+		return page, err
+	}
+
+	page.Limit = limit
+
+	cursorParam := "cursor"
+	if name != "" {
+		cursorParam = name + "_cursor"
+	}
+
+	if token := c.Query(cursorParam); token != "" {
+		cursor, err := DecodeCursor(token)
+		if err != nil {
+			return page, err
+		}
+
+		page.After = &cursor
+	}
+
+	return page, nil
+}
+
+// Apply orders the query newest first and skips everything up to the cursor. One extra row is
+// fetched so that Next can tell whether there is another page.
+func (page Page) Apply(query *gorm.DB, table string) *gorm.DB {
+	createdAt := table + ".created_at"
+	id := table + ".id"
+
+	if page.After != nil {
+		query = query.Where(
+			fmt.Sprintf("%s < ? OR (%s = ? AND %s < ?)", createdAt, createdAt, id),
+			page.After.CreatedAt, page.After.CreatedAt, page.After.ID,
+		)
+	}
+
+	return query.Order(createdAt + " desc").Order(id + " desc").Limit(page.Limit + 1)
+}
+
+// Next returns the cursor for the following page, or nil if the fetched rows fit in this page.
+// The position function should return the created_at and id of the row at the given index.
+func (page Page) Next(count int, position func(index int) (time.Time, uint)) *Cursor {
+	if count <= page.Limit {
+		return nil
+	}
+
+	createdAt, id := position(page.Limit - 1)
+	return &Cursor{CreatedAt: createdAt, ID: id}
+}
+
+// Visible returns how many of the fetched rows belong to this page
+func (page Page) Visible(count int) int {
+	if count > page.Limit {
+		return page.Limit
+	}
+
+	return count
+}
+
+// EncodeCursor formats a cursor for a response envelope, null means that there are no more pages
+func EncodeCursor(cursor *Cursor) interface{} {
+	if cursor == nil {
+		return nil
+	}
+
+	return cursor.Encode()
+}
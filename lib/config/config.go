@@ -0,0 +1,613 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable read by Load
+const EnvPrefix = "BLOG_"
+
+// The log levels the server accepts, from the most to the least verbose
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// Drivers are the database drivers the server can connect with
+var Drivers = []string{"sqlite3", "postgres", "mysql"}
+
+// Algorithms are the algorithms tokens can be signed with
+var Algorithms = []string{"HS256", "RS256", "EdDSA"}
+
+// Mailers are the ways emails can be sent
+var Mailers = []string{"log", "smtp"}
+
+// StorageDrivers are the places uploaded files can be kept
+var StorageDrivers = []string{"local"}
+
+// providerName restricts the provider names to ones which can be used in urls
+var providerName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// minSecretLength makes sure the JWT secret can't be guessed easily
+const minSecretLength = 16
+
+// Config holds all of the server's settings
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Database  Database  `yaml:"database" toml:"database"`
+	JWT       JWT       `yaml:"jwt" toml:"jwt"`
+	CORS      CORS      `yaml:"cors" toml:"cors"`
+	Log       Log       `yaml:"log" toml:"log"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Mail      Mail      `yaml:"mail" toml:"mail"`
+	OIDC      OIDC      `yaml:"oidc" toml:"oidc"`
+	Lockout   Lockout   `yaml:"lockout" toml:"lockout"`
+	Storage   Storage   `yaml:"storage" toml:"storage"`
+}
+
+// Server configures the http server. PublicURL is the address readers reach the site at,
+// which the links in the feeds start with. The client ip is only read from the
+// X-Forwarded-For and X-Real-IP headers of requests sent by one of the TrustedProxies, which
+// are ips or CIDR ranges. Without them the headers are ignored.
+type Server struct {
+	Address        string   `yaml:"address" toml:"address"`
+	PublicURL      string   `yaml:"public_url" toml:"public_url"`
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// Database configures the database connection. Pending migrations are applied at startup
+// when AutoMigrate is set, otherwise the server refuses to start until they've been applied.
+type Database struct {
+	Driver      string `yaml:"driver" toml:"driver"`
+	DSN         string `yaml:"dsn" toml:"dsn"`
+	AutoMigrate bool   `yaml:"auto_migrate" toml:"auto_migrate"`
+}
+
+// JWT configures the tokens given out to users. Access tokens are short-lived and can only
+// be revoked all at once, refresh tokens are stored in the database and exchanged for new
+// access tokens until they expire or are revoked.
+//
+// HS256 tokens are signed with the secret. RS256 and EdDSA tokens are signed with the
+// private key in SigningKey and verified with its public key and the VerificationKeys,
+// which keep the tokens signed with retired keys valid during a rotation.
+type JWT struct {
+	Algorithm        string        `yaml:"algorithm" toml:"algorithm"`
+	Secret           string        `yaml:"secret" toml:"secret"`
+	SigningKey       string        `yaml:"signing_key" toml:"signing_key"`
+	VerificationKeys []string      `yaml:"verification_keys" toml:"verification_keys"`
+	Expiry           time.Duration `yaml:"expiry" toml:"expiry"`
+	RefreshExpiry    time.Duration `yaml:"refresh_expiry" toml:"refresh_expiry"`
+}
+
+// CORS lists the origins allowed to call the api from a browser, "*" allows every origin
+type CORS struct {
+	Origins []string `yaml:"origins" toml:"origins"`
+}
+
+// Log configures what the server logs
+type Log struct {
+	Level string `yaml:"level" toml:"level"`
+}
+
+// RateLimit limits the requests a single client can send. Requests are allowed at
+// RequestsPerSecond on average with bursts of Burst requests, zero disables the limit.
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second" toml:"requests_per_second"`
+	Burst             int     `yaml:"burst" toml:"burst"`
+}
+
+// Lockout slows down password guessing. Every failed login makes the username and the client
+// ip wait BaseDelay, doubled after each further failure up to MaxDelay. MaxAttempts failures
+// for a username, or IPMaxAttempts from an ip, within Window lock it for Duration. Zero
+// attempts turn the tracking off.
+type Lockout struct {
+	MaxAttempts   int           `yaml:"max_attempts" toml:"max_attempts"`
+	IPMaxAttempts int           `yaml:"ip_max_attempts" toml:"ip_max_attempts"`
+	Window        time.Duration `yaml:"window" toml:"window"`
+	Duration      time.Duration `yaml:"duration" toml:"duration"`
+	BaseDelay     time.Duration `yaml:"base_delay" toml:"base_delay"`
+	MaxDelay      time.Duration `yaml:"max_delay" toml:"max_delay"`
+}
+
+// Storage configures where uploaded files, like avatars, are kept. The local driver writes
+// them to Directory and the server serves them under /uploads, so URL should be the public
+// address of that path. MaxAvatarSize is in bytes.
+type Storage struct {
+	Driver        string `yaml:"driver" toml:"driver"`
+	Directory     string `yaml:"directory" toml:"directory"`
+	URL           string `yaml:"url" toml:"url"`
+	MaxAvatarSize int64  `yaml:"max_avatar_size" toml:"max_avatar_size"`
+}
+
+// Mail configures the emails sent for verifying addresses and resetting passwords. The log
+// mailer appends the emails to File, or prints them when File is empty, instead of sending
+// them. LinkURL is the address of the frontend, which the links in the emails point to.
+type Mail struct {
+	Driver   string `yaml:"driver" toml:"driver"`
+	From     string `yaml:"from" toml:"from"`
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+	File     string `yaml:"file" toml:"file"`
+	LinkURL  string `yaml:"link_url" toml:"link_url"`
+}
+
+// OIDC configures logging in with external OpenID Connect providers. CallbackURL is the
+// public address of this server, which the providers send the users back to, and
+// RedirectURL is the frontend page which receives the tokens after the login.
+type OIDC struct {
+	CallbackURL string         `yaml:"callback_url" toml:"callback_url"`
+	RedirectURL string         `yaml:"redirect_url" toml:"redirect_url"`
+	Providers   []OIDCProvider `yaml:"providers" toml:"providers"`
+}
+
+// OIDCProvider is a single OpenID Connect provider. The name is used in the login urls and
+// the issuer's discovery document is fetched the first time someone logs in with it.
+type OIDCProvider struct {
+	Name         string   `yaml:"name" toml:"name"`
+	Issuer       string   `yaml:"issuer" toml:"issuer"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	Scopes       []string `yaml:"scopes" toml:"scopes"`
+}
+
+// Default returns the settings used when nothing else has been configured. There's no
+// default JWT secret, so it always has to be configured.
+func Default() Config {
+	return Config{
+		Server:   Server{Address: ":8080", PublicURL: "http://localhost:8080"},
+		Database: Database{Driver: "sqlite3", DSN: "./database.db", AutoMigrate: true},
+		JWT:      JWT{Algorithm: "HS256", Expiry: time.Minute * 15, RefreshExpiry: time.Hour * 24 * 30},
+		Log:      Log{Level: "info"},
+		Mail:     Mail{Driver: "log", From: "blog@localhost", Port: 587, LinkURL: "http://localhost:3000"},
+		OIDC:     OIDC{CallbackURL: "http://localhost:8080", RedirectURL: "http://localhost:3000/oauth/callback"},
+		Storage:  Storage{Driver: "local", Directory: "./uploads", URL: "http://localhost:8080/uploads", MaxAvatarSize: 2 << 20},
+		Lockout: Lockout{
+			MaxAttempts:   5,
+			IPMaxAttempts: 50,
+			Window:        time.Minute * 15,
+			Duration:      time.Minute * 15,
+			BaseDelay:     time.Second,
+			MaxDelay:      time.Minute,
+		},
+	}
+}
+
+// Load reads the configuration like Read and validates the result
+func Load(path string) (Config, error) {
+	config, err := Read(path)
+	if err != nil {
+		return config, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// Read reads the configuration file, if path isn't empty, and applies the environment
+// overrides on top of it. The file's format is picked from its extension.
+func Read(path string) (Config, error) {
+	config := Default()
+
+	if path != "" {
+		if err := readFile(path, &config); err != nil {
+			return config, err
+		}
+	}
+
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func readFile(path string, config *Config) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Could not read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, config)
+	case ".toml":
+		err = toml.Unmarshal(content, config)
+	default:
+		return fmt.Errorf("Unsupported config file format: %s", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Could not parse config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv overrides the settings with the environment variables which have been set
+func (config *Config) applyEnv(lookup func(string) (string, bool)) error {
+	overrides := map[string]*string{
+		"ADDRESS":           &config.Server.Address,
+		"PUBLIC_URL":        &config.Server.PublicURL,
+		"DB_DRIVER":         &config.Database.Driver,
+		"DB_DSN":            &config.Database.DSN,
+		"JWT_SECRET":        &config.JWT.Secret,
+		"JWT_ALGORITHM":     &config.JWT.Algorithm,
+		"JWT_SIGNING_KEY":   &config.JWT.SigningKey,
+		"LOG_LEVEL":         &config.Log.Level,
+		"MAIL_DRIVER":       &config.Mail.Driver,
+		"MAIL_FROM":         &config.Mail.From,
+		"MAIL_HOST":         &config.Mail.Host,
+		"MAIL_USERNAME":     &config.Mail.Username,
+		"MAIL_PASSWORD":     &config.Mail.Password,
+		"MAIL_FILE":         &config.Mail.File,
+		"MAIL_LINK_URL":     &config.Mail.LinkURL,
+		"OIDC_CALLBACK_URL": &config.OIDC.CallbackURL,
+		"OIDC_REDIRECT_URL": &config.OIDC.RedirectURL,
+		"STORAGE_DRIVER":    &config.Storage.Driver,
+		"STORAGE_DIRECTORY": &config.Storage.Directory,
+		"STORAGE_URL":       &config.Storage.URL,
+	}
+
+	for name, setting := range overrides {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			*setting = value
+		}
+	}
+
+	if value, ok := lookup(EnvPrefix + "DB_AUTO_MIGRATE"); ok {
+		autoMigrate, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%sDB_AUTO_MIGRATE should be a boolean: %v", EnvPrefix, err)
+		}
+
+		config.Database.AutoMigrate = autoMigrate
+	}
+
+	durations := map[string]*time.Duration{
+		"JWT_EXPIRY":         &config.JWT.Expiry,
+		"JWT_REFRESH_EXPIRY": &config.JWT.RefreshExpiry,
+		"LOCKOUT_WINDOW":     &config.Lockout.Window,
+		"LOCKOUT_DURATION":   &config.Lockout.Duration,
+	}
+
+	for name, setting := range durations {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s%s should be a duration: %v", EnvPrefix, name, err)
+			}
+
+			*setting = duration
+		}
+	}
+
+	if value, ok := lookup(EnvPrefix + "JWT_VERIFICATION_KEYS"); ok {
+		config.JWT.VerificationKeys = splitList(value)
+	}
+
+	if value, ok := lookup(EnvPrefix + "TRUSTED_PROXIES"); ok {
+		config.Server.TrustedProxies = splitList(value)
+	}
+
+	if value, ok := lookup(EnvPrefix + "CORS_ORIGINS"); ok {
+		config.CORS.Origins = splitList(value)
+	}
+
+	if value, ok := lookup(EnvPrefix + "RATE_LIMIT"); ok {
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%sRATE_LIMIT should be a number: %v", EnvPrefix, err)
+		}
+
+		config.RateLimit.RequestsPerSecond = limit
+	}
+
+	// the providers are only configured in the file, but their secrets can be kept out of it
+	for index := range config.OIDC.Providers {
+		provider := &config.OIDC.Providers[index]
+		name := "OIDC_" + strings.ToUpper(strings.ReplaceAll(provider.Name, "-", "_")) + "_CLIENT_SECRET"
+		if value, ok := lookup(EnvPrefix + name); ok {
+			provider.ClientSecret = value
+		}
+	}
+
+	if value, ok := lookup(EnvPrefix + "MAIL_PORT"); ok {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%sMAIL_PORT should be an integer: %v", EnvPrefix, err)
+		}
+
+		config.Mail.Port = port
+	}
+
+	if value, ok := lookup(EnvPrefix + "RATE_BURST"); ok {
+		burst, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%sRATE_BURST should be an integer: %v", EnvPrefix, err)
+		}
+
+		config.RateLimit.Burst = burst
+	}
+
+	if value, ok := lookup(EnvPrefix + "STORAGE_MAX_AVATAR_SIZE"); ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%sSTORAGE_MAX_AVATAR_SIZE should be an integer: %v", EnvPrefix, err)
+		}
+
+		config.Storage.MaxAvatarSize = size
+	}
+
+	attempts := map[string]*int{
+		"LOCKOUT_MAX_ATTEMPTS":    &config.Lockout.MaxAttempts,
+		"LOCKOUT_IP_MAX_ATTEMPTS": &config.Lockout.IPMaxAttempts,
+	}
+
+	for name, setting := range attempts {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s%s should be an integer: %v", EnvPrefix, name, err)
+			}
+
+			*setting = count
+		}
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Validate checks that the settings can be used to start the server
+func (config *Config) Validate() error {
+	if config.Server.Address == "" {
+		return errors.New("server.address is required")
+	}
+
+	if !httpURL(config.Server.PublicURL) {
+		return errors.New("server.public_url should be an address like https://example.com")
+	}
+
+	for _, proxy := range config.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("server.trusted_proxies should only contain ips and CIDR ranges, not %s", proxy)
+		}
+	}
+
+	if err := config.Database.Validate(); err != nil {
+		return err
+	}
+
+	if err := config.JWT.Validate(); err != nil {
+		return err
+	}
+
+	for _, origin := range config.CORS.Origins {
+		if origin == "*" {
+			continue
+		}
+
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.Path != "" {
+			return fmt.Errorf("cors.origins should only contain '*' or origins like https://example.com, not %s", origin)
+		}
+	}
+
+	if !contains(logLevels, config.Log.Level) {
+		return fmt.Errorf("log.level should be one of %s", strings.Join(logLevels, ", "))
+	}
+
+	if config.RateLimit.RequestsPerSecond < 0 {
+		return errors.New("rate_limit.requests_per_second can't be negative")
+	}
+
+	if config.RateLimit.RequestsPerSecond > 0 && config.RateLimit.Burst < 1 {
+		return errors.New("rate_limit.burst should be at least 1 when requests are limited")
+	}
+
+	if err := config.Mail.Validate(); err != nil {
+		return err
+	}
+
+	if err := config.OIDC.Validate(); err != nil {
+		return err
+	}
+
+	if err := config.Lockout.Validate(); err != nil {
+		return err
+	}
+
+	if err := config.Storage.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate checks the storage settings
+func (storage *Storage) Validate() error {
+	if !contains(StorageDrivers, storage.Driver) {
+		return fmt.Errorf("storage.driver should be one of %s", strings.Join(StorageDrivers, ", "))
+	}
+
+	if storage.Directory == "" {
+		return errors.New("storage.directory is required")
+	}
+
+	if !httpURL(storage.URL) {
+		return errors.New("storage.url should be an address like https://example.com/uploads")
+	}
+
+	if storage.MaxAvatarSize <= 0 {
+		return errors.New("storage.max_avatar_size should be positive")
+	}
+
+	return nil
+}
+
+// Validate checks the lockout settings. The durations only matter when attempts are tracked.
+func (lockout *Lockout) Validate() error {
+	if lockout.MaxAttempts < 0 || lockout.IPMaxAttempts < 0 {
+		return errors.New("lockout.max_attempts and lockout.ip_max_attempts can't be negative")
+	}
+
+	if lockout.MaxAttempts == 0 && lockout.IPMaxAttempts == 0 {
+		return nil
+	}
+
+	if lockout.Window <= 0 || lockout.Duration <= 0 {
+		return errors.New("lockout.window and lockout.duration should be positive when attempts are tracked")
+	}
+
+	if lockout.BaseDelay < 0 || lockout.MaxDelay < lockout.BaseDelay {
+		return errors.New("lockout.max_delay should be at least lockout.base_delay, which can't be negative")
+	}
+
+	return nil
+}
+
+// Validate checks the OpenID Connect settings. The urls are only needed when there are
+// providers to log in with.
+func (oidc *OIDC) Validate() error {
+	if len(oidc.Providers) == 0 {
+		return nil
+	}
+
+	if !httpURL(oidc.CallbackURL) {
+		return errors.New("oidc.callback_url should be an address like https://api.example.com")
+	}
+
+	if !httpURL(oidc.RedirectURL) {
+		return errors.New("oidc.redirect_url should be an address like https://example.com/oauth/callback")
+	}
+
+	names := map[string]bool{}
+	for _, provider := range oidc.Providers {
+		if !providerName.MatchString(provider.Name) {
+			return fmt.Errorf("oidc provider names should only contain lowercase letters, digits and dashes, not '%s'", provider.Name)
+		}
+
+		if names[provider.Name] {
+			return fmt.Errorf("oidc provider %s is configured twice", provider.Name)
+		}
+
+		names[provider.Name] = true
+
+		if !httpURL(provider.Issuer) {
+			return fmt.Errorf("oidc provider %s needs an issuer url", provider.Name)
+		}
+
+		if provider.ClientID == "" {
+			return fmt.Errorf("oidc provider %s needs a client_id", provider.Name)
+		}
+	}
+
+	return nil
+}
+
+// httpURL checks that the value is an absolute http or https url
+func httpURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Validate checks the mail settings. Only the SMTP mailer needs a server to send through.
+func (mail *Mail) Validate() error {
+	if !contains(Mailers, mail.Driver) {
+		return fmt.Errorf("mail.driver should be one of %s", strings.Join(Mailers, ", "))
+	}
+
+	if mail.From == "" {
+		return errors.New("mail.from is required")
+	}
+
+	if !httpURL(mail.LinkURL) {
+		return errors.New("mail.link_url should be an address like https://example.com")
+	}
+
+	if mail.Driver == "smtp" && (mail.Host == "" || mail.Port <= 0) {
+		return errors.New("mail.host and mail.port are required with the smtp mailer")
+	}
+
+	return nil
+}
+
+// Validate checks the token settings. The secret is only needed for HS256 and the signing
+// key for the other algorithms.
+func (jwt *JWT) Validate() error {
+	if !contains(Algorithms, jwt.Algorithm) {
+		return fmt.Errorf("jwt.algorithm should be one of %s", strings.Join(Algorithms, ", "))
+	}
+
+	if jwt.Algorithm == "HS256" && len(jwt.Secret) < minSecretLength {
+		return fmt.Errorf("jwt.secret should be at least %d characters", minSecretLength)
+	}
+
+	if jwt.Algorithm != "HS256" && jwt.SigningKey == "" {
+		return fmt.Errorf("jwt.signing_key is required with %s", jwt.Algorithm)
+	}
+
+	if jwt.Expiry <= 0 {
+		return errors.New("jwt.expiry should be positive")
+	}
+
+	if jwt.RefreshExpiry <= jwt.Expiry {
+		return errors.New("jwt.refresh_expiry should be longer than jwt.expiry")
+	}
+
+	return nil
+}
+
+// Validate checks the database settings, which is all the migrate command needs
+func (database *Database) Validate() error {
+	if !contains(Drivers, database.Driver) {
+		return fmt.Errorf("database.driver should be one of %s", strings.Join(Drivers, ", "))
+	}
+
+	if database.DSN == "" {
+		return errors.New("database.dsn is required")
+	}
+
+	return nil
+}
+
+// Verbose tells if the log level includes informational messages
+func (log Log) Verbose() bool {
+	return log.Level == "debug" || log.Level == "info"
+}
+
+func contains(values []string, value string) bool {
+	for index := range values {
+		if values[index] == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+// ErrUnknownProvider is returned when no provider has the requested name
+var ErrUnknownProvider = errors.New("Unknown identity provider")
+
+// Claims describe the user who logged in at the provider. The subject identifies the user at
+// the provider and never changes, unlike the other claims.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Provider authenticates users with an external identity provider using the authorization
+// code flow. The verifier is the PKCE code verifier and the nonce ties the provider's
+// response to the login which asked for it.
+type Provider interface {
+	Name() string
+	// AuthCodeURL returns the address the user is sent to for logging in
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	// Exchange trades the code the provider gave back for the user's verified claims
+	Exchange(ctx context.Context, code, nonce, verifier string) (Claims, error)
+}
+
+// Providers are the identity providers users can log in with, keyed by their name
+type Providers map[string]Provider
+
+// Load creates an OpenID Connect provider for every configured provider. The providers are
+// only contacted when someone logs in, so an unreachable provider doesn't stop the server.
+func Load(settings config.OIDC) Providers {
+	providers := Providers{}
+	for _, provider := range settings.Providers {
+		providers[provider.Name] = NewOIDCProvider(provider, settings.CallbackURL)
+	}
+
+	return providers
+}
+
+// Get finds the provider with the name
+func (providers Providers) Get(name string) (Provider, error) {
+	provider, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	return provider, nil
+}
+
+// Names lists the providers' names in alphabetical order
+func (providers Providers) Names() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
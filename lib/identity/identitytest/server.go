@@ -0,0 +1,200 @@
+// Package identitytest runs a fake OpenID Connect provider for tests
+package identitytest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/nireo/go-blog-api/lib/identity"
+)
+
+// keyID identifies the provider's only signing key in its JWKS
+const keyID = "test-key"
+
+// Server is an OpenID Connect provider with a discovery document, a JWKS and a token
+// endpoint. Logins are approved with Authorize instead of a login page, and the token
+// endpoint checks the client's credentials and the PKCE verifier like a real provider.
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key    *rsa.PrivateKey
+	mutex  sync.Mutex
+	grants map[string]grant
+}
+
+// grant is a login which has been approved but whose code hasn't been exchanged yet
+type grant struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+	claims      identity.Claims
+}
+
+// NewServer starts the provider. The test has to close it.
+func NewServer(clientID, clientSecret string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	server := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		grants:       map[string]grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", server.discovery)
+	mux.HandleFunc("/jwks", server.jwks)
+	mux.HandleFunc("/token", server.token)
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+// Authorize approves the login at the address the user was sent to and returns the address
+// the provider sends the user back to, with the code and the login's state
+func (server *Server) Authorize(loginURL string, claims identity.Claims) (string, error) {
+	parsed, err := url.Parse(loginURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsed.Query()
+	if query.Get("client_id") != server.ClientID || query.Get("response_type") != "code" {
+		return "", errors.New("The login isn't for this client")
+	}
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return "", errors.New("The login doesn't use PKCE")
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(random)
+	server.mutex.Lock()
+	server.grants[code] = grant{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: query.Get("redirect_uri"),
+		claims:      claims,
+	}
+	server.mutex.Unlock()
+
+	callback, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return "", err
+	}
+
+	values := callback.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	callback.RawQuery = values.Encode()
+	return callback.String(), nil
+}
+
+func (server *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                server.URL,
+		"authorization_endpoint":                server.URL + "/authorize",
+		"token_endpoint":                        server.URL + "/token",
+		"jwks_uri":                              server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (server *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	public := server.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+// token exchanges a code once. The verifier has to match the login's challenge.
+func (server *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID != server.ClientID || clientSecret != server.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	server.mutex.Lock()
+	approved, ok := server.grants[r.PostForm.Get("code")]
+	delete(server.grants, r.PostForm.Get("code"))
+	server.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || approved.redirectURI != r.PostForm.Get("redirect_uri") || base64.RawURLEncoding.EncodeToString(challenge[:]) != approved.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                server.URL,
+		"aud":                server.ClientID,
+		"sub":                approved.claims.Subject,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+		"nonce":              approved.nonce,
+		"email":              approved.claims.Email,
+		"email_verified":     approved.claims.EmailVerified,
+		"name":               approved.claims.Name,
+		"preferred_username": approved.claims.PreferredUsername,
+	})
+	idToken.Header["kid"] = keyID
+
+	signed, err := idToken.SignedString(server.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access-" + r.PostForm.Get("code"),
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,131 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/nireo/go-blog-api/lib/config"
+	"golang.org/x/oauth2"
+)
+
+// OIDCProvider logs users in with an OpenID Connect provider. The authorization code flow is
+// protected with PKCE and the ID token's signature, audience and nonce are verified.
+type OIDCProvider struct {
+	settings    config.OIDCProvider
+	callbackURL string
+
+	mutex    sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider creates a provider which redirects back to the callback route under the
+// server's public address
+func NewOIDCProvider(settings config.OIDCProvider, serverURL string) *OIDCProvider {
+	return &OIDCProvider{
+		settings:    settings,
+		callbackURL: CallbackURL(serverURL, settings.Name),
+	}
+}
+
+// CallbackURL is the address the provider sends the user back to after logging in
+func CallbackURL(serverURL, name string) string {
+	return strings.TrimRight(serverURL, "/") + "/api/auth/oidc/" + name + "/callback"
+}
+
+// Name returns the name the provider was configured with
+func (provider *OIDCProvider) Name() string {
+	return provider.settings.Name
+}
+
+// discover fetches the issuer's discovery document the first time it is needed. A failure
+// isn't remembered, so the next login tries again.
+func (provider *OIDCProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.oauth != nil {
+		return provider.oauth, provider.verifier, nil
+	}
+
+	discovered, err := oidc.NewProvider(ctx, provider.settings.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not discover %s: %v", provider.settings.Name, err)
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+	if len(provider.settings.Scopes) > 0 {
+		scopes = append([]string{oidc.ScopeOpenID}, provider.settings.Scopes...)
+	}
+
+	provider.oauth = &oauth2.Config{
+		ClientID:     provider.settings.ClientID,
+		ClientSecret: provider.settings.ClientSecret,
+		Endpoint:     discovered.Endpoint(),
+		RedirectURL:  provider.callbackURL,
+		Scopes:       scopes,
+	}
+	provider.verifier = discovered.Verifier(&oidc.Config{ClientID: provider.settings.ClientID})
+
+	return provider.oauth, provider.verifier, nil
+}
+
+// AuthCodeURL returns the provider's login address with the S256 code challenge
+func (provider *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	config, _, err := provider.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange trades the code for tokens and verifies the ID token in the response
+func (provider *OIDCProvider) Exchange(ctx context.Context, code, nonce, verifier string) (Claims, error) {
+	config, idTokenVerifier, err := provider.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Claims{}, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Claims{}, errors.New("The provider didn't return an ID token")
+	}
+
+	idToken, err := idTokenVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if idToken.Nonce != nonce {
+		return Claims{}, errors.New("The ID token's nonce doesn't match")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+
+	if err := idToken.Claims(&claims); err != nil {
+		return Claims{}, err
+	}
+
+	return Claims{
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package identity_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/nireo/go-blog-api/lib/config"
+	"github.com/nireo/go-blog-api/lib/identity"
+	"github.com/nireo/go-blog-api/lib/identity/identitytest"
+)
+
+var claims = identity.Claims{
+	Subject:           "subject-1",
+	Email:             "user@example.com",
+	EmailVerified:     true,
+	Name:              "Test User",
+	PreferredUsername: "testuser",
+}
+
+func newProvider(t *testing.T) (*identitytest.Server, *identity.OIDCProvider) {
+	server := identitytest.NewServer("client", "secret")
+	t.Cleanup(server.Close)
+
+	provider := identity.NewOIDCProvider(config.OIDCProvider{
+		Name:         "mock",
+		Issuer:       server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}, "http://blog.example.com")
+
+	return server, provider
+}
+
+// login sends the user to the provider and returns the code it gave back
+func login(t *testing.T, server *identitytest.Server, provider *identity.OIDCProvider, nonce, verifier string) string {
+	loginURL, err := provider.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("Could not create the login address: %v", err)
+	}
+
+	callback, err := server.Authorize(loginURL, claims)
+	if err != nil {
+		t.Fatalf("The provider rejected the login: %v", err)
+	}
+
+	parsed, err := url.Parse(callback)
+	if err != nil {
+		t.Fatalf("Could not parse the callback: %v", err)
+	}
+
+	if parsed.Path != "/api/auth/oidc/mock/callback" || parsed.Query().Get("state") != "state" {
+		t.Fatalf("Expected the callback route with the state, got %s", callback)
+	}
+
+	return parsed.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	server, provider := newProvider(t)
+	code := login(t, server, provider, "nonce", "verifier-verifier-verifier-verifier-verifier")
+
+	exchanged, err := provider.Exchange(context.Background(), code, "nonce", "verifier-verifier-verifier-verifier-verifier")
+	if err != nil {
+		t.Fatalf("Exchanging the code failed: %v", err)
+	}
+
+	if exchanged != claims {
+		t.Errorf("Expected %+v, got %+v", claims, exchanged)
+	}
+
+	// codes can only be used once
+	if _, err := provider.Exchange(context.Background(), code, "nonce", "verifier-verifier-verifier-verifier-verifier"); err == nil {
+		t.Error("Exchanging the code twice should fail")
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	server, provider := newProvider(t)
+	code := login(t, server, provider, "nonce", "verifier-verifier-verifier-verifier-verifier")
+
+	if _, err := provider.Exchange(context.Background(), code, "another nonce", "verifier-verifier-verifier-verifier-verifier"); err == nil {
+		t.Error("An ID token for another login's nonce should be rejected")
+	}
+}
+
+func TestExchangeVerifierMismatch(t *testing.T) {
+	server, provider := newProvider(t)
+	code := login(t, server, provider, "nonce", "verifier-verifier-verifier-verifier-verifier")
+
+	if _, err := provider.Exchange(context.Background(), code, "nonce", "stolen-stolen-stolen-stolen-stolen-stolen-stolen"); err == nil {
+		t.Error("A code exchanged without its PKCE verifier should be rejected")
+	}
+}
@@ -0,0 +1,280 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+// Key is a public key which tokens can be verified with. The id is the key's RFC 7638
+// thumbprint, so it stays the same no matter which file the key is loaded from.
+type Key struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+}
+
+// KeySet signs tokens with the current key and verifies them with any of the known keys
+type KeySet struct {
+	method       jwt.SigningMethod
+	signingKey   interface{}
+	signingKeyID string
+	verification map[string]Key
+}
+
+// Load reads the keys from the files named in the settings. HS256 only needs the secret.
+func Load(settings config.JWT) (*KeySet, error) {
+	if settings.Algorithm == "HS256" {
+		return &KeySet{
+			method:       jwt.SigningMethodHS256,
+			signingKey:   []byte(settings.Secret),
+			verification: map[string]Key{},
+		}, nil
+	}
+
+	privateKey, err := readPrivateKey(settings.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a signing key", settings.SigningKey)
+	}
+
+	current, err := newKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	if current.Algorithm != settings.Algorithm {
+		return nil, fmt.Errorf("%s is a %s key, but jwt.algorithm is %s", settings.SigningKey, current.Algorithm, settings.Algorithm)
+	}
+
+	set := &KeySet{
+		method:       jwt.GetSigningMethod(current.Algorithm),
+		signingKey:   privateKey,
+		signingKeyID: current.ID,
+		verification: map[string]Key{current.ID: current},
+	}
+
+	for _, path := range settings.VerificationKeys {
+		publicKey, err := readPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := newKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		set.verification[key.ID] = key
+	}
+
+	return set, nil
+}
+
+// readPEM reads the first PEM block in the file
+func readPEM(path string) (*pem.Block, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("%s doesn't contain a PEM encoded key", path)
+	}
+
+	return block, nil
+}
+
+// readPrivateKey reads a PKCS #8 or a PKCS #1 RSA private key
+func readPrivateKey(path string) (interface{}, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%s doesn't contain a PKCS #8 or PKCS #1 private key", path)
+}
+
+// readPublicKey reads a public key, or the public half of a private key, so that a retired
+// signing key can be listed as is
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	privateKey, err := readPrivateKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s doesn't contain a public key", path)
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't contain a public key", path)
+	}
+
+	return signer.Public(), nil
+}
+
+// newKey finds the algorithm for the public key and computes its id
+func newKey(publicKey crypto.PublicKey) (Key, error) {
+	key := Key{Public: publicKey}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		key.Algorithm = "RS256"
+	case ed25519.PublicKey:
+		key.Algorithm = "EdDSA"
+	default:
+		return key, errors.New("Only RSA and Ed25519 keys are supported")
+	}
+
+	// the thumbprint hashes the required members of the JWK in lexicographic order, which
+	// is how encoding/json writes maps
+	required := common.JSON{}
+	for name, value := range key.JWK() {
+		switch name {
+		case "kty", "n", "e", "crv", "x":
+			required[name] = value
+		}
+	}
+
+	encoded, err := json.Marshal(required)
+	if err != nil {
+		return key, err
+	}
+
+	sum := sha256.Sum256(encoded)
+	key.ID = jwt.EncodeSegment(sum[:])
+	return key, nil
+}
+
+// JWK formats the public key as a JSON Web Key
+func (key Key) JWK() common.JSON {
+	jwk := common.JSON{
+		"use": "sig",
+		"alg": key.Algorithm,
+	}
+
+	if key.ID != "" {
+		jwk["kid"] = key.ID
+	}
+
+	switch publicKey := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = jwt.EncodeSegment(publicKey.N.Bytes())
+		jwk["e"] = jwt.EncodeSegment(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = jwt.EncodeSegment(publicKey)
+	}
+
+	return jwk
+}
+
+// Sign creates a signed token with the claims. Tokens signed with a key pair name the key in
+// the 'kid' header.
+func (set *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(set.method, claims)
+	if set.signingKeyID != "" {
+		token.Header["kid"] = set.signingKeyID
+	}
+
+	return token.SignedString(set.signingKey)
+}
+
+// Parse verifies the token and returns its claims. Key pair tokens are verified with the key
+// named in their 'kid' header, which has to use the algorithm in the token's header.
+func (set *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if set.signingKeyID == "" {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+			}
+
+			return set.signingKey, nil
+		}
+
+		id, _ := token.Header["kid"].(string)
+		key, ok := set.verification[id]
+		if !ok {
+			return nil, fmt.Errorf("Unknown key: %v", token.Header["kid"])
+		}
+
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return key.Public, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys as a JSON Web Key Set, signing key first. HS256 doesn't have
+// public keys, so the set is empty then.
+func (set *KeySet) JWKS() common.JSON {
+	ids := make([]string, 0, len(set.verification))
+	for id := range set.verification {
+		if id != set.signingKeyID {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+
+	keys := []common.JSON{}
+	if set.signingKeyID != "" {
+		keys = append(keys, set.verification[set.signingKeyID].JWK())
+	}
+
+	for _, id := range ids {
+		keys = append(keys, set.verification[id].JWK())
+	}
+
+	return common.JSON{"keys": keys}
+}
@@ -0,0 +1,179 @@
+package lockout
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+// Clock tells the current time. The tracker is given one, so that the waits can be tested
+// without sleeping.
+type Clock func() time.Time
+
+// entry is the failure count of a single username or ip
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	blockedTill time.Time
+	locked      bool
+}
+
+// Lock is returned when a failure locks a username or an ip
+type Lock struct {
+	Key   string
+	Until time.Time
+}
+
+// Tracker counts failed logins for usernames and client ips in memory. Every failure makes
+// the key wait before the next attempt and too many failures lock it, so restarting the
+// server lifts every lock.
+type Tracker struct {
+	settings config.Lockout
+	now      Clock
+
+	mutex     sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// New creates a tracker which reads the time from the clock
+func New(settings config.Lockout, now Clock) *Tracker {
+	return &Tracker{
+		settings:  settings,
+		now:       now,
+		entries:   map[string]*entry{},
+		lastSweep: now(),
+	}
+}
+
+// UserKey is the key failures for the username are counted with. Usernames are compared
+// without case, so changing the case doesn't get around the lock.
+func UserKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// IPKey is the key failures from the client ip are counted with
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// limit returns how many failures lock the key, zero when the key isn't tracked
+func (tracker *Tracker) limit(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return tracker.settings.IPMaxAttempts
+	}
+
+	return tracker.settings.MaxAttempts
+}
+
+// delay is the wait after the given amount of failures, doubled after each one
+func (tracker *Tracker) delay(failures int) time.Duration {
+	delay := tracker.settings.BaseDelay
+	for i := 1; i < failures && delay < tracker.settings.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > tracker.settings.MaxDelay {
+		return tracker.settings.MaxDelay
+	}
+
+	return delay
+}
+
+// sweep forgets the keys whose failures are too old to count and which aren't blocked.
+// The caller holds the mutex.
+func (tracker *Tracker) sweep(now time.Time) {
+	if now.Sub(tracker.lastSweep) < tracker.settings.Window {
+		return
+	}
+
+	for key, entry := range tracker.entries {
+		if now.Sub(entry.lastFailure) > tracker.settings.Window && !now.Before(entry.blockedTill) {
+			delete(tracker.entries, key)
+		}
+	}
+
+	tracker.lastSweep = now
+}
+
+// Wait returns how long the longest waiting of the keys still has to wait before trying
+// again, zero when none of them has to
+func (tracker *Tracker) Wait(keys ...string) time.Duration {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	now := tracker.now()
+	var wait time.Duration
+	for _, key := range keys {
+		entry, ok := tracker.entries[key]
+		if !ok {
+			continue
+		}
+
+		if remaining := entry.blockedTill.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait
+}
+
+// Fail records a failed login for every key and returns the locks the failure caused
+func (tracker *Tracker) Fail(keys ...string) []Lock {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	now := tracker.now()
+	tracker.sweep(now)
+
+	locks := []Lock{}
+	for _, key := range keys {
+		limit := tracker.limit(key)
+		if limit == 0 {
+			continue
+		}
+
+		current, ok := tracker.entries[key]
+		if !ok || now.Sub(current.lastFailure) > tracker.settings.Window || (current.locked && !now.Before(current.blockedTill)) {
+			current = &entry{}
+			tracker.entries[key] = current
+		}
+
+		current.failures++
+		current.lastFailure = now
+
+		if current.failures >= limit {
+			current.locked = true
+			current.blockedTill = now.Add(tracker.settings.Duration)
+			locks = append(locks, Lock{Key: key, Until: current.blockedTill})
+			continue
+		}
+
+		current.blockedTill = now.Add(tracker.delay(current.failures))
+	}
+
+	return locks
+}
+
+// Locked returns when the key's lock ends, if it's locked
+func (tracker *Tracker) Locked(key string) (time.Time, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	entry, ok := tracker.entries[key]
+	if !ok || !entry.locked || !tracker.now().Before(entry.blockedTill) {
+		return time.Time{}, false
+	}
+
+	return entry.blockedTill, true
+}
+
+// Reset forgets the key's failures and lifts its lock
+func (tracker *Tracker) Reset(key string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	delete(tracker.entries, key)
+}
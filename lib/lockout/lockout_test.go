@@ -0,0 +1,157 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+// clock is a fake time which only moves when the test advances it
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+var settings = config.Lockout{
+	MaxAttempts:   4,
+	IPMaxAttempts: 10,
+	Window:        time.Minute * 15,
+	Duration:      time.Minute * 30,
+	BaseDelay:     time.Second,
+	MaxDelay:      time.Second * 3,
+}
+
+func newTracker() (*Tracker, *clock) {
+	fake := &clock{now: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	return New(settings, fake.Now), fake
+}
+
+func TestBackoffDoubles(t *testing.T) {
+	tracker, _ := newTracker()
+	key := UserKey("Someone")
+
+	if wait := tracker.Wait(key); wait != 0 {
+		t.Fatalf("A key without failures shouldn't wait, got %v", wait)
+	}
+
+	// the delay doubles after every failure until the maximum
+	for _, expected := range []time.Duration{time.Second, time.Second * 2, time.Second * 3} {
+		if locks := tracker.Fail(key); len(locks) != 0 {
+			t.Fatalf("Expected no lock before the limit, got %+v", locks)
+		}
+
+		if wait := tracker.Wait(key); wait != expected {
+			t.Errorf("Expected a wait of %v, got %v", expected, wait)
+		}
+	}
+
+	if wait := tracker.Wait(UserKey("someone")); wait != time.Second*3 {
+		t.Errorf("Usernames should be compared without case, got a wait of %v", wait)
+	}
+}
+
+func TestLockAtLimit(t *testing.T) {
+	tracker, fake := newTracker()
+	key := UserKey("someone")
+
+	var locks []Lock
+	for i := 0; i < settings.MaxAttempts; i++ {
+		locks = tracker.Fail(key, IPKey("127.0.0.1"))
+	}
+
+	if len(locks) != 1 || locks[0].Key != key || !locks[0].Until.Equal(fake.now.Add(settings.Duration)) {
+		t.Fatalf("Expected only the username to be locked for the duration, got %+v", locks)
+	}
+
+	if until, locked := tracker.Locked(key); !locked || !until.Equal(locks[0].Until) {
+		t.Errorf("Expected the username to be locked until %v, got %v %v", locks[0].Until, until, locked)
+	}
+
+	if wait := tracker.Wait(key); wait != settings.Duration {
+		t.Errorf("Expected a wait of the lock's duration, got %v", wait)
+	}
+
+	// the lock ends by itself and the next failure starts counting from the beginning
+	fake.advance(settings.Duration)
+	if _, locked := tracker.Locked(key); locked {
+		t.Error("The lock should have ended")
+	}
+
+	if locks := tracker.Fail(key); len(locks) != 0 || tracker.Wait(key) != settings.BaseDelay {
+		t.Errorf("Expected the failures to count from the beginning, got %+v and a wait of %v", locks, tracker.Wait(key))
+	}
+}
+
+func TestWindowExpiry(t *testing.T) {
+	tracker, fake := newTracker()
+	key := UserKey("someone")
+
+	for i := 0; i < settings.MaxAttempts-1; i++ {
+		tracker.Fail(key)
+	}
+
+	// failures older than the window don't count towards the lock
+	fake.advance(settings.Window + time.Second)
+	if locks := tracker.Fail(key); len(locks) != 0 {
+		t.Fatalf("Old failures shouldn't lock the key, got %+v", locks)
+	}
+
+	if wait := tracker.Wait(key); wait != settings.BaseDelay {
+		t.Errorf("Expected the first failure's wait, got %v", wait)
+	}
+}
+
+func TestReset(t *testing.T) {
+	tracker, _ := newTracker()
+	key := UserKey("someone")
+
+	for i := 0; i < settings.MaxAttempts; i++ {
+		tracker.Fail(key, IPKey("127.0.0.1"))
+	}
+
+	tracker.Reset(key)
+	if _, locked := tracker.Locked(key); locked {
+		t.Error("Reset should lift the lock")
+	}
+
+	if wait := tracker.Wait(key); wait != 0 {
+		t.Errorf("Reset should forget the failures, got a wait of %v", wait)
+	}
+
+	if wait := tracker.Wait(IPKey("127.0.0.1")); wait == 0 {
+		t.Error("Resetting the username shouldn't reset the ip")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	tracker, fake := newTracker()
+
+	for i := 0; i < settings.MaxAttempts; i++ {
+		tracker.Fail(UserKey("locked"))
+	}
+	tracker.Fail(UserKey("old"))
+
+	// a failure after the window sweeps the old key, but the lock outlasts the window
+	fake.advance(settings.Window + time.Second)
+	tracker.Fail(UserKey("new"))
+
+	if _, ok := tracker.entries[UserKey("old")]; ok {
+		t.Error("The old failure should have been swept")
+	}
+
+	if _, ok := tracker.entries[UserKey("locked")]; !ok {
+		t.Error("The locked key shouldn't be swept before the lock ends")
+	}
+
+	if _, ok := tracker.entries[UserKey("new")]; !ok {
+		t.Error("The new failure should be tracked")
+	}
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// LogMailer writes the emails to a file instead of sending them, so that the links in them
+// can be followed during local development and in tests. The emails are printed when there's
+// no file.
+type LogMailer struct {
+	from  string
+	path  string
+	mutex sync.Mutex
+}
+
+// NewLogMailer creates a mailer which appends the emails to the file at path
+func NewLogMailer(from, path string) *LogMailer {
+	return &LogMailer{from: from, path: path}
+}
+
+// Send appends the message to the file, separated from the previous ones by a line of dashes
+func (mailer *LogMailer) Send(message Message) error {
+	entry := strings.ReplaceAll(format(mailer.from, message), "\r\n", "\n") + "\n" + strings.Repeat("-", 72) + "\n"
+
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	if mailer.path == "" {
+		fmt.Print(entry)
+		return nil
+	}
+
+	file, err := os.OpenFile(mailer.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(entry); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(message Message) error
+}
+
+// New creates the mailer picked in the settings
+func New(settings config.Mail) (Mailer, error) {
+	switch settings.Driver {
+	case "smtp":
+		return NewSMTPMailer(settings), nil
+	case "log":
+		return NewLogMailer(settings.From, settings.File), nil
+	}
+
+	return nil, fmt.Errorf("Unknown mailer: %s", settings.Driver)
+}
+
+// headerValue removes line breaks, so that a value can't add headers of its own
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// format writes the message with its headers
+func format(from string, message Message) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&builder, "To: %s\r\n", headerValue(message.To))
+	fmt.Fprintf(&builder, "Subject: %s\r\n", headerValue(message.Subject))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return builder.String()
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"fmt"
+	"net/mail"
+	"net/smtp"
+
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+// SMTPMailer sends emails through an SMTP server. The connection is upgraded with STARTTLS
+// when the server supports it, and the credentials are only sent over TLS or to localhost.
+type SMTPMailer struct {
+	address  string
+	from     string
+	envelope string
+	auth     smtp.Auth
+}
+
+// NewSMTPMailer creates a mailer for the server in the settings. The server is only contacted
+// when an email is sent.
+func NewSMTPMailer(settings config.Mail) *SMTPMailer {
+	mailer := &SMTPMailer{
+		address:  fmt.Sprintf("%s:%d", settings.Host, settings.Port),
+		from:     settings.From,
+		envelope: settings.From,
+	}
+
+	// the from setting can include a name, but the server only wants the address
+	if address, err := mail.ParseAddress(settings.From); err == nil {
+		mailer.envelope = address.Address
+	}
+
+	if settings.Username != "" {
+		mailer.auth = smtp.PlainAuth("", settings.Username, settings.Password, settings.Host)
+	}
+
+	return mailer
+}
+
+// Send delivers the message to the SMTP server
+func (mailer *SMTPMailer) Send(message Message) error {
+	to := headerValue(message.To)
+	return smtp.SendMail(mailer.address, mailer.auth, mailer.envelope, []string{to}, []byte(format(mailer.from, message)))
+}
@@ -4,13 +4,70 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/policy"
 )
 
-// Authorized is used for blocking unauthorized requests
+// Authorized is used for blocking unauthorized requests. Personal access tokens are only
+// accepted when Scope has granted them the route's scope.
 func Authorized(c *gin.Context) {
-	_, exists := c.Get("user")
-	if !exists {
-		c.AbortWithStatus(http.StatusForbidden)
+	if CurrentUser(c) != nil {
+		return
+	}
+
+	if _, personal := c.Get("personal_token"); personal {
+		message := "Personal access tokens can't be used here"
+		if scope, ok := c.Get("required_scope"); ok {
+			message = "The token doesn't have the " + string(scope.(policy.Scope)) + " scope"
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, common.JSON{"error": message})
 		return
 	}
+
+	c.AbortWithStatus(http.StatusForbidden)
+}
+
+// Scope creates a middleware which lets personal access tokens with the scope act as their
+// user on the route. Other tokens stay anonymous, so Authorized rejects them.
+func Scope(scope policy.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawToken, exists := c.Get("personal_token")
+		if !exists {
+			return
+		}
+
+		c.Set("required_scope", scope)
+		token := rawToken.(PersonalToken)
+		if token.HasScope(string(scope)) {
+			c.Set("scope_granted", true)
+		}
+	}
+}
+
+// Require creates a middleware which blocks requests from users whose role doesn't grant the
+// permission
+func Require(permission policy.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !policy.Has(CurrentUser(c), permission) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	}
+}
+
+// CurrentUser returns the authenticated user or nil if the request is anonymous. Requests
+// with a personal access token count as anonymous on routes which don't ask for a scope.
+func CurrentUser(c *gin.Context) *User {
+	rawUser, exists := c.Get("user")
+	if !exists {
+		return nil
+	}
+
+	if _, personal := c.Get("personal_token"); personal && !c.GetBool("scope_granted") {
+		return nil
+	}
+
+	user := rawUser.(User)
+	return &user
 }
@@ -2,40 +2,30 @@ package middlewares
 
 import (
 	"errors"
-	"fmt"
+	"log"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
 	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/lib/keys"
 )
 
-var secretKey []byte
-
 // User model alias
 type User = models.User
 
-func init() {
-	secretKey = []byte("temp")
-}
+// PersonalToken model alias
+type PersonalToken = models.PersonalToken
 
-func validateToken(tokenString string) (common.JSON, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
-	if err != nil {
-		return common.JSON{}, err
-	}
-	if !token.Valid {
-		return common.JSON{}, errors.New("invalid token")
-	}
-	return token.Claims.(jwt.MapClaims), nil
-}
+// Session model alias
+type Session = models.Session
+
+// lastUsedPrecision is how often the last use of a session or a personal access token is
+// written down, so that clients making many requests don't write on every one of them
+const lastUsedPrecision = time.Minute
 
 func extractTokenFromAuthorizationHeader(c *gin.Context) (string, error) {
 	authorization := c.Request.Header.Get("Authorization")
@@ -55,8 +45,68 @@ func extractTokenFromAuthorizationHeader(c *gin.Context) (string, error) {
 	}
 }
 
-// JWTMiddleware parses jwt token from cookie/header
-func JWTMiddleware() gin.HandlerFunc {
+// tokenVersion reads the token version claim. Tokens created before versions were added
+// don't have one, so they count as the first version.
+func tokenVersion(claims common.JSON) int {
+	version, _ := claims["ver"].(float64)
+	return int(version)
+}
+
+// personalTokenUser finds the active personal access token and its user
+func personalTokenUser(tokenString string, users store.UserStore, tokens store.PersonalTokenStore) (PersonalToken, User, error) {
+	token, err := tokens.FindByHash(common.HashToken(tokenString))
+	if err != nil {
+		return token, User{}, err
+	}
+
+	now := time.Now()
+	if !token.Active(now) {
+		return token, User{}, errors.New("Token has expired")
+	}
+
+	user, err := users.FindByID(token.UserID)
+	if err != nil {
+		return token, user, err
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedPrecision {
+		if err := tokens.Touch(&token, now); err != nil {
+			log.Println("Failed to record the use of personal token", token.ID, err)
+		}
+	}
+
+	return token, user, nil
+}
+
+// tokenSession loads the session in the token's sid claim, unless it has been revoked or has
+// expired. Tokens created before sessions were recorded don't have one, so they're accepted
+// without a session until they expire.
+func tokenSession(claims common.JSON, user User, sessions store.SessionStore) (*Session, bool) {
+	id, ok := claims["sid"].(float64)
+	if !ok {
+		return nil, true
+	}
+
+	session, err := sessions.FindByID(uint(id))
+	now := time.Now()
+	if err != nil || session.UserID != user.ID || !session.Active(now) {
+		return nil, false
+	}
+
+	if now.Sub(session.LastSeenAt) >= lastUsedPrecision {
+		if err := sessions.Touch(&session, now); err != nil {
+			log.Println("Failed to record the use of session", session.ID, err)
+		}
+	}
+
+	return &session, true
+}
+
+// JWTMiddleware parses jwt token from cookie/header. The token's user and session are loaded
+// from the stores, so tokens of deleted or suspended users, of revoked sessions and tokens
+// revoked by bumping the user's token version are ignored. Personal access tokens are
+// accepted in the header as well, and Scope decides which routes they can use.
+func JWTMiddleware(keySet *keys.KeySet, stores store.Stores) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString, err := c.Cookie("token")
 		if err != nil {
@@ -69,14 +119,49 @@ func JWTMiddleware() gin.HandlerFunc {
 			tokenString = authTokenString
 		}
 
-		tokenData, err := validateToken(tokenString)
+		if strings.HasPrefix(tokenString, models.PersonalTokenPrefix) {
+			token, user, err := personalTokenUser(tokenString, stores.Users, stores.PersonalTokens)
+			if err != nil || user.Suspended() {
+				c.Next()
+				return
+			}
+
+			c.Set("user", user)
+			c.Set("personal_token", token)
+			c.Next()
+			return
+		}
+
+		tokenData, err := keySet.Parse(tokenString)
 		if err != nil {
 			c.Next()
 			return
 		}
 
-		var user User
-		user.Read(tokenData["user"].(common.JSON))
+		claims, ok := tokenData["user"].(map[string]interface{})
+		if !ok {
+			c.Next()
+			return
+		}
+
+		var tokenUser User
+		tokenUser.Read(claims)
+		user, err := stores.Users.FindByID(tokenUser.ID)
+		if err != nil || tokenVersion(tokenData) != user.TokenVersion || user.Suspended() {
+			c.Next()
+			return
+		}
+
+		session, ok := tokenSession(tokenData, user, stores.Sessions)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if session != nil {
+			c.Set("session", *session)
+		}
+
 		c.Set("user", user)
 		c.Set("token_expire", tokenData["exp"])
 		c.Next()
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/lib/config"
+	"golang.org/x/time/rate"
+)
+
+// limiterIdle is how long a client's limiter is kept after its last request
+const limiterIdle = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimit limits the requests every client ip can send. Clients going over the limit get
+// a 429 response. A zero rate disables the limit.
+func RateLimit(settings config.RateLimit) gin.HandlerFunc {
+	if settings.RequestsPerSecond == 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	var mutex sync.Mutex
+	clients := map[string]*clientLimiter{}
+	lastSweep := time.Now()
+
+	return func(c *gin.Context) {
+		now := time.Now()
+
+		mutex.Lock()
+		// forget the clients which have stopped sending requests
+		if now.Sub(lastSweep) > limiterIdle {
+			for ip, client := range clients {
+				if now.Sub(client.lastSeen) > limiterIdle {
+					delete(clients, ip)
+				}
+			}
+
+			lastSweep = now
+		}
+
+		client, ok := clients[c.ClientIP()]
+		if !ok {
+			client = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(settings.RequestsPerSecond), settings.Burst)}
+			clients[c.ClientIP()] = client
+		}
+
+		client.lastSeen = now
+		allowed := client.limiter.AllowN(now, 1)
+		mutex.Unlock()
+
+		if !allowed {
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,19 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nireo/go-blog-api/database/store"
+)
+
+// InjectStores makes the stores available to the handlers through Stores
+func InjectStores(stores store.Stores) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("stores", stores)
+		c.Next()
+	}
+}
+
+// Stores returns the stores injected by InjectStores
+func Stores(c *gin.Context) store.Stores {
+	return c.MustGet("stores").(store.Stores)
+}
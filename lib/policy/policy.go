@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"github.com/nireo/go-blog-api/database/models"
+)
+
+// User model alias
+type User = models.User
+
+// Post model alias
+type Post = models.Post
+
+// Paragraph model alias
+type Paragraph = models.Paragraph
+
+// Topic model alias
+type Topic = models.Topic
+
+// Comment model alias
+type Comment = models.Comment
+
+// Action is something a user tries to do to a resource
+type Action string
+
+// The actions the policies answer for. Suspend, ChangeRole and Unlock only apply to users.
+const (
+	Read       Action = "read"
+	Update     Action = "update"
+	Delete     Action = "delete"
+	Suspend    Action = "suspend"
+	ChangeRole Action = "change_role"
+	Unlock     Action = "unlock"
+)
+
+// Permission is granted to every user with a role
+type Permission string
+
+// ModerateContent lets a user remove other users' posts, topics and comments, and ManageUsers
+// lets them list, suspend and promote users.
+const (
+	ModerateContent Permission = "moderate_content"
+	ManageUsers     Permission = "manage_users"
+)
+
+// rolePermissions lists what each role is allowed to do on top of managing their own content
+var rolePermissions = map[string][]Permission{
+	models.RoleUser:      {},
+	models.RoleModerator: {ModerateContent},
+	models.RoleAdmin:     {ModerateContent, ManageUsers},
+}
+
+// Has checks if the user's role grants the permission. The user can be nil for anonymous
+// requests and suspended users don't have any permissions.
+func Has(user *User, permission Permission) bool {
+	if user == nil || user.Suspended() {
+		return false
+	}
+
+	for _, granted := range rolePermissions[user.Role] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// owns checks if the user is the one with the owner's id
+func owns(user *User, ownerID uint) bool {
+	return user != nil && !user.Suspended() && user.ID == ownerID
+}
+
+// CanPost checks if the user can do the action on the post. Published posts can be read by
+// anyone, but only the author sees the other states and edits the post. Moderators can
+// delete posts as well.
+func CanPost(user *User, action Action, post Post) bool {
+	switch action {
+	case Read:
+		return post.Status == models.PostStatusPublished || owns(user, post.UserID)
+	case Update:
+		return owns(user, post.UserID)
+	case Delete:
+		return owns(user, post.UserID) || Has(user, ModerateContent)
+	}
+
+	return false
+}
+
+// CanParagraph checks if the user can do the action on the paragraph, which is decided by the
+// post it is in
+func CanParagraph(user *User, action Action, paragraph Paragraph, post Post) bool {
+	return paragraph.PostID == post.ID && CanPost(user, action, post)
+}
+
+// CanTopic checks if the user can do the action on the topic. Topics are public, but only
+// the creator can edit them.
+func CanTopic(user *User, action Action, topic Topic) bool {
+	switch action {
+	case Read:
+		return true
+	case Update:
+		return owns(user, topic.UserID)
+	case Delete:
+		return owns(user, topic.UserID) || Has(user, ModerateContent)
+	}
+
+	return false
+}
+
+// CanComment checks if the user can do the action on a comment in the post. Comments can be
+// read by anyone who can read the post.
+func CanComment(user *User, action Action, comment Comment, post Post) bool {
+	if comment.PostID != post.ID {
+		return false
+	}
+
+	switch action {
+	case Read:
+		return CanPost(user, Read, post)
+	case Update:
+		return owns(user, comment.UserID)
+	case Delete:
+		return owns(user, comment.UserID) || Has(user, ModerateContent)
+	}
+
+	return false
+}
+
+// CanUser checks if the user can do the action on the target user. Admins can't suspend
+// themselves or change their own role, so there is always someone left to undo it. Lifting
+// a login lockout is allowed on anyone.
+func CanUser(user *User, action Action, target User) bool {
+	switch action {
+	case Suspend, ChangeRole:
+		return Has(user, ManageUsers) && user.ID != target.ID
+	case Unlock:
+		return Has(user, ManageUsers)
+	}
+
+	return false
+}
@@ -0,0 +1,29 @@
+package policy
+
+// Scope limits what a personal access token can be used for. Tokens only work on the routes
+// which ask for a scope the token has, and sessions from logging in can use every route.
+type Scope string
+
+// The scopes personal access tokens can be given. Comments and likes are read with the posts
+// they belong to.
+const (
+	PostsRead     Scope = "posts:read"
+	PostsWrite    Scope = "posts:write"
+	CommentsWrite Scope = "comments:write"
+	TopicsRead    Scope = "topics:read"
+	TopicsWrite   Scope = "topics:write"
+)
+
+// Scopes lists every scope in the order they are documented
+var Scopes = []Scope{PostsRead, PostsWrite, CommentsWrite, TopicsRead, TopicsWrite}
+
+// ValidScope checks if the scope is one of the scopes
+func ValidScope(scope string) bool {
+	for _, valid := range Scopes {
+		if string(valid) == scope {
+			return true
+		}
+	}
+
+	return false
+}
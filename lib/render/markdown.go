@@ -0,0 +1,144 @@
+package render
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nireo/go-blog-api/database/models"
+)
+
+var backtickRuns = regexp.MustCompile("`+")
+
+// markdownEscapes backslash escapes the characters which start inline Markdown anywhere in a
+// line
+var markdownEscapes = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "#", `\#`,
+	"!", `\!`, "<", `\<`, ">", `\>`, "&", `\&`, "|", `\|`, "~", `\~`,
+)
+
+// bulletMarkers and orderedMarkers match the list and heading markers, which only mean
+// something at the start of a line
+var (
+	bulletMarkers  = regexp.MustCompile(`(?m)^(\s*)([-+=])`)
+	orderedMarkers = regexp.MustCompile(`(?m)^(\s*\d+)([.)])`)
+)
+
+// urlEscapes percent-encodes the characters which could end a link destination inside <…>
+var urlEscapes = strings.NewReplacer(
+	"<", "%3C", ">", "%3E", `\`, "%5C", " ", "%20", "\t", "%09", "\n", "%0A", "\r", "%0D",
+)
+
+// PostMarkdown renders the post's title, description and paragraphs into Markdown
+func PostMarkdown(post models.Post, paragraphs []models.Paragraph) string {
+	blocks := []string{"# " + escapeText(singleLine(post.Title))}
+	if post.Description != "" {
+		blocks = append(blocks, "*"+escapeText(singleLine(post.Description))+"*")
+	}
+
+	for index := range paragraphs {
+		blocks = append(blocks, paragraphMarkdown(paragraphs[index]))
+	}
+
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+// Markdown renders the paragraphs into Markdown
+func Markdown(paragraphs []models.Paragraph) string {
+	blocks := make([]string, len(paragraphs), len(paragraphs))
+	for index := range paragraphs {
+		blocks[index] = paragraphMarkdown(paragraphs[index])
+	}
+
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+func paragraphMarkdown(paragraph models.Paragraph) string {
+	block := paragraph.Block()
+	data, _ := block["data"].(map[string]interface{})
+
+	switch block["type"] {
+	case "heading":
+		level := intField(data, "level")
+		if level < 1 || level > 6 {
+			level = 2
+		}
+
+		return strings.Repeat("#", level) + " " + escapeText(singleLine(stringField(data, "text")))
+	case "code":
+		code := stringField(data, "code")
+
+		// the fence has to be longer than any run of backticks inside the code
+		fence := "```"
+		for _, run := range backtickRuns.FindAllString(code, -1) {
+			if len(run) >= len(fence) {
+				fence = strings.Repeat("`", len(run)+1)
+			}
+		}
+
+		// the info string can't contain backticks or line breaks
+		language := singleLine(strings.Replace(stringField(data, "language"), "`", "", -1))
+		return fence + language + "\n" + code + "\n" + fence
+	case "quote":
+		lines := strings.Split(escapeText(stringField(data, "text")), "\n")
+		if cite := stringField(data, "cite"); cite != "" {
+			lines = append(lines, "", "— "+escapeText(singleLine(cite)))
+		}
+
+		for index := range lines {
+			lines[index] = strings.TrimRight("> "+lines[index], " ")
+		}
+
+		return strings.Join(lines, "\n")
+	case "image":
+		image := fmt.Sprintf("![%s](<%s>)", escapeText(singleLine(stringField(data, "alt"))), escapeURL(stringField(data, "url")))
+		if caption := stringField(data, "caption"); caption != "" {
+			image += "\n*" + escapeText(singleLine(caption)) + "*"
+		}
+
+		return image
+	case "list":
+		ordered, _ := data["ordered"].(bool)
+		items := listField(data, "items")
+		lines := make([]string, len(items), len(items))
+		for index := range items {
+			marker := "-"
+			if ordered {
+				marker = fmt.Sprintf("%d.", index+1)
+			}
+
+			lines[index] = marker + " " + escapeText(singleLine(items[index]))
+		}
+
+		return strings.Join(lines, "\n")
+	case "embed":
+		link := escapeURL(stringField(data, "url"))
+		if provider := stringField(data, "provider"); provider != "" {
+			return fmt.Sprintf("[%s](<%s>)", escapeText(singleLine(provider)), link)
+		}
+
+		return "<" + link + ">"
+	}
+
+	return escapeText(paragraph.Content)
+}
+
+// singleLine joins the lines of text which has to fit on a single Markdown line
+func singleLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// escapeText keeps Markdown renderers from treating user text as formatting, links or inline
+// HTML. Only the list markers at the start of a line need escaping, a dash inside a sentence
+// is left alone.
+func escapeText(text string) string {
+	text = markdownEscapes.Replace(text)
+	text = bulletMarkers.ReplaceAllString(text, `$1\$2`)
+	return orderedMarkers.ReplaceAllString(text, `$1\$2`)
+}
+
+// escapeURL keeps a link inside its <…> destination, the blocks only accept http and https
+// addresses so encoding the characters doesn't change where the link goes
+func escapeURL(link string) string {
+	return urlEscapes.Replace(link)
+}
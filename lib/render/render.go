@@ -0,0 +1,210 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/nireo/go-blog-api/database/models"
+)
+
+// highlightStyle is the chroma style used for code blocks. The colors are written as inline
+// styles, so the output looks the same in RSS readers and emails which don't load stylesheets.
+const highlightStyle = "github"
+
+var policy = newPolicy()
+
+// newPolicy allows the elements produced by the renderer and nothing else. Only the styles
+// written by the syntax highlighter are allowed.
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+
+	p.AllowElements("p", "br", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote", "cite",
+		"pre", "code", "span", "ul", "ol", "li", "figure", "figcaption", "article", "header")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("src", "alt").OnElements("img")
+	p.AllowURLSchemes("http", "https")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-zA-Z0-9+#-]+$`)).OnElements("code")
+	p.AllowStyles("color", "background-color", "font-weight", "font-style", "text-decoration").
+		OnElements("span", "pre")
+
+	return p
+}
+
+// Sanitize removes everything from the HTML which isn't on the allow-list
+func Sanitize(unsafe string) string {
+	return policy.Sanitize(unsafe)
+}
+
+// PostHTML renders the post's title, description and paragraphs into sanitized HTML
+func PostHTML(post models.Post, paragraphs []models.Paragraph) string {
+	var builder strings.Builder
+
+	builder.WriteString("<article><header>")
+	fmt.Fprintf(&builder, "<h1>%s</h1>", html.EscapeString(post.Title))
+	if post.Description != "" {
+		fmt.Fprintf(&builder, "<p>%s</p>", html.EscapeString(post.Description))
+	}
+	builder.WriteString("</header>")
+
+	for index := range paragraphs {
+		builder.WriteString(paragraphHTML(paragraphs[index]))
+	}
+
+	builder.WriteString("</article>")
+	return Sanitize(builder.String())
+}
+
+// HTML renders the paragraphs into sanitized HTML
+func HTML(paragraphs []models.Paragraph) string {
+	var builder strings.Builder
+	for index := range paragraphs {
+		builder.WriteString(paragraphHTML(paragraphs[index]))
+	}
+
+	return Sanitize(builder.String())
+}
+
+func paragraphHTML(paragraph models.Paragraph) string {
+	block := paragraph.Block()
+	data, _ := block["data"].(map[string]interface{})
+
+	switch block["type"] {
+	case "heading":
+		level := intField(data, "level")
+		if level < 1 || level > 6 {
+			level = 2
+		}
+
+		return fmt.Sprintf("<h%d>%s</h%d>", level, html.EscapeString(stringField(data, "text")), level)
+	case "code":
+		return highlight(stringField(data, "code"), stringField(data, "language"))
+	case "quote":
+		quote := "<blockquote><p>" + multiline(stringField(data, "text")) + "</p>"
+		if cite := stringField(data, "cite"); cite != "" {
+			quote += "<cite>" + html.EscapeString(cite) + "</cite>"
+		}
+
+		return quote + "</blockquote>"
+	case "image":
+		image := fmt.Sprintf(`<figure><img src="%s" alt="%s">`,
+			html.EscapeString(stringField(data, "url")), html.EscapeString(stringField(data, "alt")))
+		if caption := stringField(data, "caption"); caption != "" {
+			image += "<figcaption>" + html.EscapeString(caption) + "</figcaption>"
+		}
+
+		return image + "</figure>"
+	case "list":
+		tag := "ul"
+		if ordered, _ := data["ordered"].(bool); ordered {
+			tag = "ol"
+		}
+
+		var builder strings.Builder
+		builder.WriteString("<" + tag + ">")
+		for _, item := range listField(data, "items") {
+			builder.WriteString("<li>" + html.EscapeString(item) + "</li>")
+		}
+
+		builder.WriteString("</" + tag + ">")
+		return builder.String()
+	case "embed":
+		// embeds are rendered as links, since iframes can't be sanitized
+		link := stringField(data, "url")
+		title := stringField(data, "provider")
+		if title == "" {
+			title = link
+		}
+
+		return fmt.Sprintf(`<p><a href="%s">%s</a></p>`, html.EscapeString(link), html.EscapeString(title))
+	}
+
+	return "<p>" + multiline(paragraph.Content) + "</p>"
+}
+
+// highlight renders the code with inline colors. Unknown languages are guessed from the code.
+func highlight(code, language string) string {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, code)
+	if err != nil {
+		return plainCode(code, language)
+	}
+
+	var buffer bytes.Buffer
+	formatter := chromahtml.New(chromahtml.PreventSurroundingPre(true))
+	if err := formatter.Format(&buffer, styles.Get(highlightStyle), iterator); err != nil {
+		return plainCode(code, language)
+	}
+
+	return fmt.Sprintf(`<pre><code class="%s">%s</code></pre>`, languageClass(language), buffer.String())
+}
+
+func plainCode(code, language string) string {
+	return fmt.Sprintf(`<pre><code class="%s">%s</code></pre>`, languageClass(language), html.EscapeString(code))
+}
+
+func languageClass(language string) string {
+	if language == "" {
+		return "language-plaintext"
+	}
+
+	return "language-" + html.EscapeString(language)
+}
+
+// multiline escapes the text and keeps its line breaks
+func multiline(text string) string {
+	return strings.Replace(html.EscapeString(text), "\n", "<br>", -1)
+}
+
+func stringField(data map[string]interface{}, name string) string {
+	value, _ := data[name].(string)
+	return value
+}
+
+func intField(data map[string]interface{}, name string) int {
+	switch value := data[name].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	}
+
+	return 0
+}
+
+func listField(data map[string]interface{}, name string) []string {
+	switch value := data[name].(type) {
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for index := range value {
+			if item, ok := value[index].(string); ok {
+				items = append(items, item)
+			}
+		}
+
+		return items
+	case []string:
+		return value
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/nireo/go-blog-api/database/store"
+)
+
+// Run publishes scheduled posts every interval. It blocks, so it should be started in its own goroutine.
+func Run(posts store.PostStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		publishDuePosts(posts)
+		<-ticker.C
+	}
+}
+
+func publishDuePosts(posts store.PostStore) {
+	published, err := posts.PublishDue(time.Now().UTC())
+	if err != nil {
+		log.Println("Failed to publish scheduled posts", err)
+		return
+	}
+
+	if published > 0 {
+		log.Printf("Published %d scheduled posts", published)
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage keeps the files in a directory, which the server serves under the base url
+type LocalStorage struct {
+	directory string
+	baseURL   string
+}
+
+// NewLocalStorage creates a storage writing to the directory. The directory is created when
+// the first file is saved.
+func NewLocalStorage(directory, baseURL string) *LocalStorage {
+	return &LocalStorage{
+		directory: directory,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// Save writes the content to a temporary file first and renames it in place, so that a
+// failed upload never leaves half a file behind
+func (storage *LocalStorage) Save(key string, content io.Reader) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	destination := filepath.Join(storage.directory, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(destination), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), destination)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return storage.baseURL + "/" + key, nil
+}
+
+// Delete removes the file
+func (storage *LocalStorage) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(storage.directory, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+// ErrInvalidKey is returned for keys which would point outside of the storage
+var ErrInvalidKey = errors.New("Invalid storage key")
+
+// Storage keeps uploaded files. Files are identified by keys like avatars/<uuid>.png, which
+// are also the end of their public url.
+type Storage interface {
+	// Save writes the content under the key and returns the file's public url
+	Save(key string, content io.Reader) (string, error)
+	// Delete removes the file, removing a missing file isn't an error
+	Delete(key string) error
+}
+
+// New creates the storage picked in the settings
+func New(settings config.Storage) (Storage, error) {
+	switch settings.Driver {
+	case "local":
+		return NewLocalStorage(settings.Directory, settings.URL), nil
+	}
+
+	return nil, fmt.Errorf("Unknown storage: %s", settings.Driver)
+}
+
+// cleanKey makes sure the key is a relative path which stays inside the storage
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != key || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+
+	return cleaned, nil
+}
@@ -0,0 +1,72 @@
+package syndication
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// CacheTTL is how long a rendered feed is served before it's built again
+const CacheTTL = 5 * time.Minute
+
+// Entry is a cached feed together with its validators
+type Entry struct {
+	Feed
+	ETag    string
+	expires time.Time
+}
+
+// Cache keeps rendered feeds in memory, so readers polling the feeds don't hit the database
+type Cache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]Entry
+}
+
+// NewCache creates an empty cache which keeps feeds for the given duration
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		entries: map[string]Entry{},
+	}
+}
+
+// Get returns the feed cached with the key, unless it has expired
+func (cache *Cache) Get(key string) (Entry, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(cache.entries, key)
+		return Entry{}, false
+	}
+
+	return entry, true
+}
+
+// Set caches the feed and returns the entry with its ETag
+func (cache *Cache) Set(key string, feed Feed) Entry {
+	sum := sha1.Sum(feed.Body)
+	entry := Entry{
+		Feed:    feed,
+		ETag:    `"` + hex.EncodeToString(sum[:]) + `"`,
+		expires: time.Now().Add(cache.ttl),
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	// expired entries are only removed when they're read, so sweep them here to keep
+	// feeds of removed users and topics from piling up
+	now := time.Now()
+	for cachedKey, cached := range cache.entries {
+		if now.After(cached.expires) {
+			delete(cache.entries, cachedKey)
+		}
+	}
+
+	cache.entries[key] = entry
+	return entry
+}
@@ -0,0 +1,132 @@
+package syndication
+
+import (
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/render"
+)
+
+// FeedSize is the amount of the newest posts included in a feed
+const FeedSize = 20
+
+// The formats a feed can be written in
+const (
+	FormatRSS  = "rss"
+	FormatAtom = "atom"
+)
+
+// Channel describes the feed itself, the posts are added as its items
+type Channel struct {
+	Title       string
+	Description string
+	// Link is the absolute url of the page the feed follows
+	Link string
+}
+
+// Feed is a rendered feed ready to be sent to readers
+type Feed struct {
+	Body         []byte
+	ContentType  string
+	LastModified time.Time
+}
+
+// ValidFormat checks if the feed can be written in the given format
+func ValidFormat(format string) bool {
+	return format == FormatRSS || format == FormatAtom
+}
+
+// Build renders the posts into a feed, loading their authors and paragraphs from the stores.
+// Links to the posts are created under baseURL, which is the absolute url of the site
+// without a trailing slash.
+func Build(stores store.Stores, channel Channel, posts []models.Post, baseURL, format string) (Feed, bool) {
+	var result Feed
+
+	authorIDs := make([]uint, len(posts), len(posts))
+	for index := range posts {
+		authorIDs[index] = posts[index].UserID
+	}
+
+	authors, err := stores.Users.FindByIDs(authorIDs)
+	if err != nil {
+		return result, false
+	}
+
+	feed := &feeds.Feed{
+		Id:          channel.Link,
+		Title:       channel.Title,
+		Description: channel.Description,
+		Link:        &feeds.Link{Href: channel.Link},
+	}
+
+	for index := range posts {
+		post := posts[index]
+		paragraphs, err := stores.Posts.Paragraphs(post)
+		if err != nil {
+			return result, false
+		}
+
+		item := &feeds.Item{
+			Id:          "urn:uuid:" + post.UUID,
+			Title:       post.Title,
+			Link:        &feeds.Link{Href: baseURL + "/post/" + post.UUID},
+			Description: post.Description,
+			Content:     render.HTML(paragraphs),
+			Created:     publishedAt(post),
+			Updated:     modifiedAt(post),
+		}
+
+		if author, ok := authors[post.UserID]; ok {
+			item.Author = &feeds.Author{Name: author.Username}
+		}
+
+		feed.Add(item)
+		if modified := modifiedAt(post); modified.After(result.LastModified) {
+			result.LastModified = modified
+		}
+	}
+
+	// an empty feed still needs a date, so readers don't see it changing on every request
+	if result.LastModified.IsZero() {
+		result.LastModified = time.Unix(0, 0)
+	}
+
+	feed.Updated = result.LastModified
+
+	var body string
+	if format == FormatAtom {
+		body, err = feed.ToAtom()
+		result.ContentType = "application/atom+xml; charset=utf-8"
+	} else {
+		body, err = feed.ToRss()
+		result.ContentType = "application/rss+xml; charset=utf-8"
+	}
+
+	if err != nil {
+		return result, false
+	}
+
+	result.Body = []byte(body)
+	return result, true
+}
+
+// publishedAt is the time the post became visible to readers
+func publishedAt(post models.Post) time.Time {
+	if post.PublishAt != nil {
+		return *post.PublishAt
+	}
+
+	return post.CreatedAt
+}
+
+// modifiedAt is the last time the post changed for readers. Publishing a scheduled post
+// doesn't touch its update time, so the publish time counts too.
+func modifiedAt(post models.Post) time.Time {
+	if published := publishedAt(post); published.After(post.UpdatedAt) {
+		return published
+	}
+
+	return post.UpdatedAt
+}
@@ -1,22 +1,95 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
 	"github.com/nireo/go-blog-api/api"
-	"github.com/nireo/go-blog-api/lib/common"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/config"
+	"github.com/nireo/go-blog-api/lib/identity"
+	"github.com/nireo/go-blog-api/lib/keys"
+	"github.com/nireo/go-blog-api/lib/lockout"
+	"github.com/nireo/go-blog-api/lib/mailer"
 	"github.com/nireo/go-blog-api/lib/middlewares"
+	"github.com/nireo/go-blog-api/lib/scheduler"
+	"github.com/nireo/go-blog-api/lib/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nireo/go-blog-api/database"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "path to a YAML or TOML config file")
+	flag.Parse()
+
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(*configPath, flag.Args()[1:]); err != nil {
+			log.Fatalln(err)
+		}
+
+		return
+	}
+
+	if flag.Arg(0) == "role" {
+		if err := runRole(*configPath, flag.Args()[1:]); err != nil {
+			log.Fatalln(err)
+		}
+
+		return
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalln("Invalid configuration:", err)
+	}
+
+	keySet, err := keys.Load(cfg.JWT)
+	if err != nil {
+		log.Fatalln("Could not load the token signing keys:", err)
+	}
+
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatalln("Could not create the mailer:", err)
+	}
+
+	uploads, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatalln("Could not create the storage:", err)
+	}
+
 	// start database
-	db, _ := database.Initialize()
+	db, err := database.Initialize(cfg.Database)
+	if err != nil {
+		log.Fatalln("Could not open the database:", err)
+	}
+
+	stores := store.NewGormStores(db)
+
+	// publish scheduled posts in the background
+	go scheduler.Run(stores.Posts, time.Minute)
+
+	if cfg.Log.Level != "debug" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	app := gin.New() // create gin app
+	if err := app.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalln("Invalid trusted proxies:", err)
+	}
 
-	common.SetDatabase(db)
+	if cfg.Log.Verbose() {
+		app.Use(gin.Logger())
+	}
 
-	app := gin.Default() // create gin app
-	app.Use(middlewares.JWTMiddleware())
-	api.ApplyRoutes(app) // apply api router
-	app.Run(":8080")     // listen to given port
+	app.Use(gin.Recovery())
+	app.Use(middlewares.CORS(cfg.CORS))
+	app.Use(middlewares.RateLimit(cfg.RateLimit))
+	app.Use(middlewares.JWTMiddleware(keySet, stores))
+	guard := lockout.New(cfg.Lockout, time.Now)
+	api.ApplyRoutes(app, cfg, keySet, stores, mail, identity.Load(cfg.OIDC), guard, uploads) // apply api router
+	app.Run(cfg.Server.Address)                                                              // listen to given address
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nireo/go-blog-api/database"
+	"github.com/nireo/go-blog-api/database/migrations"
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+const migrateUsage = `usage: go-blog-api [-config file] migrate <command>
+
+commands:
+  up            apply every pending migration
+  down [steps]  roll back the newest migrations, one by default
+  status        list the migrations and whether they have been applied
+  create <name> write an empty migration into ` + migrations.Dir
+
+// runMigrate runs the migrate subcommand. Only the database settings are needed, so the rest
+// of the configuration isn't validated.
+func runMigrate(configPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+
+		path, err := migrations.Create(migrations.Dir, args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Created", path)
+		return nil
+	}
+
+	cfg, err := config.Read(configPath)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.Database.Validate(); err != nil {
+		return err
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(db)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Nothing to apply")
+		}
+
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.New("The amount of steps should be a positive integer")
+			}
+		}
+
+		rolledBack, err := migrations.Down(db, steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+
+		if err == nil && len(rolledBack) == 0 {
+			fmt.Println("Nothing to roll back")
+		}
+
+		return err
+	case "status":
+		states, err := migrations.Status(db)
+		if err != nil {
+			return err
+		}
+
+		for _, state := range states {
+			status := "pending"
+			if state.Applied {
+				status = "applied " + state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Printf("%04d_%-30s %s\n", state.Version, state.Name, status)
+		}
+
+		return nil
+	}
+
+	return errors.New(migrateUsage)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nireo/go-blog-api/database"
+	"github.com/nireo/go-blog-api/database/models"
+	"github.com/nireo/go-blog-api/database/store"
+	"github.com/nireo/go-blog-api/lib/config"
+)
+
+const roleUsage = `usage: go-blog-api [-config file] role <username> <role>
+
+Gives the user a role, which is one of user, moderator or admin. The first admin has to be
+created this way, later ones can be promoted through the admin api.`
+
+// runRole runs the role subcommand. Like migrate, it only needs the database settings.
+func runRole(configPath string, args []string) error {
+	if len(args) != 2 {
+		return errors.New(roleUsage)
+	}
+
+	username, role := args[0], args[1]
+	if !models.ValidRole(role) {
+		return errors.New(roleUsage)
+	}
+
+	cfg, err := config.Read(configPath)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.Database.Validate(); err != nil {
+		return err
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	users := store.NewGormStores(db).Users
+	user, err := users.FindByUsername(username)
+	if err != nil {
+		return fmt.Errorf("Could not find user %s: %v", username, err)
+	}
+
+	if err := users.UpdateRole(&user, role); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now %s\n", user.Username, role)
+	return nil
+}